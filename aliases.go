@@ -0,0 +1,152 @@
+/*
+Tag aliases.
+
+An alias is another name for a tag, like pg for postgres. Looking up an alias in the
+tag cache finds the canonical tag, so the answer always shows the canonical name, and
+tagging, untagging or dropping an alias acts on the canonical tag. Aliases belong to
+the tag rather than to a component; they are deleted along with their tag.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"errors"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TagAlias is another name for the tag TagName
+type TagAlias struct {
+	ID      int
+	Name    string `gorm:"type:varchar(50)"`
+	TagName string `gorm:"type:varchar(50)"`
+}
+
+// AddAlias adds an alias to its tag
+func (s *GormStore) AddAlias(a TagAlias) error {
+	if len(a.Name) > MAX_TAG_LENGTH {
+		return ErrTagTooLong
+	}
+	var count int
+	if err := s.db.Model(&Tag{}).Where("name = ?", a.TagName).Count(&count).Error; err != nil {
+		return s.fail("query tag", err)
+	}
+	if count == 0 {
+		return ErrNoTag
+	}
+	if err := s.db.Model(&Tag{}).Where("name = ?", a.Name).Count(&count).Error; err != nil {
+		return s.fail("query tag", err)
+	}
+	if count != 0 {
+		return ErrAliasTaken
+	}
+	if err := s.db.Model(&TagAlias{}).Where("name = ?", a.Name).Count(&count).Error; err != nil {
+		return s.fail("query alias", err)
+	}
+	if count != 0 {
+		return ErrAliasTaken
+	}
+	if err := s.db.Create(&a).Error; err != nil {
+		return s.fail("create alias", err)
+	}
+	log.WithFields(log.Fields{"alias": a.Name, "tag": a.TagName}).Info("added alias to the database")
+	return nil
+}
+
+// RemoveAlias removes an alias, returning ErrNoAlias if there is none by the name
+func (s *GormStore) RemoveAlias(name string) error {
+	res := s.db.Where("name = ?", name).Delete(&TagAlias{})
+	if res.Error != nil {
+		return s.fail("remove alias", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return ErrNoAlias
+	}
+	log.WithField("alias", name).Info("removed alias from the database")
+	return nil
+}
+
+// tagAliases returns the sorted aliases of every tag, keyed by tag name
+func (s *GormStore) tagAliases() (map[string][]string, error) {
+	var aliases []TagAlias
+	if err := s.db.Order("name").Find(&aliases).Error; err != nil {
+		return nil, s.fail("query aliases", err)
+	}
+	byTag := make(map[string][]string)
+	for _, a := range aliases {
+		byTag[a.TagName] = append(byTag[a.TagName], a.Name)
+	}
+	return byTag, nil
+}
+
+// canonicalTag returns the tag an alias belongs to, or n if it is not an alias
+func (s *GormStore) canonicalTag(n string) (string, error) {
+	var aliases []TagAlias
+	if err := s.db.Where("name = ?", n).Find(&aliases).Error; err != nil {
+		return n, s.fail("query alias", err)
+	}
+	if len(aliases) == 0 {
+		return n, nil
+	}
+	return aliases[0].TagName, nil
+}
+
+// AddAlias adds an alias to its tag
+func (s *MemStore) AddAlias(a TagAlias) error {
+	if len(a.Name) > MAX_TAG_LENGTH {
+		return ErrTagTooLong
+	}
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.tags[a.TagName]; !ok {
+		return ErrNoTag
+	}
+	if _, ok := s.tags[a.Name]; ok {
+		return ErrAliasTaken
+	}
+	if _, ok := s.aliases[a.Name]; ok {
+		return ErrAliasTaken
+	}
+	s.aliases[a.Name] = a.TagName
+	return nil
+}
+
+// RemoveAlias removes an alias, returning ErrNoAlias if there is none by the name
+func (s *MemStore) RemoveAlias(name string) error {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.aliases[name]; !ok {
+		return ErrNoAlias
+	}
+	delete(s.aliases, name)
+	return nil
+}
+
+// tagAliases returns the sorted aliases of a tag
+func (s *MemStore) tagAliases(n string) (aliases []string) {
+	for alias, tag := range s.aliases {
+		if tag == n {
+			aliases = append(aliases, alias)
+		}
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+// dropAliases removes every alias of a tag
+func (s *MemStore) dropAliases(n string) {
+	for alias, tag := range s.aliases {
+		if tag == n {
+			delete(s.aliases, alias)
+		}
+	}
+}
+
+// ErrNoAlias is returned if there is no alias by the name
+var ErrNoAlias = errors.New("No alias exists for this word")
+
+// ErrAliasTaken is returned if an alias is already a tag or an alias
+var ErrAliasTaken = errors.New("This word is already a tag or an alias")
@@ -0,0 +1,195 @@
+/*
+Anchors of a component beyond the primary anchor.
+
+The primary anchor is kept in Component.AnchorSlackID. Everyone else who anchors a
+component - a backup, an engineering contact and so on - is a ComponentAnchor with a
+free-form role. All anchors of a component are owners of it, see permissions.go.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+)
+
+// ComponentAnchor is a person anchoring a component in a role other than primary
+type ComponentAnchor struct {
+	ID            int
+	ComponentChan string `gorm:"type:varchar(20)"`
+	SlackID       string `gorm:"type:varchar(20)"`
+	Role          string `gorm:"type:varchar(20)"`
+}
+
+// anchor roles. The primary anchor is not stored as a ComponentAnchor
+const (
+	anchorPrimary = "primary"
+	anchorBackup  = "backup"
+)
+
+// MAX_ROLE_LENGTH should be length of the anchor role varchar in db
+const MAX_ROLE_LENGTH = 20
+
+// anchorEntry returns the audit entry value for an anchor
+func anchorEntry(a ComponentAnchor) string {
+	return a.SlackID + " " + a.Role
+}
+
+// entryAnchor returns the anchor described by an audit entry for adding or removing
+// an anchor
+func entryAnchor(e AuditEntry) ComponentAnchor {
+	v := e.After
+	if e.Action == actionAnchorRemove {
+		v = e.Before
+	}
+	a := ComponentAnchor{ComponentChan: e.ComponentChan}
+	if f := strings.Fields(v); len(f) == 2 {
+		a.SlackID, a.Role = f[0], f[1]
+	}
+	return a
+}
+
+// anchorsByRole groups anchors by role. The roles are returned sorted, with backup first
+func anchorsByRole(anchors []ComponentAnchor) (roles []string, byRole map[string][]string) {
+	byRole = make(map[string][]string)
+	for _, a := range anchors {
+		if _, ok := byRole[a.Role]; !ok {
+			roles = append(roles, a.Role)
+		}
+		byRole[a.Role] = append(byRole[a.Role], a.SlackID)
+	}
+	sort.Slice(roles, func(i, j int) bool {
+		if roles[i] == anchorBackup || roles[j] == anchorBackup {
+			return roles[i] == anchorBackup
+		}
+		return roles[i] < roles[j]
+	})
+	return roles, byRole
+}
+
+// anchorIDs returns everyone anchoring the component in any role, through its
+// rotation or through an active override
+func (c Component) anchorIDs() []string {
+	ids := []string{c.AnchorSlackID}
+	for _, a := range c.Anchors {
+		ids = append(ids, a.SlackID)
+	}
+	for _, id := range c.scheduledAnchors() {
+		found := false
+		for _, known := range ids {
+			found = found || known == id
+		}
+		if !found {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// AddComponentAnchor adds an anchor to a component, returning ErrAnchorExists if the
+// user already anchors it
+func (s *GormStore) AddComponentAnchor(a ComponentAnchor) error {
+	if len(a.Role) > MAX_ROLE_LENGTH {
+		return ErrRoleTooLong
+	}
+	if _, err := s.findComponent(a.ComponentChan); err != nil {
+		return err
+	}
+	var count int
+	if err := s.db.Model(&ComponentAnchor{}).Where("component_chan = ? AND slack_id = ?", a.ComponentChan, a.SlackID).Count(&count).Error; err != nil {
+		return s.fail("query component anchors", err)
+	}
+	if count != 0 {
+		return ErrAnchorExists
+	}
+	if err := s.db.Create(&a).Error; err != nil {
+		return s.fail("add component anchor", err)
+	}
+	log.WithFields(log.Fields{"anchor": a.SlackID, "role": a.Role, "component": a.ComponentChan}).Info("added anchor to component")
+	return nil
+}
+
+// RemoveComponentAnchor removes an anchor from a component, returning ErrNoAnchor if
+// the user does not anchor it
+func (s *GormStore) RemoveComponentAnchor(componentChan, slackID string) error {
+	res := s.db.Where("component_chan = ? AND slack_id = ?", componentChan, slackID).Delete(&ComponentAnchor{})
+	if res.Error != nil {
+		return s.fail("remove component anchor", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return ErrNoAnchor
+	}
+	log.WithFields(log.Fields{"anchor": slackID, "component": componentChan}).Info("removed anchor from component")
+	return nil
+}
+
+// loadAnchors loads the anchors of the components selected by db into d
+func (s *GormStore) loadAnchors(db *gorm.DB, d componentDetails) error {
+	var anchors []ComponentAnchor
+	if err := db.Order("id").Find(&anchors).Error; err != nil {
+		return s.fail("query component anchors", err)
+	}
+	for _, a := range anchors {
+		d.anchors[a.ComponentChan] = append(d.anchors[a.ComponentChan], a)
+	}
+	return nil
+}
+
+// AddComponentAnchor adds an anchor to a component, returning ErrAnchorExists if the
+// user already anchors it
+func (s *MemStore) AddComponentAnchor(a ComponentAnchor) error {
+	if len(a.Role) > MAX_ROLE_LENGTH {
+		return ErrRoleTooLong
+	}
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.components[a.ComponentChan]; !ok {
+		return ErrNoComponent
+	}
+	for _, anchor := range s.anchors {
+		if anchor.ComponentChan == a.ComponentChan && anchor.SlackID == a.SlackID {
+			return ErrAnchorExists
+		}
+	}
+	a.ID = len(s.anchors) + 1
+	s.anchors = append(s.anchors, a)
+	return nil
+}
+
+// RemoveComponentAnchor removes an anchor from a component, returning ErrNoAnchor if
+// the user does not anchor it
+func (s *MemStore) RemoveComponentAnchor(componentChan, slackID string) error {
+	s.Lock()
+	defer s.Unlock()
+	for i, a := range s.anchors {
+		if a.ComponentChan == componentChan && a.SlackID == slackID {
+			s.anchors = append(s.anchors[:i:i], s.anchors[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNoAnchor
+}
+
+func (s *MemStore) componentAnchors(componentChan string) (anchors []ComponentAnchor) {
+	for _, a := range s.anchors {
+		if a.ComponentChan == componentChan {
+			anchors = append(anchors, a)
+		}
+	}
+	return anchors
+}
+
+// ErrAnchorExists is returned if a user already anchors the component
+var ErrAnchorExists = errors.New("User already anchors this component")
+
+// ErrNoAnchor is returned if a user does not anchor the component
+var ErrNoAnchor = errors.New("User does not anchor this component")
+
+// ErrRoleTooLong is returned if an anchor role is too long for the DB
+var ErrRoleTooLong = errors.New("Anchor role too long")
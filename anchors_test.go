@@ -0,0 +1,83 @@
+/*
+Tests for the anchors of a component and their roles.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nlopes/slack"
+)
+
+func TestAnchorCommands(t *testing.T) {
+	newTestCache(t, map[string][]string{"kafka": {"C1"}})
+	stub := newSlackStub(nil)
+	defer stub.Close()
+
+	// each command runs after the ones before it, by the primary anchor UC1
+	tests := []struct {
+		text    string
+		message string
+		anchors []string // the anchors of C1 afterwards, as "ID role"
+	}{
+		{"<@B> set <#C1|c1> anchor add <@U2>", "Successfully added <@U2> as backup anchor for <#C1|c1>", []string{"U2 backup"}},
+		{"<@B> set <#C1|c1> anchor ADD <@U3> role Engineering", "Successfully added <@U3> as engineering anchor for <#C1|c1>", []string{"U2 backup", "U3 engineering"}},
+		{"<@B> set <#C1|c1> anchor add <@U4> role", "Successfully added <@U4> as backup anchor for <#C1|c1>", []string{"U2 backup", "U3 engineering", "U4 backup"}},
+		{"<@B> set <#C1|c1> anchor add <@U5> role Primary", primaryAnchorRole, []string{"U2 backup", "U3 engineering", "U4 backup"}},
+		{"<@B> set <#C1|c1> anchor add <@U5> role " + strings.Repeat("x", MAX_ROLE_LENGTH+1), roleTooLong, []string{"U2 backup", "U3 engineering", "U4 backup"}},
+		{"<@B> set <#C1|c1> anchor add <@U2> role engineering", anchorExists, []string{"U2 backup", "U3 engineering", "U4 backup"}},
+		{"<@B> set <#C1|c1> anchor add <@UC1>", anchorExists, []string{"U2 backup", "U3 engineering", "U4 backup"}},
+		{"<@B> set <#C1|c1> anchor remove <@U2>", "Successfully removed <@U2> as backup anchor for <#C1|c1>", []string{"U3 engineering", "U4 backup"}},
+		{"<@B> set <#C1|c1> anchor remove <@U2>", noAnchor, []string{"U3 engineering", "U4 backup"}},
+		{"<@B> set <#C1|c1> anchor remove <@UC1>", removePrimaryAnchor, []string{"U3 engineering", "U4 backup"}},
+	}
+	for _, tt := range tests {
+		ev := &slack.MessageEvent{Msg: slack.Msg{User: "UC1", Channel: "D1", Text: tt.text}}
+		handleCommand(ev, strings.Fields(tt.text))
+		if got := stub.messages(); !reflect.DeepEqual(got, []string{tt.message}) {
+			t.Errorf("%q said %q, want %q", tt.text, got, tt.message)
+		}
+		c, err := store.GetAnchor("C1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var anchors []string
+		for _, a := range c.Anchors {
+			anchors = append(anchors, anchorEntry(a))
+		}
+		if c.AnchorSlackID != "UC1" || !reflect.DeepEqual(anchors, tt.anchors) {
+			t.Errorf("after %q, C1 is anchored by %s and %q, want %q", tt.text, c.AnchorSlackID, anchors, tt.anchors)
+		}
+	}
+
+	// the cache is reloaded, so the roles are shown with the tag
+	if tags := cache.Find("kafka"); len(tags) != 1 || anchorsFmt(tags[0].component()) != "*anchor:* <@UC1>, *backup:* <@U4>, *engineering:* <@U3>" {
+		t.Errorf("the cache has %+v", tags)
+	}
+}
+
+func TestAnchorsByRole(t *testing.T) {
+	anchors := []ComponentAnchor{
+		{SlackID: "U1", Role: "support"},
+		{SlackID: "U2", Role: anchorBackup},
+		{SlackID: "U3", Role: "engineering"},
+		{SlackID: "U4", Role: "support"},
+		{SlackID: "U5", Role: anchorBackup},
+	}
+	roles, byRole := anchorsByRole(anchors)
+	if want := []string{anchorBackup, "engineering", "support"}; !reflect.DeepEqual(roles, want) {
+		t.Errorf("roles = %q, want %q", roles, want)
+	}
+	want := map[string][]string{anchorBackup: {"U2", "U5"}, "engineering": {"U3"}, "support": {"U1", "U4"}}
+	if !reflect.DeepEqual(byRole, want) {
+		t.Errorf("byRole = %q, want %q", byRole, want)
+	}
+	if roles, byRole := anchorsByRole(nil); len(roles) != 0 || len(byRole) != 0 {
+		t.Errorf("anchorsByRole(nil) = %q, %q", roles, byRole)
+	}
+}
@@ -0,0 +1,313 @@
+/*
+Audit trail for changes to tags, components, anchors and playbooks.
+
+Every command which changes routing data is recorded as a Change: who ran it, where,
+and the command text. A Change holds one AuditEntry per component or tag it touched,
+with the value before and after the change. For tag entries, the before and after
+values are the component channels the tag was attached to. When a tag is removed from
+a component, the before value lists its aliases on a second line, since they are
+removed along with the last component and a revert has to bring them back.
+
+The trail is append-only - the store has no way to update or delete entries.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+)
+
+// Change is a single command which changed routing data
+type Change struct {
+	ID           int
+	CreatedAt    time.Time
+	ActorSlackID string       `gorm:"type:varchar(20)"`
+	Channel      string       `gorm:"type:varchar(20)"`
+	Command      string       `gorm:"type:text"`
+	RevertOf     int          `gorm:"not null;default:0"` // ID of the change this one reverts, if any
+	Entries      []AuditEntry `gorm:"foreignkey:ChangeID"`
+}
+
+// AuditEntry is the change made to a single component or tag
+type AuditEntry struct {
+	ID            int
+	ChangeID      int    `gorm:"index"`
+	Action        string `gorm:"type:varchar(20)"`
+	ComponentChan string `gorm:"type:varchar(20);index"`
+	Tag           string `gorm:"type:varchar(50);index"`
+	Before        string `gorm:"type:text"`
+	After         string `gorm:"type:text"`
+}
+
+// AuditLog stores the audit trail
+type AuditLog interface {
+	// RecordChange appends a change and its entries to the audit trail
+	RecordChange(c *Change) error
+	// History returns the most recent changes with an entry matching the non-empty
+	// ComponentChan and Tag of filter, newest first
+	History(filter AuditEntry, limit int) ([]Change, error)
+	// GetChange returns a change with its entries
+	GetChange(id int) (Change, error)
+	// LastChange returns the most recent change by the actor which is not a revert,
+	// has not been reverted and was not denied
+	LastChange(actorSlackID string) (Change, error)
+	// IsReverted returns true if a change has been reverted
+	IsReverted(id int) (bool, error)
+}
+
+// actions recorded in the audit trail
+const (
+	actionAddComponent   = "add component"
+	actionTag            = "tag"
+	actionUntag          = "untag"
+	actionDrop           = "drop"
+	actionAnchor         = "anchor"
+	actionAnchorAdd      = "add anchor"
+	actionAnchorRemove   = "remove anchor"
+	actionPlaybook       = "playbook"
+	actionPlaybookAdd    = "add playbook"
+	actionPlaybookRemove = "remove playbook"
+	actionPlaybookURL    = "playbook url"
+	actionGrant          = "grant"
+	actionRevoke         = "revoke"
+	actionRotation       = "rotation"
+	actionOverride       = "override"
+	actionAway           = "away"
+	actionAlias          = "alias"
+	actionUnalias        = "unalias"
+	actionName           = infoName
+	actionDescription    = infoDescription
+	actionArea           = infoArea
+	actionLink           = "link"
+	actionDenied         = "denied" // a command refused for lack of permission
+)
+
+// number of changes shown by the history command
+const historyLimit = 10
+
+// newChange starts a change made by the user in the response channel
+func newChange(r response, command string) *Change {
+	return &Change{ActorSlackID: r.user, Channel: r.channel, Command: command}
+}
+
+// add adds an entry to the change
+func (c *Change) add(action, componentChan, tag, before, after string) {
+	c.Entries = append(c.Entries, AuditEntry{
+		Action:        action,
+		ComponentChan: componentChan,
+		Tag:           tag,
+		Before:        before,
+		After:         after,
+	})
+}
+
+// recordChange writes the change to the audit trail if anything was changed. The
+// change itself has already been made, so failures are only logged
+func recordChange(c *Change) {
+	if len(c.Entries) == 0 {
+		return
+	}
+	if c.CreatedAt.IsZero() {
+		c.CreatedAt = time.Now()
+	}
+	if err := store.RecordChange(c); err != nil {
+		log.WithFields(log.Fields{"actor": c.ActorSlackID, "command": c.Command, "ERROR": err}).Error("Could not record change in the audit trail")
+	}
+}
+
+// RecordChange appends a change and its entries to the audit trail
+func (s *GormStore) RecordChange(c *Change) error {
+	if err := s.db.Create(c).Error; err != nil {
+		return s.fail("record change", err)
+	}
+	return nil
+}
+
+// History returns the most recent changes with an entry matching the non-empty
+// ComponentChan and Tag of filter, newest first
+func (s *GormStore) History(filter AuditEntry, limit int) ([]Change, error) {
+	var (
+		ids     []int
+		changes []Change
+	)
+	where := AuditEntry{ComponentChan: filter.ComponentChan, Tag: filter.Tag}
+	// the limit counts changes, not their entries
+	rows, err := s.db.Model(&AuditEntry{}).Where(&where).Select("change_id").Group("change_id").Order("MAX(id) desc").Limit(limit).Rows()
+	if err != nil {
+		return nil, s.fail("query audit entries", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, s.fail("query audit entries", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, s.fail("query audit entries", err)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	if err := s.db.Preload("Entries").Where("id in (?)", ids).Order("id desc").Find(&changes).Error; err != nil {
+		return nil, s.fail("query changes", err)
+	}
+	return changes, nil
+}
+
+// GetChange returns a change with its entries
+func (s *GormStore) GetChange(id int) (Change, error) {
+	var c Change
+	if err := s.db.Preload("Entries").First(&c, id).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return c, ErrNoChange
+		}
+		return c, s.fail("query change", err)
+	}
+	return c, nil
+}
+
+// LastChange returns the most recent change by the actor which is not a revert,
+// has not been reverted and was not denied
+func (s *GormStore) LastChange(actorSlackID string) (Change, error) {
+	var c Change
+	err := s.db.Preload("Entries").
+		Where("actor_slack_id = ? AND revert_of = 0", actorSlackID).
+		Where("id NOT IN (SELECT revert_of FROM changes WHERE revert_of <> 0)").
+		Where("id IN (SELECT change_id FROM audit_entries WHERE action <> ?)", actionDenied).
+		Order("id desc").First(&c).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return c, ErrNoChange
+		}
+		return c, s.fail("query last change", err)
+	}
+	return c, nil
+}
+
+// IsReverted returns true if a change has been reverted
+func (s *GormStore) IsReverted(id int) (bool, error) {
+	var count int
+	if err := s.db.Model(&Change{}).Where("revert_of = ?", id).Count(&count).Error; err != nil {
+		return false, s.fail("query reverts", err)
+	}
+	return count != 0, nil
+}
+
+// RecordChange appends a change and its entries to the audit trail
+func (s *MemStore) RecordChange(c *Change) error {
+	s.Lock()
+	defer s.Unlock()
+	c.ID = len(s.changes) + 1
+	for i := range c.Entries {
+		c.Entries[i].ChangeID = c.ID
+	}
+	s.changes = append(s.changes, *c)
+	return nil
+}
+
+// History returns the most recent changes with an entry matching the non-empty
+// ComponentChan and Tag of filter, newest first
+func (s *MemStore) History(filter AuditEntry, limit int) (changes []Change, err error) {
+	s.Lock()
+	defer s.Unlock()
+	for i := len(s.changes) - 1; i >= 0 && len(changes) < limit; i-- {
+		for _, e := range s.changes[i].Entries {
+			if (filter.ComponentChan == "" || e.ComponentChan == filter.ComponentChan) && (filter.Tag == "" || e.Tag == filter.Tag) {
+				changes = append(changes, s.changes[i])
+				break
+			}
+		}
+	}
+	return changes, nil
+}
+
+// GetChange returns a change with its entries
+func (s *MemStore) GetChange(id int) (Change, error) {
+	s.Lock()
+	defer s.Unlock()
+	if id < 1 || id > len(s.changes) {
+		return Change{}, ErrNoChange
+	}
+	return s.changes[id-1], nil
+}
+
+// LastChange returns the most recent change by the actor which is not a revert,
+// has not been reverted and was not denied
+func (s *MemStore) LastChange(actorSlackID string) (Change, error) {
+	s.Lock()
+	defer s.Unlock()
+	reverted := make(map[int]bool)
+	for _, c := range s.changes {
+		reverted[c.RevertOf] = true
+	}
+	for i := len(s.changes) - 1; i >= 0; i-- {
+		c := s.changes[i]
+		if c.ActorSlackID == actorSlackID && c.RevertOf == 0 && !reverted[c.ID] && !c.denied() {
+			return c, nil
+		}
+	}
+	return Change{}, ErrNoChange
+}
+
+// IsReverted returns true if a change has been reverted
+func (s *MemStore) IsReverted(id int) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	for _, c := range s.changes {
+		if c.RevertOf == id {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// denied returns true if the change only records denied attempts
+func (c Change) denied() bool {
+	for _, e := range c.Entries {
+		if e.Action != actionDenied {
+			return false
+		}
+	}
+	return true
+}
+
+// tagChans returns the component channels a tag is attached to in the cache
+func tagChans(tag string) string {
+	var chans []string
+	for _, t := range cache.Find(tag) {
+		chans = append(chans, t.ComponentChan)
+	}
+	return strings.Join(chans, ",")
+}
+
+// tagEntry returns the audit entry value for a tag about to be removed from a
+// component: its component channels, and its aliases on a second line if it has any
+func tagEntry(tag string) string {
+	entry := tagChans(tag)
+	if tags := cache.Find(tag); len(tags) != 0 && len(tags[0].Aliases) != 0 {
+		entry += "\n" + strings.Join(tags[0].Aliases, ",")
+	}
+	return entry
+}
+
+// entryTag returns the component channels and aliases of a tag described by an audit
+// entry value
+func entryTag(v string) (chans string, aliases []string) {
+	f := strings.SplitN(v, "\n", 2)
+	if len(f) == 2 {
+		aliases = splitList(f[1])
+	}
+	return f[0], aliases
+}
+
+// ErrNoChange is returned if there is no matching change in the audit trail
+var ErrNoChange = errors.New("No change found in the audit trail")
@@ -0,0 +1,102 @@
+/*
+Tests for the audit trail.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nlopes/slack"
+)
+
+func TestHistory(t *testing.T) {
+	g, done := newTestGormStore(t)
+	defer done()
+	for name, s := range map[string]Store{"mem": NewMemStore(), "sqlite": g} {
+		t.Run(name, func(t *testing.T) {
+			// 13 changes of 3 to 5 entries each, all about kafka, every other one about C2
+			for i := 0; i < 13; i++ {
+				c := &Change{ActorSlackID: "U1", Command: "tag"}
+				for j := 0; j < 3+i%3; j++ {
+					c.add(actionTag, "C1", "kafka", "", "C1")
+				}
+				if i%2 == 0 {
+					c.add(actionTag, "C2", "kafka", "", "C2")
+				}
+				if err := s.RecordChange(c); err != nil {
+					t.Fatal(err)
+				}
+			}
+			tests := []struct {
+				filter AuditEntry
+				limit  int
+				ids    []int
+			}{
+				{AuditEntry{Tag: "kafka"}, 10, []int{13, 12, 11, 10, 9, 8, 7, 6, 5, 4}},
+				{AuditEntry{ComponentChan: "C1", Tag: "kafka"}, 3, []int{13, 12, 11}},
+				{AuditEntry{ComponentChan: "C2"}, 20, []int{13, 11, 9, 7, 5, 3, 1}},
+				{AuditEntry{Tag: "pg"}, 10, nil},
+			}
+			for _, tt := range tests {
+				h, err := s.History(tt.filter, tt.limit)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var ids []int
+				for _, c := range h {
+					ids = append(ids, c.ID)
+					if want := 3 + (c.ID-1)%3 + c.ID%2; len(c.Entries) != want {
+						t.Errorf("change %d has %d entries, want %d", c.ID, len(c.Entries), want)
+					}
+				}
+				if len(ids) != len(tt.ids) {
+					t.Errorf("History(%+v, %d) = changes %v, want %v", tt.filter, tt.limit, ids, tt.ids)
+					continue
+				}
+				for i := range ids {
+					if ids[i] != tt.ids[i] {
+						t.Errorf("History(%+v, %d) = changes %v, want %v", tt.filter, tt.limit, ids, tt.ids)
+						break
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestHistoryCommand(t *testing.T) {
+	c := newTestCache(t, map[string][]string{"kafka": {"C1"}})
+	stub := newSlackStub(nil)
+	defer stub.Close()
+	if _, err := c.AddAlias("event bus", "kafka"); err != nil {
+		t.Fatal(err)
+	}
+	change := &Change{ActorSlackID: "UC1", Command: "tag"}
+	change.add(actionTag, "C1", "kafka", "", "C1")
+	if err := store.RecordChange(change); err != nil {
+		t.Fatal(err)
+	}
+	h, err := store.History(AuditEntry{Tag: "kafka"}, historyLimit)
+	if err != nil || len(h) != 1 {
+		t.Fatalf("History = %+v, %v", h, err)
+	}
+
+	// the tag is cleaned up and an alias is resolved, as when tagging
+	for _, text := range []string{"<@B> history tag kafka", "<@B> history tag KAFKA,", "<@B> history tag Event  Bus"} {
+		ev := &slack.MessageEvent{Msg: slack.Msg{User: "UX", Channel: "D1", Text: text}}
+		handleCommand(ev, strings.Fields(text))
+		if got := stub.messages(); !reflect.DeepEqual(got, []string{historyFmt(h)}) {
+			t.Errorf("%q said %q", text, got)
+		}
+	}
+	text := "<@B> history tag redis"
+	handleCommand(&slack.MessageEvent{Msg: slack.Msg{User: "UX", Channel: "D1", Text: text}}, strings.Fields(text))
+	if got := stub.messages(); !reflect.DeepEqual(got, []string{noHistory}) {
+		t.Errorf("%q said %q", text, got)
+	}
+}
@@ -0,0 +1,321 @@
+/*
+Anchors who are away.
+
+Anyone can mark themselves away until a date with @bot away until 2026-11-01, and
+back again with @bot back. Anchors are also marked away automatically while their
+slack status emoji is one of the configured away emoji, or while they have paused
+notifications with do not disturb - a background job checks every anchor of a tag
+in the cache every awaySweepInterval. Each source is kept as its own absence, so
+clearing a slack status does not end an absence declared with the bot.
+
+While the anchor on duty for a component is away, its backup anchors are shown
+instead, then the primary anchor, and the support channel if all of them are away,
+with a note that the anchor is away.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/nlopes/slack"
+	log "github.com/sirupsen/logrus"
+)
+
+// Absence marks a slack user away until a given time
+type Absence struct {
+	ID        int
+	CreatedAt time.Time
+	SlackID   string `gorm:"type:varchar(20)"`
+	Source    string `gorm:"type:varchar(20)"`
+	Until     time.Time
+}
+
+// sources of an absence
+const (
+	awayManual = "manual" // declared with the away command
+	awayStatus = "status" // slack status emoji
+	awayDND    = "dnd"    // slack do not disturb
+)
+
+// how often slack statuses are checked. An away status without an expiration is
+// renewed on every check, so it ends at most awayRenewal after the status is cleared
+const (
+	awaySweepInterval = 15 * time.Minute
+	awayRenewal       = 2 * awaySweepInterval
+)
+
+// awayEmoji are the slack status emoji which mark someone away, see config.go
+var awayEmoji = []string{":palm_tree:", ":airplane:", ":face_with_thermometer:"}
+
+// AwayStore stores absences. The active absences of a component's anchors are
+// loaded with components and tags
+type AwayStore interface {
+	// SetAway stores an absence, replacing any absence of the user from the same source
+	SetAway(a Absence) error
+	// ClearAway removes the absence of the user from the source, returning ErrNotAway
+	// if there is none
+	ClearAway(slackID, source string) error
+	// GetAway returns the active absence of the user from the source, returning
+	// ErrNotAway if there is none
+	GetAway(slackID, source string) (Absence, error)
+}
+
+// away returns the absence of the anchor if they are away at now
+func (c Component) away(anchor string, now time.Time) (Absence, bool) {
+	a, ok := c.Away[anchor]
+	return a, ok && now.Before(a.Until)
+}
+
+// contact returns who to contact about the component at now: the anchor on duty or,
+// if they are away, the first backup who isn't, then the primary anchor. The absence
+// of the anchor on duty is returned if they are away. If everyone is away, the anchor
+// is empty and the support channel should be contacted instead
+func (c Component) contact(now time.Time) (anchor string, away *Absence) {
+	onDuty := currentAnchor(c.AnchorSlackID, c.Rotation, c.Overrides, now)
+	a, ok := c.away(onDuty, now)
+	if !ok {
+		return onDuty, nil
+	}
+	var fallbacks []string
+	for _, backup := range c.Anchors {
+		if backup.Role == anchorBackup {
+			fallbacks = append(fallbacks, backup.SlackID)
+		}
+	}
+	for _, f := range append(fallbacks, c.AnchorSlackID) {
+		if _, ok := c.away(f, now); !ok && f != onDuty && f != "" {
+			return f, &a
+		}
+	}
+	return "", &a
+}
+
+// latestAbsences returns the latest ending absence of each of the anchors
+func latestAbsences(absences []Absence, anchors []string) map[string]Absence {
+	var byUser map[string]Absence
+	for _, a := range absences {
+		for _, anchor := range anchors {
+			if a.SlackID != anchor {
+				continue
+			}
+			if byUser == nil {
+				byUser = make(map[string]Absence)
+			}
+			if a.Until.After(byUser[anchor].Until) {
+				byUser[anchor] = a
+			}
+		}
+	}
+	return byUser
+}
+
+// statusAway returns until when a slack status marks the user away
+func statusAway(p slack.UserProfile, now time.Time) (time.Time, bool) {
+	for _, emoji := range awayEmoji {
+		if p.StatusEmoji != emoji {
+			continue
+		}
+		if p.StatusExpiration > 0 {
+			return time.Unix(int64(p.StatusExpiration), 0).UTC(), true
+		}
+		return now.Add(awayRenewal).UTC(), true
+	}
+	return time.Time{}, false
+}
+
+// dndAway returns until when do not disturb marks the user away. Only paused
+// notifications count - the nightly do not disturb hours do not
+func dndAway(d slack.DNDStatus, now time.Time) (time.Time, bool) {
+	if !d.SnoozeEnabled {
+		return time.Time{}, false
+	}
+	until := time.Unix(int64(d.SnoozeEndTime), 0).UTC()
+	return until, now.Before(until)
+}
+
+// sweepAway checks the slack status of every anchor every awaySweepInterval
+func sweepAway() {
+	for range time.Tick(awaySweepInterval) {
+		checkAway(time.Now())
+	}
+}
+
+// checkAway marks anchors away while their slack status or do not disturb says so,
+// and back once it doesn't
+func checkAway(now time.Time) {
+	changed := false
+	for _, id := range cache.Anchors() {
+		if isUserGroup(id) {
+			continue
+		}
+		user, err := sc.GetUserInfo(id)
+		if err != nil {
+			log.WithFields(log.Fields{"user": id, "ERROR": err}).Error("Could not check slack status")
+			continue
+		}
+		until, ok := statusAway(user.Profile, now)
+		changed = setDetectedAway(id, awayStatus, until, ok) || changed
+		dnd, err := sc.GetDNDInfo(&id)
+		if err != nil {
+			log.WithFields(log.Fields{"user": id, "ERROR": err}).Error("Could not check do not disturb")
+			continue
+		}
+		until, ok = dndAway(*dnd, now)
+		changed = setDetectedAway(id, awayDND, until, ok) || changed
+	}
+	if changed {
+		cache.Load()
+	}
+}
+
+// setDetectedAway stores or clears an absence detected in slack and returns true if
+// anything was written
+func setDetectedAway(slackID, source string, until time.Time, away bool) bool {
+	var err error
+	if away {
+		err = store.SetAway(Absence{SlackID: slackID, Source: source, Until: until})
+	} else if err = store.ClearAway(slackID, source); err == ErrNotAway {
+		return false
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"user": slackID, "source": source, "ERROR": err}).Error("Could not update absence")
+		return false
+	}
+	if away {
+		log.WithFields(log.Fields{"user": slackID, "source": source, "until": until}).Debug("Anchor is away")
+	}
+	return true
+}
+
+// absenceEntry returns the audit entry value for an absence, "" for none
+func absenceEntry(a *Absence) string {
+	if a == nil {
+		return ""
+	}
+	return a.SlackID + " " + a.Until.UTC().Format(time.RFC3339)
+}
+
+// entryAbsence returns the manual absence described by an audit entry value, nil for none
+func entryAbsence(v string) *Absence {
+	f := strings.Fields(v)
+	if len(f) != 2 {
+		return nil
+	}
+	until, err := time.Parse(time.RFC3339, f[1])
+	if err != nil {
+		return nil
+	}
+	return &Absence{SlackID: f[0], Source: awayManual, Until: until}
+}
+
+// SetAway stores an absence, replacing any absence of the user from the same source
+func (s *GormStore) SetAway(a Absence) error {
+	tx := s.db.Begin()
+	if err := tx.Where("slack_id = ? AND source = ?", a.SlackID, a.Source).Delete(&Absence{}).Error; err != nil {
+		tx.Rollback()
+		return s.fail("replace absence", err)
+	}
+	a.ID = 0
+	if err := tx.Create(&a).Error; err != nil {
+		tx.Rollback()
+		return s.fail("create absence", err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return s.fail("set absence", err)
+	}
+	return nil
+}
+
+// ClearAway removes the absence of the user from the source, returning ErrNotAway if
+// there is none
+func (s *GormStore) ClearAway(slackID, source string) error {
+	res := s.db.Where("slack_id = ? AND source = ?", slackID, source).Delete(&Absence{})
+	if res.Error != nil {
+		return s.fail("clear absence", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotAway
+	}
+	return nil
+}
+
+// GetAway returns the active absence of the user from the source, returning
+// ErrNotAway if there is none
+func (s *GormStore) GetAway(slackID, source string) (Absence, error) {
+	var absences []Absence
+	if err := s.db.Where("slack_id = ? AND source = ? AND until > ?", slackID, source, time.Now().UTC()).Find(&absences).Error; err != nil {
+		return Absence{}, s.fail("query absence", err)
+	}
+	if len(absences) == 0 {
+		return Absence{}, ErrNotAway
+	}
+	return absences[0], nil
+}
+
+// activeAbsences returns the absences of the users which have not ended yet, or of
+// everyone if no users are given
+func (s *GormStore) activeAbsences(slackIDs ...string) ([]Absence, error) {
+	var absences []Absence
+	db := s.db.Where("until > ?", time.Now().UTC())
+	if len(slackIDs) != 0 {
+		db = db.Where("slack_id IN (?)", slackIDs)
+	}
+	if err := db.Find(&absences).Error; err != nil {
+		return nil, s.fail("query absences", err)
+	}
+	return absences, nil
+}
+
+// SetAway stores an absence, replacing any absence of the user from the same source
+func (s *MemStore) SetAway(a Absence) error {
+	s.Lock()
+	defer s.Unlock()
+	s.clearAway(a.SlackID, a.Source)
+	if a.CreatedAt.IsZero() {
+		a.CreatedAt = time.Now()
+	}
+	s.absences = append(s.absences, a)
+	return nil
+}
+
+// ClearAway removes the absence of the user from the source, returning ErrNotAway if
+// there is none
+func (s *MemStore) ClearAway(slackID, source string) error {
+	s.Lock()
+	defer s.Unlock()
+	if !s.clearAway(slackID, source) {
+		return ErrNotAway
+	}
+	return nil
+}
+
+func (s *MemStore) clearAway(slackID, source string) bool {
+	for i, a := range s.absences {
+		if a.SlackID == slackID && a.Source == source {
+			s.absences = append(s.absences[:i:i], s.absences[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// GetAway returns the active absence of the user from the source, returning
+// ErrNotAway if there is none
+func (s *MemStore) GetAway(slackID, source string) (Absence, error) {
+	s.Lock()
+	defer s.Unlock()
+	for _, a := range s.absences {
+		if a.SlackID == slackID && a.Source == source && time.Now().Before(a.Until) {
+			return a, nil
+		}
+	}
+	return Absence{}, ErrNotAway
+}
+
+// ErrNotAway is returned if a user has no absence
+var ErrNotAway = errors.New("User is not away")
@@ -0,0 +1,169 @@
+/*
+Tests for anchors who are away.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+func TestContact(t *testing.T) {
+	now := time.Now()
+	away := func(ids ...string) map[string]Absence {
+		m := make(map[string]Absence)
+		for _, id := range ids {
+			m[id] = Absence{SlackID: id, Source: awayManual, Until: now.Add(time.Hour)}
+		}
+		return m
+	}
+	// U8 is on duty through the rotation, U7 is a backup and UD is not
+	c := Component{
+		ComponentChan: "C1",
+		SupportChan:   "S1",
+		AnchorSlackID: "UP",
+		Anchors: []ComponentAnchor{
+			{ComponentChan: "C1", SlackID: "UD", Role: "deputy"},
+			{ComponentChan: "C1", SlackID: "U7", Role: anchorBackup},
+		},
+		Rotation: &Rotation{ComponentChan: "C1", Members: "U8", Period: time.Hour, Start: now.Add(-time.Minute)},
+	}
+	tests := []struct {
+		name   string
+		away   map[string]Absence
+		anchor string
+		absent string // the anchor whose absence is returned, "" for none
+	}{
+		{"on duty", nil, "U8", ""},
+		{"backup while away", away("U8"), "U7", "U8"},
+		{"someone else away", away("U7", "UP"), "U8", ""},
+		{"primary after the backups", away("U8", "U7"), "UP", "U8"},
+		{"everyone away", away("U8", "U7", "UP"), "", "U8"},
+		{"absence ended", map[string]Absence{"U8": {SlackID: "U8", Until: now.Add(-time.Second)}}, "U8", ""},
+	}
+	for _, tt := range tests {
+		c.Away = tt.away
+		anchor, absence := c.contact(now)
+		absent := ""
+		if absence != nil {
+			absent = absence.SlackID
+		}
+		if anchor != tt.anchor || absent != tt.absent {
+			t.Errorf("%s: contact = %q, absence of %q, want %q, absence of %q", tt.name, anchor, absent, tt.anchor, tt.absent)
+		}
+	}
+
+	// the support channel is shown when everyone is away
+	c.Away = away("U8", "U7", "UP")
+	if got := anchorsFmt(c); !strings.HasPrefix(got, "*anchor:* <#S1> ") || !strings.Contains(got, "<@U8>") {
+		t.Errorf("with everyone away, anchorsFmt = %q", got)
+	}
+	c.Rotation, c.Anchors, c.Away = nil, nil, away("UP")
+	if anchor, absence := c.contact(now); anchor != "" || absence == nil || absence.SlackID != "UP" {
+		t.Errorf("with only the primary anchor away, contact = %q, %+v", anchor, absence)
+	}
+}
+
+func TestStatusAway(t *testing.T) {
+	now := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+	expires := now.Add(48 * time.Hour)
+	tests := []struct {
+		name    string
+		profile slack.UserProfile
+		until   time.Time
+		away    bool
+	}{
+		{"away emoji until a time", slack.UserProfile{StatusEmoji: ":palm_tree:", StatusExpiration: int(expires.Unix())}, expires, true},
+		{"away emoji renewed", slack.UserProfile{StatusEmoji: ":airplane:"}, now.Add(awayRenewal), true},
+		{"other emoji", slack.UserProfile{StatusEmoji: ":coffee:", StatusExpiration: int(expires.Unix())}, time.Time{}, false},
+		{"no status", slack.UserProfile{}, time.Time{}, false},
+	}
+	for _, tt := range tests {
+		until, away := statusAway(tt.profile, now)
+		if !until.Equal(tt.until) || away != tt.away {
+			t.Errorf("%s: statusAway = %v, %v, want %v, %v", tt.name, until, away, tt.until, tt.away)
+		}
+	}
+}
+
+func TestDNDAway(t *testing.T) {
+	now := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+	later := int(now.Add(time.Hour).Unix())
+	tests := []struct {
+		name string
+		dnd  slack.DNDStatus
+		away bool
+	}{
+		{"snoozed", slack.DNDStatus{SnoozeInfo: slack.SnoozeInfo{SnoozeEnabled: true, SnoozeEndTime: later}}, true},
+		{"snooze ended", slack.DNDStatus{SnoozeInfo: slack.SnoozeInfo{SnoozeEnabled: true, SnoozeEndTime: int(now.Unix())}}, false},
+		{"nightly hours", slack.DNDStatus{Enabled: true, NextStartTimestamp: int(now.Unix()) - 60, NextEndTimestamp: later}, false},
+		{"off", slack.DNDStatus{}, false},
+	}
+	for _, tt := range tests {
+		if _, away := dndAway(tt.dnd, now); away != tt.away {
+			t.Errorf("%s: dndAway = %v, want %v", tt.name, away, tt.away)
+		}
+	}
+}
+
+func TestCheckAway(t *testing.T) {
+	c := newTestCache(t, map[string][]string{"kafka": {"C1"}, "postgres": {"C2"}, "redis": {"C3"}})
+	stub := newSlackStub(nil)
+	defer stub.Close()
+	now := time.Now().UTC().Truncate(time.Second)
+	stub.profiles = map[string]slack.UserProfile{
+		"UC1": {StatusEmoji: ":palm_tree:"},
+		"UC3": {StatusEmoji: ":coffee:"},
+	}
+	stub.dnd = map[string]slack.DNDStatus{
+		"UC2": {SnoozeInfo: slack.SnoozeInfo{SnoozeEnabled: true, SnoozeEndTime: int(now.Add(time.Hour).Unix())}},
+		"UC3": {Enabled: true},
+	}
+	manual := Absence{SlackID: "UC1", Source: awayManual, Until: now.Add(24 * time.Hour)}
+	if err := store.SetAway(manual); err != nil {
+		t.Fatal(err)
+	}
+
+	checkAway(now)
+	if a, err := store.GetAway("UC1", awayStatus); err != nil || !a.Until.Equal(now.Add(awayRenewal)) {
+		t.Errorf("UC1's status absence = %+v, %v", a, err)
+	}
+	if a, err := store.GetAway("UC2", awayDND); err != nil || !a.Until.Equal(now.Add(time.Hour)) {
+		t.Errorf("UC2's do not disturb absence = %+v, %v", a, err)
+	}
+	for _, source := range []string{awayStatus, awayDND} {
+		if _, err := store.GetAway("UC3", source); err != ErrNotAway {
+			t.Errorf("UC3 is away by %s: %v", source, err)
+		}
+	}
+	if tags := c.Find("postgres"); len(tags) != 1 || tags[0].Away["UC2"].Source != awayDND {
+		t.Errorf("the cache wasn't reloaded: %+v", tags)
+	}
+
+	// a status without an expiration is renewed while it is set
+	later := now.Add(awaySweepInterval)
+	checkAway(later)
+	if a, _ := store.GetAway("UC1", awayStatus); !a.Until.Equal(later.Add(awayRenewal)) {
+		t.Errorf("UC1's status absence wasn't renewed: %+v", a)
+	}
+
+	// clearing the status ends the absence it set, but not one declared with the bot
+	stub.profiles = nil
+	stub.dnd = nil
+	checkAway(later)
+	if _, err := store.GetAway("UC1", awayStatus); err != ErrNotAway {
+		t.Errorf("UC1 is still away by status: %v", err)
+	}
+	if _, err := store.GetAway("UC2", awayDND); err != ErrNotAway {
+		t.Errorf("UC2 is still away by do not disturb: %v", err)
+	}
+	if a, err := store.GetAway("UC1", awayManual); err != nil || !a.Until.Equal(manual.Until) {
+		t.Errorf("UC1's own absence = %+v, %v", a, err)
+	}
+}
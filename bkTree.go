@@ -0,0 +1,155 @@
+/*
+A BK-tree of tag names, for fuzzy lookups.
+
+Scoring a word against every tag gets slow once there are thousands of tags. A BK-tree
+keeps the names in a tree by their levenshtein distance to each other, so only names
+which may be close to a word are looked at: a name is within d of the word only if its
+distance to its parent is within d of the parent's distance to the word.
+
+The levenshtein distance with the default options - where a substitution costs as much
+as a deletion and an insertion - is a metric, which the tree relies on. Names are
+removed by marking them deleted, and the tree is rebuilt once too many are.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	lv "github.com/texttheater/golang-levenshtein/levenshtein"
+)
+
+// bkTree is a BK-tree of names
+type bkTree struct {
+	root    *bkNode
+	size    int // names in the tree, including deleted ones
+	deleted int
+}
+
+type bkNode struct {
+	name     string
+	deleted  bool
+	children map[int]*bkNode // by distance to name
+}
+
+// distance is the levenshtein distance of two names
+func distance(a, b string) int {
+	return lv.DistanceForStrings([]rune(a), []rune(b), lv.DefaultOptions)
+}
+
+// newBKTree returns a tree of the names
+func newBKTree(names []string) *bkTree {
+	t := new(bkTree)
+	for _, n := range names {
+		t.add(n)
+	}
+	return t
+}
+
+// add adds a name to the tree, if it isn't in it already
+func (t *bkTree) add(name string) {
+	if t.root == nil {
+		t.root = &bkNode{name: name}
+		t.size++
+		return
+	}
+	node := t.root
+	for {
+		d := distance(name, node.name)
+		if d == 0 {
+			if node.deleted {
+				node.deleted = false
+				t.deleted--
+			}
+			return
+		}
+		child, ok := node.children[d]
+		if !ok {
+			if node.children == nil {
+				node.children = make(map[int]*bkNode)
+			}
+			node.children[d] = &bkNode{name: name}
+			t.size++
+			return
+		}
+		node = child
+	}
+}
+
+// remove removes a name from the tree, rebuilding it if more than half of the names
+// in it are deleted
+func (t *bkTree) remove(name string) {
+	node := t.root
+	for node != nil {
+		d := distance(name, node.name)
+		if d == 0 {
+			if !node.deleted {
+				node.deleted = true
+				t.deleted++
+			}
+			break
+		}
+		node = node.children[d]
+	}
+	if t.deleted > t.size/2 {
+		*t = *newBKTree(t.names())
+	}
+}
+
+// names returns the names in the tree which aren't deleted
+func (t *bkTree) names() []string {
+	var names []string
+	var walk func(*bkNode)
+	walk = func(n *bkNode) {
+		if n == nil {
+			return
+		}
+		if !n.deleted {
+			names = append(names, n.name)
+		}
+		for _, c := range n.children {
+			walk(c)
+		}
+	}
+	walk(t.root)
+	return names
+}
+
+// near returns the names within maxDist of word, with their distance to it
+func (t *bkTree) near(word string, maxDist int) map[string]int {
+	found := make(map[string]int)
+	if t.root == nil {
+		return found
+	}
+	stack := []*bkNode{t.root}
+	for len(stack) != 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		d := distance(word, node.name)
+		if d <= maxDist && !node.deleted {
+			found[node.name] = d
+		}
+		for cd, child := range node.children {
+			if cd >= d-maxDist && cd <= d+maxDist {
+				stack = append(stack, child)
+			}
+		}
+	}
+	return found
+}
+
+// maxDistance returns the largest levenshtein distance at which a name can have a
+// ratio of at least minRatio to a word of length n. The ratio is
+// (n + m - d) / (n + m) for a name of length m, and m is at most n + d
+func maxDistance(n int, minRatio float64) int {
+	return int(2*float64(n)*(1-minRatio)/minRatio + 1e-9)
+}
+
+// ratio returns the levenshtein ratio of two names at distance d
+func ratio(a, b string, d int) float64 {
+	sum := len([]rune(a)) + len([]rune(b))
+	if sum == 0 {
+		return 0
+	}
+	return float64(sum-d) / float64(sum)
+}
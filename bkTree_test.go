@@ -0,0 +1,158 @@
+/*
+Tests for fuzzy lookups in the BK-tree, checked against scoring every tag.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	lv "github.com/texttheater/golang-levenshtein/levenshtein"
+)
+
+// testTagNames returns n different tag names of one or two words, made up from a few
+// letters so that many of them are close to each other
+func testTagNames(n int) []string {
+	r := rand.New(rand.NewSource(1))
+	word := func() string {
+		b := make([]byte, 3+r.Intn(8))
+		for i := range b {
+			b[i] = "abcdeklmnorst"[r.Intn(13)]
+		}
+		return string(b)
+	}
+	seen := make(map[string]bool)
+	var names []string
+	for len(names) < n {
+		name := word()
+		if r.Intn(5) == 0 {
+			name += " " + word()
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// testQueries returns words to look up: some of the names, the names with a letter
+// changed, dropped or added, and made up words
+func testQueries(names []string, n int) []string {
+	r := rand.New(rand.NewSource(2))
+	var queries []string
+	for i := 0; i < n; i++ {
+		q := []rune(names[r.Intn(len(names))])
+		p := r.Intn(len(q))
+		switch i % 4 {
+		case 1:
+			q[p] = 'x'
+		case 2:
+			q = append(q[:p], q[p+1:]...)
+		case 3:
+			q = append(q[:p], append([]rune("é"), q[p:]...)...)
+		}
+		queries = append(queries, string(q))
+	}
+	return append(queries, "", "z", "kafka", strings.Repeat("ab", 20))
+}
+
+// similarLinear is TagCache.Similar done by scoring every name
+func similarLinear(names []string, word string, minRatio float64) map[string]float64 {
+	similar := make(map[string]float64)
+	for _, n := range names {
+		if r := lv.RatioForStrings([]rune(word), []rune(n), lv.DefaultOptions); r >= minRatio {
+			similar[n] = r
+		}
+	}
+	return similar
+}
+
+func TestSimilarMatchesLinear(t *testing.T) {
+	names := testTagNames(600)
+	queries := testQueries(names, 100)
+	tree := newBKTree(names)
+	cache := &TagCache{fuzzy: tree}
+
+	check := func(step string, live []string) {
+		if got := tree.names(); len(got) != len(live) {
+			t.Fatalf("%s: the tree has %d names, want %d", step, len(got), len(live))
+		}
+		for _, minRatio := range []float64{0.5, 0.8, 0.85, 0.9, 1} {
+			for _, q := range queries {
+				want := similarLinear(live, q, minRatio)
+				if got := cache.Similar(q, minRatio); !reflect.DeepEqual(got, want) {
+					t.Errorf("%s: Similar(%q, %v) = %v, want %v", step, q, minRatio, got, want)
+				}
+			}
+		}
+	}
+	check("built", names)
+
+	// removing a quarter leaves the names marked deleted
+	for _, n := range names[:150] {
+		tree.remove(n)
+	}
+	tree.remove("not a tag")
+	if tree.deleted != 150 {
+		t.Fatalf("%d names deleted, want 150", tree.deleted)
+	}
+	check("a quarter removed", names[150:])
+
+	// adding some back undeletes them
+	for _, n := range names[:30] {
+		tree.add(n)
+	}
+	check("some added back", append(append([]string(nil), names[:30]...), names[150:]...))
+
+	// removing more than half rebuilds the tree
+	size := tree.size
+	for _, n := range names[:400] {
+		tree.remove(n)
+	}
+	if tree.size >= size || tree.deleted > tree.size/2 {
+		t.Fatalf("the tree wasn't rebuilt: it has %d names, %d deleted", tree.size, tree.deleted)
+	}
+	check("rebuilt", names[400:])
+}
+
+func TestMaxDistance(t *testing.T) {
+	for n := 1; n <= 20; n++ {
+		for _, minRatio := range []float64{0.5, 0.7, 0.8, 0.85, 0.9, 1} {
+			d := maxDistance(n, minRatio)
+			// the closest a name at distance d can be is d letters longer than the word
+			if r := float64(2*n) / float64(2*n+d); r < minRatio-1e-9 {
+				t.Errorf("maxDistance(%d, %v) = %d, which can only reach a ratio of %v", n, minRatio, d, r)
+			}
+			if r := float64(2*n) / float64(2*n+d+1); r >= minRatio {
+				t.Errorf("maxDistance(%d, %v) = %d, but %d can still reach a ratio of %v", n, minRatio, d, d+1, r)
+			}
+		}
+	}
+}
+
+const benchTags = 5000
+
+func BenchmarkSimilarBKTree(b *testing.B) {
+	names := testTagNames(benchTags)
+	queries := testQueries(names, 200)
+	cache := &TagCache{fuzzy: newBKTree(names)}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cache.Similar(queries[i%len(queries)], matchDistPercent)
+	}
+}
+
+func BenchmarkSimilarLinear(b *testing.B) {
+	names := testTagNames(benchTags)
+	queries := testQueries(names, 200)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		similarLinear(names, queries[i%len(queries)], matchDistPercent)
+	}
+}
@@ -0,0 +1,276 @@
+/*
+What a component is, for humans.
+
+Besides its channels, a component can have a display name, a short description, the
+product area it belongs to and extra links, like its docs, dashboards or repo. They
+are all optional, and are shown with the component and its tags:
+
+	@bot set #kafka name Kafka Streams
+	@bot set #kafka description Stream processing for the event pipeline
+	@bot set #kafka area Messaging
+	@bot set #kafka link dashboard https://grafana.example.com/d/kafka
+	@bot set #kafka link remove dashboard
+
+Setting a name, description or area to none clears it. A link label is a single word,
+and setting a link again with the same label replaces it.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+)
+
+// ComponentLink is an extra link of a component, like its docs or a dashboard
+type ComponentLink struct {
+	ID            int
+	ComponentChan string `gorm:"type:varchar(20)"`
+	Label         string `gorm:"type:varchar(30)"`
+	URL           string `gorm:"type:varchar(200)"`
+}
+
+// component info which can be set. These are also the audit trail actions
+const (
+	infoName        = "name"
+	infoDescription = "description"
+	infoArea        = "area"
+)
+
+// infoColumns are the components columns of the info
+var infoColumns = map[string]string{
+	infoName:        "display_name",
+	infoDescription: "description",
+	infoArea:        "area",
+}
+
+// maximum lengths of the info, matching the varchar in the db where there is one
+var infoLengths = map[string]int{
+	infoName:        50,
+	infoDescription: 1000,
+	infoArea:        30,
+}
+
+// MAX_LABEL_LENGTH and MAX_LINK_LENGTH should be the length of the link varchars in db
+const (
+	MAX_LABEL_LENGTH = 30
+	MAX_LINK_LENGTH  = 200
+)
+
+// info returns the value of a component's info
+func (c Component) info(field string) string {
+	switch field {
+	case infoName:
+		return c.DisplayName
+	case infoDescription:
+		return c.Description
+	case infoArea:
+		return c.Area
+	}
+	return ""
+}
+
+// setInfo sets the value of a component's info
+func (c *Component) setInfo(field, value string) {
+	switch field {
+	case infoName:
+		c.DisplayName = value
+	case infoDescription:
+		c.Description = value
+	case infoArea:
+		c.Area = value
+	}
+}
+
+// link returns the component's link with the label
+func (c Component) link(label string) (ComponentLink, bool) {
+	for _, l := range c.Links {
+		if l.Label == label {
+			return l, true
+		}
+	}
+	return ComponentLink{}, false
+}
+
+// linkEntry returns the audit entry value for a link, "" for none
+func linkEntry(l *ComponentLink) string {
+	if l == nil {
+		return ""
+	}
+	return l.Label + " " + l.URL
+}
+
+// entryLink returns the link of a component described by an audit entry value, nil
+// for none
+func entryLink(componentChan, v string) *ComponentLink {
+	f := strings.Fields(v)
+	if len(f) != 2 {
+		return nil
+	}
+	return &ComponentLink{ComponentChan: componentChan, Label: f[0], URL: f[1]}
+}
+
+// checkInfo returns an error if the info can't be set to value
+func checkInfo(field, value string) error {
+	max, ok := infoLengths[field]
+	if !ok {
+		return ErrNoInfo
+	}
+	if len(value) > max {
+		return ErrInfoTooLong
+	}
+	return nil
+}
+
+// checkLink returns an error if the link can't be stored
+func checkLink(l ComponentLink) error {
+	if len(l.Label) > MAX_LABEL_LENGTH || len(l.URL) > MAX_LINK_LENGTH {
+		return ErrInfoTooLong
+	}
+	return nil
+}
+
+// ChangeInfo sets the name, description or area of a component
+func (s *GormStore) ChangeInfo(componentChan, field, value string) error {
+	if err := checkInfo(field, value); err != nil {
+		return err
+	}
+	component, err := s.findComponent(componentChan)
+	if err != nil {
+		return err
+	}
+	if err := s.db.Model(&component).Update(infoColumns[field], value).Error; err != nil {
+		return s.fail("change component "+field, err)
+	}
+	log.WithFields(log.Fields{"component": componentChan, field: value}).Info("Changed component info in DB")
+	return nil
+}
+
+// SetLink adds a link to a component, replacing any link with the same label
+func (s *GormStore) SetLink(l ComponentLink) error {
+	if err := checkLink(l); err != nil {
+		return err
+	}
+	if _, err := s.findComponent(l.ComponentChan); err != nil {
+		return err
+	}
+	tx := s.db.Begin()
+	if err := tx.Where("component_chan = ? AND label = ?", l.ComponentChan, l.Label).Delete(&ComponentLink{}).Error; err != nil {
+		tx.Rollback()
+		return s.fail("replace link", err)
+	}
+	l.ID = 0
+	if err := tx.Create(&l).Error; err != nil {
+		tx.Rollback()
+		return s.fail("create link", err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return s.fail("set link", err)
+	}
+	log.WithFields(log.Fields{"component": l.ComponentChan, "label": l.Label, "url": l.URL}).Info("set component link")
+	return nil
+}
+
+// RemoveLink removes the link with the label from a component, returning ErrNoLink if
+// there is none
+func (s *GormStore) RemoveLink(componentChan, label string) error {
+	res := s.db.Where("component_chan = ? AND label = ?", componentChan, label).Delete(&ComponentLink{})
+	if res.Error != nil {
+		return s.fail("remove link", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return ErrNoLink
+	}
+	log.WithFields(log.Fields{"component": componentChan, "label": label}).Info("removed component link")
+	return nil
+}
+
+// loadLinks loads the links of the components selected by db into d
+func (s *GormStore) loadLinks(db *gorm.DB, d componentDetails) error {
+	var links []ComponentLink
+	if err := db.Order("label").Find(&links).Error; err != nil {
+		return s.fail("query component links", err)
+	}
+	for _, l := range links {
+		d.links[l.ComponentChan] = append(d.links[l.ComponentChan], l)
+	}
+	return nil
+}
+
+// ChangeInfo sets the name, description or area of a component
+func (s *MemStore) ChangeInfo(componentChan, field, value string) error {
+	if err := checkInfo(field, value); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	c, ok := s.components[componentChan]
+	if !ok {
+		return ErrNoComponent
+	}
+	c.setInfo(field, value)
+	s.components[componentChan] = c
+	return nil
+}
+
+// SetLink adds a link to a component, replacing any link with the same label
+func (s *MemStore) SetLink(l ComponentLink) error {
+	if err := checkLink(l); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.components[l.ComponentChan]; !ok {
+		return ErrNoComponent
+	}
+	s.removeLink(l.ComponentChan, l.Label)
+	s.links = append(s.links, l)
+	return nil
+}
+
+// RemoveLink removes the link with the label from a component, returning ErrNoLink if
+// there is none
+func (s *MemStore) RemoveLink(componentChan, label string) error {
+	s.Lock()
+	defer s.Unlock()
+	if !s.removeLink(componentChan, label) {
+		return ErrNoLink
+	}
+	return nil
+}
+
+func (s *MemStore) removeLink(componentChan, label string) bool {
+	for i, l := range s.links {
+		if l.ComponentChan == componentChan && l.Label == label {
+			s.links = append(s.links[:i:i], s.links[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// componentLinks returns the links of a component sorted by label
+func (s *MemStore) componentLinks(componentChan string) (links []ComponentLink) {
+	for _, l := range s.links {
+		if l.ComponentChan == componentChan {
+			links = append(links, l)
+		}
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].Label < links[j].Label })
+	return links
+}
+
+// ErrNoInfo is returned for info which can't be set on a component
+var ErrNoInfo = errors.New("Components have no such info")
+
+// ErrInfoTooLong is returned if a component's info or link is too long to store
+var ErrInfoTooLong = errors.New("Too long to store")
+
+// ErrNoLink is returned if a component has no link with the label
+var ErrNoLink = errors.New("Component has no link with this label")
@@ -0,0 +1,93 @@
+/*
+Tests for the display name, description, area and links of a component, backed by a
+MemStore.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nlopes/slack"
+)
+
+// infoSummary returns the info of a component as "name|area|description|label url,..."
+func infoSummary(c Component) string {
+	var links []string
+	for _, l := range c.Links {
+		links = append(links, l.Label+" "+l.URL)
+	}
+	return strings.Join([]string{c.DisplayName, c.Area, c.Description, strings.Join(links, ",")}, "|")
+}
+
+func TestInfoCommands(t *testing.T) {
+	newTestCache(t, map[string][]string{"kafka": {"C1"}})
+	stub := newSlackStub(nil)
+	defer stub.Close()
+
+	// each command runs after the ones before it, by the anchor UC1
+	tests := []struct {
+		text    string
+		message string
+		info    string // infoSummary of C1 afterwards
+	}{
+		{"<@B> set <#C1|c1> name Kafka Brokers", "Successfully changed the name of <#C1|c1>", "Kafka Brokers|||"},
+		{"<@B> set <#C1|c1> area streaming", "Successfully changed the area of <#C1|c1>", "Kafka Brokers|streaming||"},
+		{"<@B> set <#C1|c1> DESCRIPTION Moves  events between services", "Successfully changed the description of <#C1|c1>", "Kafka Brokers|streaming|Moves events between services|"},
+		{"<@B> set <#C1|c1> area " + strings.Repeat("x", infoLengths[infoArea]+1), fmt.Sprintf(infoTooLong, infoLengths[infoName], infoLengths[infoArea], infoLengths[infoDescription]), "Kafka Brokers|streaming|Moves events between services|"},
+		{"<@B> set <#C1|c1> link Dashboard <https://grafana/kafka>", "Successfully set the link _dashboard_ of <#C1|c1> to https://grafana/kafka", "Kafka Brokers|streaming|Moves events between services|dashboard https://grafana/kafka"},
+		{"<@B> set <#C1|c1> link dashboard <https://grafana/kafka2|Grafana>", "Successfully set the link _dashboard_ of <#C1|c1> to https://grafana/kafka2", "Kafka Brokers|streaming|Moves events between services|dashboard https://grafana/kafka2"},
+		{"<@B> set <#C1|c1> link repo <https://git/kafka>", "Successfully set the link _repo_ of <#C1|c1> to https://git/kafka", "Kafka Brokers|streaming|Moves events between services|dashboard https://grafana/kafka2,repo https://git/kafka"},
+		{"<@B> set <#C1|c1> link docs git/kafka", badLink, "Kafka Brokers|streaming|Moves events between services|dashboard https://grafana/kafka2,repo https://git/kafka"},
+		{"<@B> set <#C1|c1> link remove Repo", "Removed the link _repo_ from <#C1|c1>", "Kafka Brokers|streaming|Moves events between services|dashboard https://grafana/kafka2"},
+		{"<@B> set <#C1|c1> link remove repo", fmt.Sprintf(noLink, "<#C1|c1>", "repo"), "Kafka Brokers|streaming|Moves events between services|dashboard https://grafana/kafka2"},
+		{"<@B> set <#C1|c1> area none", "Cleared the area of <#C1|c1>", "Kafka Brokers||Moves events between services|dashboard https://grafana/kafka2"},
+	}
+	for _, tt := range tests {
+		ev := &slack.MessageEvent{Msg: slack.Msg{User: "UC1", Channel: "D1", Text: tt.text}}
+		handleCommand(ev, strings.Fields(tt.text))
+		if got := stub.messages(); !reflect.DeepEqual(got, []string{tt.message}) {
+			t.Errorf("%q said %q, want %q", tt.text, got, tt.message)
+		}
+		c, err := store.GetAnchor("C1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := infoSummary(c); got != tt.info {
+			t.Errorf("after %q, C1 has %q, want %q", tt.text, got, tt.info)
+		}
+	}
+
+	// the cache is reloaded, so the info is shown with the component
+	c, _ := store.GetAnchor("C1")
+	c.SupportChan = "S1"
+	want := "*component:* Kafka Brokers, *anchor:* <@UC1>, *component-channel:* <#C1>, *support-channel:* <#S1>, *dashboard:* https://grafana/kafka2\n>Moves events between services\n"
+	if got := componentFmt(c); got != want {
+		t.Errorf("componentFmt = %q, want %q", got, want)
+	}
+	if tags := cache.Find("kafka"); len(tags) != 1 || infoSummary(tags[0].component()) != infoSummary(c) {
+		t.Errorf("the cache has %+v", tags)
+	}
+}
+
+func TestComponentNameFmt(t *testing.T) {
+	tests := []struct {
+		name, area string
+		want       string
+	}{
+		{"Kafka Brokers", "streaming", "*component:* Kafka Brokers _(streaming)_, "},
+		{"Kafka Brokers", "", "*component:* Kafka Brokers, "},
+		{"", "streaming", "*area:* streaming, "},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		if got := componentNameFmt(Component{DisplayName: tt.name, Area: tt.area}); got != tt.want {
+			t.Errorf("componentNameFmt(%q, %q) = %q, want %q", tt.name, tt.area, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,458 @@
+/*
+Configuration for the bot. Settings are read from the following sources, with later
+sources overriding earlier ones:
+
+1. Defaults
+2. A YAML or TOML config file, given by -config or ACORN_CONFIG
+3. Environment variables
+4. Command line flags
+
+If running on PCF with the postgres dialect and no database URL from any of these, the
+URL of the cloud foundry service binding is used.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/cloudfoundry-community/go-cfenv"
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Config holds all runtime settings of the bot
+type Config struct {
+	DBDialect        string   `yaml:"db_dialect" toml:"db_dialect"`
+	DBURL            string   `yaml:"db_url" toml:"db_url"`
+	SlackToken       string   `yaml:"slack_token" toml:"slack_token"`
+	SigningSecret    string   `yaml:"signing_secret" toml:"signing_secret"`
+	HTTPPort         string   `yaml:"http_port" toml:"http_port"`
+	BotName          string   `yaml:"bot_name" toml:"bot_name"`
+	BotChannel       string   `yaml:"bot_channel" toml:"bot_channel"`
+	LogLevel         string   `yaml:"log_level" toml:"log_level"`
+	MatchDistPercent float64  `yaml:"match_dist_percent" toml:"match_dist_percent"`
+	MinWordLength    int      `yaml:"min_word_length" toml:"min_word_length"`
+	AutoMigrate      bool     `yaml:"auto_migrate" toml:"auto_migrate"`
+	UndoWindow       string   `yaml:"undo_window" toml:"undo_window"`
+	ProposalTTL      string   `yaml:"proposal_ttl" toml:"proposal_ttl"`
+	Admins           []string `yaml:"admins" toml:"admins"`
+	AwayEmoji        []string `yaml:"away_emoji" toml:"away_emoji"`
+	LinkCheck        string   `yaml:"link_check_interval" toml:"link_check_interval"`
+	IndexInterval    string   `yaml:"index_interval" toml:"index_interval"`
+	StopWords        []string `yaml:"stop_words" toml:"stop_words"`
+
+	// weights of the tag matchers by name, see matcher.go. They only pick the best
+	// match of each tag, as tags are ranked by the kind of match first
+	Matchers matcherWeights `yaml:"matchers" toml:"matchers"`
+
+	undoWindow  time.Duration
+	proposalTTL time.Duration
+	linkCheck   time.Duration
+	index       time.Duration
+}
+
+// environment variables which may be used to configure the bot
+const (
+	envConfig           = "ACORN_CONFIG"
+	envDBDialect        = "DB_DIALECT"
+	envDBURL            = "DB_URL"
+	envSlackToken       = "SLACK_BOT_TOKEN"
+	envSigningSecret    = "SLACK_SIGNING_SECRET"
+	envHTTPPort         = "PORT" // set by cloud foundry
+	envBotName          = "SLACK_BOT_NAME"
+	envBotChannel       = "SLACK_BOT_CHANNEL"
+	envLogLevel         = "LOG_LEVEL"
+	envMatchDistPercent = "MATCH_DIST_PERCENT"
+	envMinWordLength    = "MIN_WORD_LENGTH"
+	envAutoMigrate      = "AUTO_MIGRATE"
+	envUndoWindow       = "UNDO_WINDOW"
+	envProposalTTL      = "PROPOSAL_TTL"
+	envAdmins           = "ADMINS"
+	envAwayEmoji        = "AWAY_EMOJI"
+	envLinkCheck        = "LINK_CHECK_INTERVAL"
+	envIndexInterval    = "INDEX_INTERVAL"
+	envMatchers         = "MATCHERS"
+	envStopWords        = "STOP_WORDS"
+)
+
+const defaultSQLitePath = "acorn.db"
+
+// label of the cloud foundry database service
+const serviceLable = "elephantsql"
+
+// defaultConfig returns the settings used when no other source provides a value
+func defaultConfig() *Config {
+	return &Config{
+		DBDialect:        dialectPostgres,
+		HTTPPort:         "8080",
+		LogLevel:         "debug",
+		MatchDistPercent: .85,
+		MinWordLength:    4,
+		AutoMigrate:      true,
+		UndoWindow:       "1h",
+		ProposalTTL:      "72h",
+		AwayEmoji:        []string{":palm_tree:", ":airplane:", ":face_with_thermometer:"},
+		LinkCheck:        "24h",
+		IndexInterval:    "6h",
+		StopWords:        defaultStopWords,
+	}
+}
+
+// loadConfig builds the bot configuration from all sources. args are the command
+// line arguments without the program name, and getenv is used to look up environment
+// variables (normally os.Getenv)
+func loadConfig(args []string, getenv func(string) string) (*Config, error) {
+	cfg := defaultConfig()
+
+	fs := flag.NewFlagSet("acorn", flag.ContinueOnError)
+	var (
+		configFile       = fs.String("config", getenv(envConfig), "path to a YAML or TOML config file")
+		dbDialect        = fs.String("db-dialect", "", "database dialect, postgres or sqlite3")
+		dbURL            = fs.String("db-url", "", "database connection string, or file path for sqlite3")
+		slackToken       = fs.String("slack-token", "", "slack bot integration token")
+		signingSecret    = fs.String("signing-secret", "", "slack app signing secret, used to verify button clicks")
+		httpPort         = fs.String("http-port", "", "port to listen on for slack interactive messages")
+		botName          = fs.String("bot-name", "", "slack user name of the bot")
+		botChannel       = fs.String("bot-channel", "", "slack channel the bot posts help to on join")
+		logLevel         = fs.String("log-level", "", "log level (debug, info, warn, error)")
+		matchDistPercent = fs.Float64("match-dist-percent", 0, "minimum levenshtein ratio for a fuzzy tag match")
+		minWordLength    = fs.Int("min-word-length", 0, "minimum word length for fuzzy tag matching")
+		autoMigrate      = fs.Bool("auto-migrate", true, "apply pending schema migrations at startup")
+		undoWindow       = fs.String("undo-window", "", "how long after a change its author can undo it, e.g. 30m")
+		proposalTTL      = fs.String("proposal-ttl", "", "how long a tag proposal waits for the anchor before it expires, e.g. 72h")
+		admins           = fs.String("admins", "", "comma separated slack IDs of bot admins")
+		awayEmoji        = fs.String("away-emoji", "", "comma separated slack status emoji which mark an anchor away")
+		linkCheck        = fs.String("link-check-interval", "", "how often playbook links are checked, e.g. 24h, or 0 to never check them")
+		indexInterval    = fs.String("index-interval", "", "how often playbook contents are indexed for search, e.g. 6h, or 0 to never index them")
+		stopWords        = fs.String("stop-words", "", "comma separated words which are left out of questions")
+		matchers         = fs.String("matchers", "", "comma separated weights of the tag matchers, e.g. exact:1,fuzzy:0.9")
+	)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *configFile != "" {
+		if err := cfg.loadFile(*configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.loadEnv(getenv); err != nil {
+		return nil, err
+	}
+
+	// only flags which were explicitly passed override other sources
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "db-dialect":
+			cfg.DBDialect = *dbDialect
+		case "db-url":
+			cfg.DBURL = *dbURL
+		case "slack-token":
+			cfg.SlackToken = *slackToken
+		case "signing-secret":
+			cfg.SigningSecret = *signingSecret
+		case "http-port":
+			cfg.HTTPPort = *httpPort
+		case "bot-name":
+			cfg.BotName = *botName
+		case "bot-channel":
+			cfg.BotChannel = *botChannel
+		case "log-level":
+			cfg.LogLevel = *logLevel
+		case "match-dist-percent":
+			cfg.MatchDistPercent = *matchDistPercent
+		case "min-word-length":
+			cfg.MinWordLength = *minWordLength
+		case "auto-migrate":
+			cfg.AutoMigrate = *autoMigrate
+		case "undo-window":
+			cfg.UndoWindow = *undoWindow
+		case "proposal-ttl":
+			cfg.ProposalTTL = *proposalTTL
+		case "admins":
+			cfg.Admins = splitList(*admins)
+		case "away-emoji":
+			cfg.AwayEmoji = splitList(*awayEmoji)
+		case "link-check-interval":
+			cfg.LinkCheck = *linkCheck
+		case "index-interval":
+			cfg.IndexInterval = *indexInterval
+		case "stop-words":
+			cfg.StopWords = splitList(*stopWords)
+		case "matchers":
+			w, err := parseWeights(*matchers)
+			if err != nil {
+				flagErr = fmt.Errorf("-matchers: %v", err)
+			}
+			cfg.Matchers = w
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+
+	if cfg.DBDialect == dialectPostgres && cfg.DBURL == "" {
+		if uri, err := getCFConStr(); err != nil {
+			log.WithField("reason", err).Debug("Not using a cloud foundry database binding")
+		} else {
+			cfg.DBURL = uri
+		}
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadFile reads settings from a YAML (.yml, .yaml) or TOML (.toml) file
+func (cfg *Config) loadFile(path string) error {
+	switch filepath.Ext(path) {
+	case ".yml", ".yaml":
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := yaml.Unmarshal(b, cfg); err != nil {
+			return fmt.Errorf("could not parse config file %s: %v", path, err)
+		}
+	case ".toml":
+		if _, err := toml.DecodeFile(path, cfg); err != nil {
+			return fmt.Errorf("could not parse config file %s: %v", path, err)
+		}
+	default:
+		return ErrConfigFormat
+	}
+	log.WithField("file", path).Info("Loaded config file")
+	return nil
+}
+
+// loadEnv reads settings from environment variables which are set
+func (cfg *Config) loadEnv(getenv func(string) string) error {
+	strs := map[string]*string{
+		envDBDialect:     &cfg.DBDialect,
+		envDBURL:         &cfg.DBURL,
+		envSlackToken:    &cfg.SlackToken,
+		envSigningSecret: &cfg.SigningSecret,
+		envHTTPPort:      &cfg.HTTPPort,
+		envBotName:       &cfg.BotName,
+		envBotChannel:    &cfg.BotChannel,
+		envLogLevel:      &cfg.LogLevel,
+		envUndoWindow:    &cfg.UndoWindow,
+		envProposalTTL:   &cfg.ProposalTTL,
+		envLinkCheck:     &cfg.LinkCheck,
+		envIndexInterval: &cfg.IndexInterval,
+	}
+	for env, s := range strs {
+		if v := getenv(env); v != "" {
+			*s = v
+		}
+	}
+	if v := getenv(envMatchDistPercent); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("%s: %v", envMatchDistPercent, err)
+		}
+		cfg.MatchDistPercent = f
+	}
+	if v := getenv(envMinWordLength); v != "" {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%s: %v", envMinWordLength, err)
+		}
+		cfg.MinWordLength = i
+	}
+	if v := getenv(envAutoMigrate); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("%s: %v", envAutoMigrate, err)
+		}
+		cfg.AutoMigrate = b
+	}
+	if v := getenv(envAdmins); v != "" {
+		cfg.Admins = splitList(v)
+	}
+	if v := getenv(envAwayEmoji); v != "" {
+		cfg.AwayEmoji = splitList(v)
+	}
+	if v := getenv(envStopWords); v != "" {
+		cfg.StopWords = splitList(v)
+	}
+	if v := getenv(envMatchers); v != "" {
+		w, err := parseWeights(v)
+		if err != nil {
+			return fmt.Errorf("%s: %v", envMatchers, err)
+		}
+		cfg.Matchers = w
+	}
+	return nil
+}
+
+// validate checks for required settings and fills in dialect specific defaults
+func (cfg *Config) validate() error {
+	switch cfg.DBDialect {
+	case dialectPostgres:
+		if cfg.DBURL == "" {
+			return ErrNoDBURL
+		}
+	case dialectSQLite:
+		if cfg.DBURL == "" {
+			cfg.DBURL = defaultSQLitePath
+		}
+	default:
+		return ErrDialect
+	}
+	if cfg.SlackToken == "" || cfg.BotName == "" || cfg.BotChannel == "" {
+		return ErrNoSlackConfig
+	}
+	if cfg.SigningSecret == "" {
+		return ErrNoSigningSecret
+	}
+	if _, err := log.ParseLevel(cfg.LogLevel); err != nil {
+		return err
+	}
+	if cfg.MatchDistPercent <= 0 || cfg.MatchDistPercent > 1 {
+		return ErrMatchDistPercent
+	}
+	if cfg.MinWordLength < 0 {
+		return ErrMinWordLength
+	}
+	var err error
+	if cfg.undoWindow, err = time.ParseDuration(cfg.UndoWindow); err != nil {
+		return fmt.Errorf("undo_window: %v", err)
+	}
+	if cfg.proposalTTL, err = time.ParseDuration(cfg.ProposalTTL); err != nil {
+		return fmt.Errorf("proposal_ttl: %v", err)
+	}
+	if cfg.linkCheck, err = time.ParseDuration(cfg.LinkCheck); err != nil {
+		return fmt.Errorf("link_check_interval: %v", err)
+	}
+	if cfg.index, err = time.ParseDuration(cfg.IndexInterval); err != nil {
+		return fmt.Errorf("index_interval: %v", err)
+	}
+	if len(cfg.Matchers) == 0 {
+		cfg.Matchers = defaultMatchers
+	}
+	if _, err := newMatcher(cfg.Matchers); err != nil {
+		return fmt.Errorf("matchers: %v", err)
+	}
+	for _, w := range cfg.Matchers {
+		if w > 0 {
+			return nil
+		}
+	}
+	return ErrNoMatcherWeight
+}
+
+// matcherWeights are the weights of the tag matchers by name
+type matcherWeights map[string]float64
+
+// UnmarshalTOML decodes a table of weights. TOML keeps integers and floats apart, so
+// a weight may be written either way, like exact = 1
+func (w *matcherWeights) UnmarshalTOML(data interface{}) error {
+	table, ok := data.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("matchers must be a table of weights, not %v", data)
+	}
+	*w = make(matcherWeights)
+	for name, v := range table {
+		switch n := v.(type) {
+		case int64:
+			(*w)[name] = float64(n)
+		case float64:
+			(*w)[name] = n
+		default:
+			return fmt.Errorf("weight of matcher %s is not a number: %v", name, v)
+		}
+	}
+	return nil
+}
+
+// parseWeights parses comma separated name:weight pairs
+func parseWeights(s string) (map[string]float64, error) {
+	weights := make(map[string]float64)
+	for _, item := range splitList(s) {
+		pair := strings.SplitN(item, ":", 2)
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("%q is not name:weight", item)
+		}
+		w, err := strconv.ParseFloat(strings.TrimSpace(pair[1]), 64)
+		if err != nil {
+			return nil, err
+		}
+		weights[strings.TrimSpace(pair[0])] = w
+	}
+	return weights, nil
+}
+
+// splitList splits a comma separated list, dropping empty items
+func splitList(s string) (list []string) {
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return
+}
+
+// getCFConStr returns the database URI of the service bound to the app in cloud foundry
+func getCFConStr() (string, error) {
+	appEnv, err := cfenv.Current()
+	if err != nil {
+		return "", err
+	}
+	dbService, err := appEnv.Services.WithLabel(serviceLable)
+	if err != nil {
+		return "", err
+	}
+	if len(dbService) != 1 {
+		log.WithField("serviceLable", serviceLable).Error("It appears that more than one service with the serviceLable is attached to your app\nPlease ensure there is only one database instance attached")
+		return "", ErrCFService
+	}
+	uri, ok := dbService[0].CredentialString("uri")
+	if !ok {
+		return "", ErrCFService
+	}
+	return uri, nil
+}
+
+// ErrConfigFormat is returned if the config file is not YAML or TOML
+var ErrConfigFormat = errors.New("Config file must be YAML (.yml, .yaml) or TOML (.toml)")
+
+// ErrNoDBURL is returned if no database URL is configured for postgres
+var ErrNoDBURL = errors.New("No database URL configured - set db_url or bind an " + serviceLable + " service")
+
+// ErrDialect is returned if the configured database dialect is not supported
+var ErrDialect = errors.New("Unsupported database dialect, use postgres or sqlite3")
+
+// ErrNoSlackConfig is returned if the slack token, bot name or bot channel are missing
+var ErrNoSlackConfig = errors.New("Slack token, bot name and bot channel are all required")
+
+// ErrNoSigningSecret is returned if the slack signing secret is missing
+var ErrNoSigningSecret = errors.New("The slack signing secret is required to receive confirmations - set signing_secret")
+
+// ErrMatchDistPercent is returned if the levenshtein ratio is out of range
+var ErrMatchDistPercent = errors.New("match_dist_percent must be greater than 0 and at most 1")
+
+// ErrMinWordLength is returned if the minimum word length for fuzzy matching is negative
+var ErrMinWordLength = errors.New("min_word_length must not be negative")
+
+// ErrNoMatcherWeight is returned if no matcher has a weight above 0, so no tag would
+// ever match
+var ErrNoMatcherWeight = errors.New("At least one matcher must have a weight above 0")
+
+// ErrCFService is returned if the cloud foundry database binding is unusable
+var ErrCFService = errors.New("Could not find a single database URI in the cloud foundry services")
@@ -0,0 +1,253 @@
+/*
+Tests for loading the configuration from defaults, files, the environment and flags.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testYAMLConfig = `
+db_url: file.db
+log_level: info
+http_port: "9000"
+min_word_length: 3
+undo_window: 30m
+admins: [UFILE]
+matchers: {exact: 1, fulltext: 0.5}
+`
+
+const testTOMLConfig = `
+db_url = "file.db"
+log_level = "info"
+http_port = "9000"
+min_word_length = 3
+undo_window = "30m"
+admins = ["UFILE"]
+
+[matchers]
+exact = 1
+fulltext = 0.5
+`
+
+// errAnyConfig stands for any error in the loadConfig tests
+var errAnyConfig = errors.New("any error")
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acorn-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"acorn.yml":      testYAMLConfig,
+		"acorn.toml":     testTOMLConfig,
+		"bad.toml":       "[matchers]\nexact = \"high\"\n",
+		"zero.yml":       "matchers: {exact: 0, fuzzy: -1}\n",
+		"acorn.json":     "{}",
+		"float.toml":     "[matchers]\nexact = 1.0\nalias = 1\n",
+		"bad-yaml.yml":   "min_word_length: [",
+		"sqlite-url.yml": "db_url: other.db\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := func(name string) string { return filepath.Join(dir, name) }
+
+	// the settings from testYAMLConfig and testTOMLConfig
+	fromFile := func(cfg *Config) {
+		cfg.DBURL = "file.db"
+		cfg.LogLevel = "info"
+		cfg.HTTPPort = "9000"
+		cfg.MinWordLength = 3
+		cfg.UndoWindow = "30m"
+		cfg.Admins = []string{"UFILE"}
+		cfg.Matchers = matcherWeights{matchExact: 1, matchFullText: 0.5}
+	}
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want func(cfg *Config)
+		err  error
+	}{
+		{
+			name: "defaults",
+			want: func(cfg *Config) {},
+		},
+		{
+			name: "yaml file",
+			args: []string{"-config", file("acorn.yml")},
+			want: fromFile,
+		},
+		{
+			name: "toml file with integer weights",
+			args: []string{"-config", file("acorn.toml")},
+			want: fromFile,
+		},
+		{
+			name: "toml file with mixed weights",
+			args: []string{"-config", file("float.toml")},
+			want: func(cfg *Config) { cfg.Matchers = matcherWeights{matchExact: 1, matchAlias: 1} },
+		},
+		{
+			name: "file from the environment",
+			env:  map[string]string{envConfig: file("acorn.toml")},
+			want: fromFile,
+		},
+		{
+			name: "environment over file",
+			args: []string{"-config", file("acorn.yml")},
+			env: map[string]string{
+				envLogLevel:      "warn",
+				envMinWordLength: "5",
+				envAdmins:        "U1, U2",
+				envMatchers:      "fuzzy:1",
+				envAutoMigrate:   "false",
+			},
+			want: func(cfg *Config) {
+				fromFile(cfg)
+				cfg.LogLevel = "warn"
+				cfg.MinWordLength = 5
+				cfg.Admins = []string{"U1", "U2"}
+				cfg.Matchers = matcherWeights{matchFuzzy: 1}
+				cfg.AutoMigrate = false
+			},
+		},
+		{
+			name: "flags over environment",
+			args: []string{
+				"-config", file("acorn.yml"),
+				"-log-level", "error",
+				"-min-word-length", "6",
+				"-admins", "U3",
+				"-matchers", "alias:1",
+				"-auto-migrate=true",
+			},
+			env: map[string]string{
+				envLogLevel:      "warn",
+				envMinWordLength: "5",
+				envAdmins:        "U1, U2",
+				envMatchers:      "fuzzy:1",
+				envAutoMigrate:   "false",
+			},
+			want: func(cfg *Config) {
+				fromFile(cfg)
+				cfg.LogLevel = "error"
+				cfg.MinWordLength = 6
+				cfg.Admins = []string{"U3"}
+				cfg.Matchers = matcherWeights{matchAlias: 1}
+			},
+		},
+		{
+			name: "flags not given leave the rest",
+			args: []string{"-config", file("acorn.yml"), "-http-port", "7000"},
+			env:  map[string]string{envLogLevel: "warn"},
+			want: func(cfg *Config) {
+				fromFile(cfg)
+				cfg.HTTPPort = "7000"
+				cfg.LogLevel = "warn"
+			},
+		},
+		{
+			name: "sqlite path from the environment over the file",
+			args: []string{"-config", file("sqlite-url.yml")},
+			env:  map[string]string{envDBURL: "env.db"},
+			want: func(cfg *Config) { cfg.DBURL = "env.db" },
+		},
+		{
+			name: "no weight above 0",
+			args: []string{"-config", file("zero.yml")},
+			err:  ErrNoMatcherWeight,
+		},
+		{
+			name: "no weight above 0 from a flag",
+			args: []string{"-matchers", "exact:0"},
+			err:  ErrNoMatcherWeight,
+		},
+		{
+			name: "weight not a number",
+			args: []string{"-config", file("bad.toml")},
+			err:  errAnyConfig,
+		},
+		{
+			name: "unknown matcher",
+			env:  map[string]string{envMatchers: "soundex:1"},
+			err:  errAnyConfig,
+		},
+		{
+			name: "unknown file format",
+			args: []string{"-config", file("acorn.json")},
+			err:  ErrConfigFormat,
+		},
+		{
+			name: "file not parsed",
+			args: []string{"-config", file("bad-yaml.yml")},
+			err:  errAnyConfig,
+		},
+		{
+			name: "environment not parsed",
+			env:  map[string]string{envMinWordLength: "four"},
+			err:  errAnyConfig,
+		},
+		{
+			name: "no signing secret",
+			env:  map[string]string{envSigningSecret: ""},
+			err:  ErrNoSigningSecret,
+		},
+		{
+			name: "no slack token",
+			args: []string{"-slack-token", ""},
+			err:  ErrNoSlackConfig,
+		},
+		{
+			name: "negative min word length",
+			args: []string{"-min-word-length", "-1"},
+			err:  ErrMinWordLength,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the slack settings are required, so always come from the environment
+			env := map[string]string{
+				envDBDialect:     dialectSQLite,
+				envSlackToken:    "xoxb-test",
+				envBotName:       "acorn",
+				envBotChannel:    "acorn",
+				envSigningSecret: "secret",
+			}
+			for k, v := range tt.env {
+				env[k] = v
+			}
+			cfg, err := loadConfig(tt.args, func(k string) string { return env[k] })
+			if tt.err != nil {
+				if err == nil || (tt.err != errAnyConfig && err != tt.err) {
+					t.Fatalf("loadConfig = %+v, %v, want %v", cfg, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := defaultConfig()
+			want.DBDialect, want.SlackToken, want.BotName, want.BotChannel, want.SigningSecret = dialectSQLite, "xoxb-test", "acorn", "acorn", "secret"
+			tt.want(want)
+			if err := want.validate(); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(cfg, want) {
+				t.Errorf("loadConfig =\n%+v\nwant\n%+v", cfg, want)
+			}
+		})
+	}
+}
@@ -3,17 +3,23 @@ dbopts.go contains database operations for the bot.
 
 We contemplate three data models: anchor, component and tag. The TagInfo struct
 is used to bundle the response information that will be displayed by slackParse
-logic.
+logic. GormStore implements the TagStore interface on top of these models.
 
 Released under MIT license, copyright 2018 Tyler Ramer, Ignacio Elizaga
 */
 package main
 
 import (
+	"database/sql/driver"
 	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -21,9 +27,18 @@ import (
 type Component struct {
 	ID            int
 	AnchorSlackID string `gorm:"type:varchar(20)"`
-	PlaybookURL   string `gorm:"type:varchar(100)"`
 	ComponentChan string `gorm:"type:varchar(20)"`
 	SupportChan   string `gorm:"type:varchar(20)"`
+	DisplayName   string `gorm:"type:varchar(50)"` // see componentInfo.go
+	Description   string `gorm:"type:text"`
+	Area          string `gorm:"type:varchar(30)"`
+
+	Playbooks []Playbook         `gorm:"-"` // see playbooks.go
+	Links     []ComponentLink    `gorm:"-"` // see componentInfo.go
+	Anchors   []ComponentAnchor  `gorm:"-"` // anchors other than the primary, see anchors.go
+	Rotation  *Rotation          `gorm:"-"` // see schedule.go
+	Overrides []AnchorOverride   `gorm:"-"` // active overrides, see schedule.go
+	Away      map[string]Absence `gorm:"-"` // active absences of the anchors by slack ID, see away.go
 }
 
 // Tag is the database representation of a tag
@@ -36,42 +51,62 @@ type Tag struct {
 // MAX_TAG_LENGTH should be length of varchar in db
 const MAX_TAG_LENGTH = 50
 
-var db *gorm.DB
+// MAX_URL_LENGTH is the longest playbook URL accepted. Playbook URLs are stored as
+// text, this only keeps out pasting mistakes
+const MAX_URL_LENGTH = 2000
 
-// InitDB creates the connection to the database specified in conStr and stores
-// it in the db local variable
-func InitDB() {
-	var err error
-	db, err = gorm.Open("postgres", conStr)
-	if err != nil {
-		log.Error("Trouble connecting to the database, shutting down")
-		log.Panic(err)
-	}
-	log.WithField("conStr", conStr).Info("connected to the database")
+// GormStore is the TagStore backed by a gorm database connection
+type GormStore struct {
+	db *gorm.DB
+
+	// OnReconnect is called once the database is reachable again after an error
+	OnReconnect  func()
+	reconnecting int32
 }
 
-// MigrateDB performs a database migration from scratch for any of the db tables
-// if they don't exist. This does not include ddl changes in existing tables
-func MigrateDB() error {
-	var err error
-	if err = db.AutoMigrate(&Component{}, &Tag{}).Error; err != nil {
-		log.Error("the migration has failed")
-		log.Panic(err)
+// Supported database dialects
+const (
+	dialectPostgres = "postgres"
+	dialectSQLite   = "sqlite3"
+)
+
+// NewGormStore creates the connection to the database specified in conStr and
+// returns a store wrapping it. For sqlite, conStr is the path to the database file
+func NewGormStore(dialect, conStr string) (*GormStore, error) {
+	var db *gorm.DB
+	err := retryWithBackoff("connect to the database", connectAttempts, func() (err error) {
+		db, err = gorm.Open(dialect, conStr)
+		return
+	})
+	if err != nil {
+		log.Error("Trouble connecting to the database")
+		return nil, &DBError{Op: "connect", Err: err}
 	}
-	return err
+	if dialect == dialectSQLite {
+		// sqlite only allows a single writer - serialize access rather than fail with "database is locked"
+		db.DB().SetMaxOpenConns(1)
+	}
+	log.WithFields(log.Fields{"dialect": dialect, "conStr": conStr}).Info("connected to the database")
+	return &GormStore{db: db}, nil
 }
 
-// QueryTag scans the database for a given tag name and returns a slice of
-// TagInfo objects
-func QueryTag(n string) (retTags []TagInfo, err error) {
+// QueryTag scans the database for a given tag name or alias and returns a slice of
+// TagInfo objects for the canonical tag
+func (s *GormStore) QueryTag(n string) (retTags []TagInfo, err error) {
 	var (
-		t          TagInfo
 		tag        Tag
 		components []Component
 	)
+	aliases, err := s.tagAliases()
+	if err != nil {
+		return nil, err
+	}
+	if n, err = s.canonicalTag(n); err != nil {
+		return nil, err
+	}
 
 	// query the tag
-	if err := db.Where("Name = ?", n).First(&tag).Error; err != nil {
+	if err := s.db.Where("Name = ?", n).First(&tag).Error; err != nil {
 		if gorm.IsRecordNotFoundError(err) {
 			log.WithField("tag", n).Error("tag not found")
 			// TODO:  we can add some logic to this and ask the user to notify an
@@ -79,21 +114,27 @@ func QueryTag(n string) (retTags []TagInfo, err error) {
 			return nil, ErrNoTag
 		}
 		log.Error("an error ocurred querying the database for tag")
-		log.Panic(err)
+		return nil, s.fail("query tag", err)
 	}
 
 	// query the components associated with the tag
-	if err := db.Model(&tag).Association("Components").Find(&components).Error; err != nil {
+	if err := s.db.Model(&tag).Association("Components").Find(&components).Error; err != nil {
 		log.Error("an error ocurred querying the database for components associated with tag")
-		log.Panic(err)
+		return nil, s.fail("query tag components", err)
+	}
+	if len(components) == 0 {
+		return nil, nil
+	}
+	details, err := s.componentDetails(components...)
+	if err != nil {
+		return nil, err
 	}
 
-	t.Name = n // More than one component for some tags, but this method handles a single tag name
+	// More than one component for some tags, but this method handles a single tag name
 	for _, component := range components {
-		t.Anchor = component.AnchorSlackID
-		t.ComponentChan = component.ComponentChan
-		t.PlaybookURL = component.PlaybookURL
-		t.SupportChan = component.SupportChan
+		details.fill(&component)
+		t := newTagInfo(n, component)
+		t.Aliases = aliases[n]
 		retTags = append(retTags, t)
 	}
 	log.WithField("retTags[]", retTags).Info("tag information found")
@@ -101,38 +142,47 @@ func QueryTag(n string) (retTags []TagInfo, err error) {
 	return
 }
 
-// GetAllTags retrives all tags in the database into a map for use in the cache
-func GetAllTags() (tagMap map[string][]TagInfo, size int) {
+// GetAllTags retrives all tags in the database into a map for use in the cache. Each
+// alias is in the map as well, with the TagInfo of its canonical tag
+func (s *GormStore) GetAllTags() (tagMap map[string][]TagInfo, size int, err error) {
 	var (
-		t          TagInfo
 		tags       []Tag
 		components []Component
 	)
 	tagMap = make(map[string][]TagInfo)
+	details, err := s.componentDetails()
+	if err != nil {
+		return nil, 0, err
+	}
+	aliases, err := s.tagAliases()
+	if err != nil {
+		return nil, 0, err
+	}
 
-	if err := db.Find(&tags).Error; err != nil {
+	if err := s.db.Find(&tags).Error; err != nil {
 		if gorm.IsRecordNotFoundError(err) {
 			log.Info("Currently no tags to load from the database")
-			return nil, 0
+			return tagMap, 0, nil
 		}
-		log.Panic(err)
+		return nil, 0, s.fail("query all tags", err)
 	}
 	for _, tag := range tags {
-		if err := db.Model(&tag).Association("Components").Find(&components).Error; err != nil {
+		if err := s.db.Model(&tag).Association("Components").Find(&components).Error; err != nil {
 			log.Error("An error occured querying the database for components associated with a tag")
-			log.Panic(err)
+			return nil, 0, s.fail("query tag components", err)
 		}
 		var retTags []TagInfo
-		t.Name = tag.Name
 		for _, component := range components {
-			t.Anchor = component.AnchorSlackID
-			t.ComponentChan = component.ComponentChan
-			t.PlaybookURL = component.PlaybookURL
-			t.SupportChan = component.SupportChan
+			details.fill(&component)
+			t := newTagInfo(tag.Name, component)
+			t.Aliases = aliases[tag.Name]
 			retTags = append(retTags, t)
 		}
-		tagMap[t.Name] = retTags
-		log.WithFields(log.Fields{"name": t.Name, "tagInfo": retTags}).Debug("tag information retrieved from database")
+		tagMap[tag.Name] = retTags
+		for _, alias := range aliases[tag.Name] {
+			tagMap[alias] = retTags
+		}
+		log.WithFields(log.Fields{"name": tag.Name, "tagInfo": retTags}).Debug("tag information retrieved from database")
 	}
 	size = len(tags)
 	log.WithField("number", size).Info("Tags returned from the database")
@@ -142,148 +192,382 @@ func GetAllTags() (tagMap map[string][]TagInfo, size int) {
 // AddTag adds a component tag to the database
 // Note: in usage, query the cache for a tag before going to DB; thus, checking if tag
 // entry already exists should not be an issue here
-// TODO: Eventually, adding a component may be possible. Need to build error logic if
-// component exists w/ different information than provided. Also need to clean up probably
-func AddTag(t TagInfo) error {
+func (s *GormStore) AddTag(t TagInfo) error {
 	if len(t.Name) > MAX_TAG_LENGTH {
 		return ErrTagTooLong
 	}
-	var component Component
 	tag := Tag{Name: t.Name}
 
-	if err := db.Where(&Component{ComponentChan: t.ComponentChan}).First(&component).Error; err != nil {
-		if gorm.IsRecordNotFoundError(err) {
-			// Check if the channel exists in slack
-			channel, err := getChanName(t.ComponentChan)
-			if err != nil {
-				log.WithField("ComponentChannel", t.ComponentChan).Error("Component channel is not valid")
-				return err
-			}
-			log.WithField("ComponentName", channel).Error("Component is not in the DB")
-			return ErrNoComponent
-		}
-		log.Panic(err)
+	component, err := s.findComponent(t.ComponentChan)
+	if err != nil {
+		return err
 	}
 
-	if err := db.Where(&tag).First(&tag).Error; err != nil {
+	if err := s.db.Where(&tag).First(&tag).Error; err != nil {
 		if gorm.IsRecordNotFoundError(err) {
 			log.WithField("tag", tag.Name).Info("Adding new tag to DB")
 		} else {
 			log.Error("an error ocurred querying the database for tag")
-			log.Panic(err)
+			return s.fail("query tag", err)
 		}
 	}
 
-	if db.NewRecord(tag) {
+	if s.db.NewRecord(tag) {
 		tag.Components = append(tag.Components, component)
-		if err := db.Create(&tag).Error; err != nil {
+		if err := s.db.Create(&tag).Error; err != nil {
 			log.Error("Failed creating a tag in the database")
-			log.Panic(err)
+			return s.fail("create tag", err)
 		}
 	} else {
-		if err := db.Model(&tag).Association("Components").Append(component).Error; err != nil {
+		if err := s.db.Model(&tag).Association("Components").Append(component).Error; err != nil {
 			log.Error("Failed adding a tag association to the database")
-			log.Panic(err)
+			return s.fail("add tag association", err)
 		}
 	}
-	supportChan, _ := getChanName(component.SupportChan)
-	componentChan, _ := getChanName(component.ComponentChan)
-	log.WithFields(log.Fields{"tag": t.Name, "support-channel": supportChan, "component-channel": componentChan}).Info("added tag to the database")
+	log.WithFields(log.Fields{"tag": t.Name, "support-channel": component.SupportChan, "component-channel": component.ComponentChan}).Info("added tag to the database")
 	return nil
 }
 
-// ChangeAnchor takes a component chan and anchor string and sets anchor string as anchor for that component
-func ChangeAnchor(componentChan, newAnchor string) error {
+// AddComponent adds a new component to the database. The channels and anchor should
+// be validated against slack before calling this
+func (s *GormStore) AddComponent(c Component) error {
+	for _, p := range c.Playbooks {
+		if err := checkPlaybook(p); err != nil {
+			return err
+		}
+	}
 	var component Component
-	if err := db.Where(&Component{ComponentChan: componentChan}).First(&component).Error; err != nil {
-		if gorm.IsRecordNotFoundError(err) {
-			// Check if the channel exists in slack
-			channel, err := getChanName(componentChan)
-			if err != nil {
-				log.WithField("ComponentChannel", componentChan).Error("Component channel is not valid")
-				return err
-			}
-			log.WithField("ComponentName", channel).Error("Component is not in the DB")
-			return ErrNoComponent
+	if err := s.db.Where(&Component{ComponentChan: c.ComponentChan}).First(&component).Error; err == nil {
+		log.WithField("ComponentChannel", c.ComponentChan).Error("Component is already in the DB")
+		return ErrComponentExists
+	} else if !gorm.IsRecordNotFoundError(err) {
+		log.Error("an error ocurred querying the database for component")
+		return s.fail("query component", err)
+	}
+	tx := s.db.Begin()
+	if err := tx.Create(&c).Error; err != nil {
+		tx.Rollback()
+		log.Error("Failed creating a component in the database")
+		return s.fail("create component", err)
+	}
+	for _, p := range c.Playbooks {
+		p.ComponentChan = c.ComponentChan
+		if err := tx.Create(&p).Error; err != nil {
+			tx.Rollback()
+			return s.fail("create playbook", err)
 		}
-		log.Panic(err)
 	}
-	if err := db.Model(&component).Update("AnchorSlackID", newAnchor).Error; err != nil {
+	if err := tx.Commit().Error; err != nil {
+		return s.fail("create component", err)
+	}
+	log.WithFields(log.Fields{"component": c.ComponentChan, "support": c.SupportChan, "anchor": c.AnchorSlackID}).Info("added component to the database")
+	return nil
+}
+
+// ChangeAnchor takes a component chan and anchor string and sets anchor string as anchor for that component
+func (s *GormStore) ChangeAnchor(componentChan, newAnchor string) error {
+	component, err := s.findComponent(componentChan)
+	if err != nil {
+		return err
+	}
+	if err := s.db.Model(&component).Update("AnchorSlackID", newAnchor).Error; err != nil {
 		log.WithFields(log.Fields{"anchor": newAnchor, "component": componentChan}).Error("Failed to change anchor")
-		log.Panic(err)
+		return s.fail("change anchor", err)
 	}
-	cache.Load() // More than one tag will be reset - we need to reload the cache entirely
 	log.WithFields(log.Fields{"anchor": newAnchor, "component": componentChan}).Info("Changed Anchor in DB")
 	return nil
 }
 
 // GetAnchor returns the anchor slack ID and other tag details about a component channel
-func GetAnchor(componentChan string) (Component, error) {
+func (s *GormStore) GetAnchor(componentChan string) (Component, error) {
+	return s.findComponent(componentChan)
+}
+
+// DropTag removes a tag from the database. This will only be called from within the tag cache, so no need to reload cache
+func (s *GormStore) DropTag(t string) error {
+	var tag Tag
+	if err := s.db.Where(&Tag{Name: t}).First(&tag).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return ErrNoTag
+		}
+		log.Error("Could not look up tag in DB")
+		return s.fail("query tag", err)
+	}
+	tx := s.db.Begin()
+	if err := tx.Where("tag_name = ?", tag.Name).Delete(&TagAlias{}).Error; err != nil {
+		tx.Rollback()
+		return s.fail("delete tag aliases", err)
+	}
+	if err := tx.Model(&tag).Association("Components").Clear().Error; err != nil {
+		tx.Rollback()
+		log.WithField("tag", tag.Name).Error("Could not delete tag associations from database")
+		return s.fail("delete tag associations", err)
+	}
+	if err := tx.Delete(&tag).Error; err != nil {
+		tx.Rollback()
+		log.WithField("tag", tag.Name).Error("Could not delete tag from database")
+		return s.fail("delete tag", err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return s.fail("delete tag", err)
+	}
+	return nil
+}
+
+// RemoveTag removes the association between a tag and the component channel in
+// the TagInfo. The tag itself is deleted once no components are left
+func (s *GormStore) RemoveTag(t TagInfo) error {
+	var (
+		tag        Tag
+		components []Component
+	)
+	if err := s.db.Where(&Tag{Name: t.Name}).First(&tag).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return ErrNoTag
+		}
+		log.Error("Could not look up tag in DB")
+		return s.fail("query tag", err)
+	}
+	component, err := s.findComponent(t.ComponentChan)
+	if err != nil {
+		return err
+	}
+	if err := s.db.Model(&tag).Association("Components").Find(&components).Error; err != nil {
+		log.Error("an error ocurred querying the database for components associated with tag")
+		return s.fail("query tag components", err)
+	}
+	found := false
+	for _, c := range components {
+		if c.ID == component.ID {
+			found = true
+		}
+	}
+	if !found {
+		return ErrTagNotOnComponent
+	}
+
+	tx := s.db.Begin()
+	if err := tx.Model(&tag).Association("Components").Delete(component).Error; err != nil {
+		tx.Rollback()
+		log.WithFields(log.Fields{"tag": t.Name, "component": t.ComponentChan}).Error("Could not delete tag association from database")
+		return s.fail("delete tag association", err)
+	}
+	if len(components) == 1 {
+		if err := tx.Where("tag_name = ?", tag.Name).Delete(&TagAlias{}).Error; err != nil {
+			tx.Rollback()
+			return s.fail("delete tag aliases", err)
+		}
+		if err := tx.Delete(&tag).Error; err != nil {
+			tx.Rollback()
+			log.WithField("tag", tag.Name).Error("Could not delete tag from database")
+			return s.fail("delete tag", err)
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		return s.fail("delete tag association", err)
+	}
+	log.WithFields(log.Fields{"tag": t.Name, "component": t.ComponentChan}).Info("removed tag from component in the database")
+	return nil
+}
+
+// findComponent looks up a component by channel, returning ErrNoComponent if it is
+// not in the DB
+func (s *GormStore) findComponent(componentChan string) (Component, error) {
 	var component Component
-	if err := db.Where(&Component{ComponentChan: componentChan}).First(&component).Error; err != nil {
+	if err := s.db.Where(&Component{ComponentChan: componentChan}).First(&component).Error; err != nil {
 		if gorm.IsRecordNotFoundError(err) {
-			// Check if the channel exists in slack
-			channel, err := getChanName(componentChan)
-			if err != nil {
-				log.WithField("ComponentChannel", componentChan).Error("Component channel is not valid")
-				return component, err
-			}
-			log.WithField("ComponentName", channel).Error("Component is not in the DB")
+			log.WithField("ComponentChannel", componentChan).Error("Component is not in the DB")
 			return component, ErrNoComponent
 		}
-		log.Panic(err)
+		log.Error("an error ocurred querying the database for component")
+		return component, s.fail("query component", err)
+	}
+	details, err := s.componentDetails(component)
+	if err != nil {
+		return component, err
 	}
+	details.fill(&component)
 	return component, nil
+}
 
+// componentDetails holds what is stored about components outside of the components
+// table, keyed by component channel
+type componentDetails struct {
+	anchors   map[string][]ComponentAnchor
+	rotations map[string]Rotation
+	overrides map[string][]AnchorOverride
+	absences  []Absence
+	links     map[string][]ComponentLink
+	playbooks map[string][]Playbook
 }
 
-// ChangePlaybook changes the playbook URL for a component
-func ChangePlaybook(componentChan, newURL string) error {
-	var component Component
-	if err := db.Where(&Component{ComponentChan: componentChan}).First(&component).Error; err != nil {
-		if gorm.IsRecordNotFoundError(err) {
-			// Check if the channel exists in slack
-			channel, err := getChanName(componentChan)
-			if err != nil {
-				log.WithField("ComponentChannel", componentChan).Error("Component channel is not valid")
-				return err
-			}
-			log.WithField("ComponentName", channel).Error("Component is not in the DB")
-			return ErrNoComponent
+// fill sets the details of the component
+func (d componentDetails) fill(c *Component) {
+	c.Anchors = d.anchors[c.ComponentChan]
+	c.Rotation = nil
+	if r, ok := d.rotations[c.ComponentChan]; ok {
+		c.Rotation = &r
+	}
+	c.Overrides = d.overrides[c.ComponentChan]
+	c.Away = latestAbsences(d.absences, c.anchorIDs())
+	c.Links = d.links[c.ComponentChan]
+	c.Playbooks = d.playbooks[c.ComponentChan]
+}
+
+// componentDetails loads the details of the components, or of every component if
+// none are given
+func (s *GormStore) componentDetails(components ...Component) (componentDetails, error) {
+	d := componentDetails{
+		anchors:   make(map[string][]ComponentAnchor),
+		rotations: make(map[string]Rotation),
+		overrides: make(map[string][]AnchorOverride),
+		links:     make(map[string][]ComponentLink),
+		playbooks: make(map[string][]Playbook),
+	}
+	db := s.db
+	if len(components) != 0 {
+		chans := make([]string, len(components))
+		for i, c := range components {
+			chans[i] = c.ComponentChan
 		}
-		log.Panic(err)
+		db = db.Where("component_chan IN (?)", chans)
 	}
-	if err := db.Model(&component).Update("PlaybookURL", newURL).Error; err != nil {
-		log.WithFields(log.Fields{"url": newURL, "component": componentChan}).Error("Failed to change playbookURL")
-		log.Panic(err)
+	if err := s.loadAnchors(db, d); err != nil {
+		return d, err
 	}
-	cache.Load() // More than one tag will be reset - we need to reload the cache entirely
-	log.WithFields(log.Fields{"URL": newURL, "component": componentChan}).Info("Changed playbook URL in DB")
-	return nil
+	if err := s.loadSchedules(db, d); err != nil {
+		return d, err
+	}
+	if err := s.loadLinks(db, d); err != nil {
+		return d, err
+	}
+	if err := s.loadPlaybooks(db, d); err != nil {
+		return d, err
+	}
+	// only the absences of their anchors, who are known now
+	var anchors []string
+	for _, c := range components {
+		d.fill(&c)
+		anchors = append(anchors, c.anchorIDs()...)
+	}
+	var err error
+	d.absences, err = s.activeAbsences(anchors...)
+	return d, err
 }
 
-// DropTag removes a tag from the database. This will only be called from within the tag cache, so no need to reload cache
-func DropTag(t string) error {
-	var tag Tag
-	if err := db.Where(&Tag{Name: t}).First(&tag).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return ErrNoTag
+// fail logs a database error. If the connection to the database was lost, the error
+// is wrapped in a DBError and reconnecting starts in the background, so the bot keeps
+// running while the database is unavailable. Other errors, like constraint violations,
+// are returned as they are
+func (s *GormStore) fail(op string, err error) error {
+	log.WithFields(log.Fields{"op": op, "ERROR": err}).Error("database operation failed")
+	if !s.connectionLost(err) {
+		return err
+	}
+	go s.reconnect()
+	return &DBError{Op: op, Err: err}
+}
+
+// connectionLost returns true if err means the database can't be reached: a bad
+// connection, a network error, or anything else if the database doesn't answer a ping
+func (s *GormStore) connectionLost(err error) bool {
+	if errs, ok := err.(gorm.Errors); ok {
+		for _, e := range errs {
+			if s.connectionLost(e) {
+				return true
+			}
 		}
-		log.Error("Could not look up tag in DB")
-		log.Panic(err)
+		return false
 	}
-	if err := db.Delete(&tag).Error; err != nil {
-		log.WithField("tag", tag.Name).Error("Could not delete tag from database")
-		log.Panic(err)
+	if _, ok := err.(net.Error); ok || err == driver.ErrBadConn {
+		return true
 	}
-	return nil
+	return s.db.DB().Ping() != nil
+}
+
+// reconnect pings the database with backoff until it answers. Only one reconnect
+// loop runs at a time
+func (s *GormStore) reconnect() {
+	if !atomic.CompareAndSwapInt32(&s.reconnecting, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&s.reconnecting, 0)
+	retryWithBackoff("ping the database", 0, s.db.DB().Ping)
+	log.Info("database connection is available")
+	if s.OnReconnect != nil {
+		s.OnReconnect()
+	}
+}
+
+// number of connection attempts made at startup before giving up
+const connectAttempts = 8
+
+// backoff limits used when retrying database operations
+const (
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+// retryWithBackoff calls fn until it succeeds, doubling the wait between attempts
+// up to maxBackoff. If attempts is 0, it retries forever
+func retryWithBackoff(op string, attempts int, fn func() error) (err error) {
+	wait := minBackoff
+	for i := 1; ; i++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if attempts > 0 && i >= attempts {
+			return err
+		}
+		log.WithFields(log.Fields{"op": op, "attempt": i, "retryIn": wait, "ERROR": err}).Warn("database unavailable, retrying")
+		time.Sleep(wait)
+		if wait *= 2; wait > maxBackoff {
+			wait = maxBackoff
+		}
+	}
+}
+
+// DBError is returned when the database can't be reached, as opposed to the expected
+// errors below
+type DBError struct {
+	Op  string
+	Err error
+}
+
+func (e *DBError) Error() string {
+	return fmt.Sprintf("database error during %s: %v", e.Op, e.Err)
+}
+
+// Cause returns the underlying database error
+func (e *DBError) Cause() error {
+	return e.Err
+}
+
+// Unwrap returns the underlying database error
+func (e *DBError) Unwrap() error {
+	return e.Err
+}
+
+// IsDBError returns true if err is a DBError
+func IsDBError(err error) bool {
+	_, ok := err.(*DBError)
+	return ok
 }
 
 // ErrNoComponent is returned of there is no component in DB with provided ID
 var ErrNoComponent = errors.New("No component returned from the database for this ID")
 
+// ErrComponentExists is returned if a component with the provided channel is already in the DB
+var ErrComponentExists = errors.New("Component already exists in the database")
+
+// ErrURLTooLong is returned if a URL is too long for DB
+var ErrURLTooLong = errors.New("URL too long")
+
 // ErrNoTag is returned if there is no tag in the DB for the associated entry TODO - add error to be returned by the cache
 var ErrNoTag = errors.New("No tag exists for this word")
 
+// ErrTagNotOnComponent is returned if a tag exists but is not associated with the component
+var ErrTagNotOnComponent = errors.New("Tag is not associated with this component")
+
 // ErrTagTooLong is returned if tag length is too long for DB
 var ErrTagTooLong = errors.New("Tag name too long")
@@ -0,0 +1,187 @@
+/*
+Tests for the gorm store, against a temporary SQLite database.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// newTestGormStore returns a store on a new SQLite database with every migration
+// applied, and a function to close and remove it
+func newTestGormStore(t *testing.T) (*GormStore, func()) {
+	dir, err := ioutil.TempDir("", "acorn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewGormStore(dialectSQLite, filepath.Join(dir, "acorn.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	done := func() {
+		s.db.Close()
+		os.RemoveAll(dir)
+	}
+	if _, err := s.MigrateUp(); err != nil {
+		done()
+		t.Fatal(err)
+	}
+	return s, done
+}
+
+// gormTagChans returns the sorted component channels of a tag in the store
+func gormTagChans(t *testing.T, s *GormStore, name string) []string {
+	tags, err := s.QueryTag(name)
+	if err != nil && err != ErrNoTag {
+		t.Fatal(err)
+	}
+	var chans []string
+	for _, tag := range tags {
+		chans = append(chans, tag.ComponentChan)
+	}
+	sort.Strings(chans)
+	return chans
+}
+
+func TestGormStoreMigrations(t *testing.T) {
+	s, done := newTestGormStore(t)
+	defer done()
+	status, err := s.MigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status) != len(migrations) {
+		t.Fatalf("%d migrations in the status, want %d", len(status), len(migrations))
+	}
+	for i, st := range status {
+		if st.Version != migrations[i].version || st.AppliedAt == nil {
+			t.Errorf("migration %d: %+v", migrations[i].version, st)
+		}
+	}
+	if applied, err := s.MigrateUp(); err != nil || len(applied) != 0 {
+		t.Errorf("migrating again applied %+v, %v", applied, err)
+	}
+
+	// playbook titles are unique per component in the database itself
+	for _, c := range []string{"C1", "C2"} {
+		if err := s.AddComponent(Component{ComponentChan: c}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	quiet := s.db.LogMode(false)
+	if err := quiet.Create(&Playbook{ComponentChan: "C1", Title: "Failover"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := quiet.Create(&Playbook{ComponentChan: "C1", Title: "Failover"}).Error; err == nil {
+		t.Error("a second playbook titled Failover was created for C1")
+	}
+	if err := quiet.Create(&Playbook{ComponentChan: "C2", Title: "Failover"}).Error; err != nil {
+		t.Errorf("a playbook titled Failover for C2: %v", err)
+	}
+}
+
+func TestGormStoreTags(t *testing.T) {
+	s, done := newTestGormStore(t)
+	defer done()
+
+	if err := s.AddTag(TagInfo{Name: "kafka", ComponentChan: "C1"}); err != ErrNoComponent {
+		t.Errorf("tagging an unknown component = %v, want ErrNoComponent", err)
+	}
+	for _, c := range []Component{
+		{ComponentChan: "C1", SupportChan: "S1", AnchorSlackID: "U1"},
+		{ComponentChan: "C2", SupportChan: "S2", AnchorSlackID: "U2"},
+	} {
+		if err := s.AddComponent(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.AddComponent(Component{ComponentChan: "C1"}); err != ErrComponentExists {
+		t.Errorf("adding C1 twice = %v, want ErrComponentExists", err)
+	}
+	for _, tag := range []TagInfo{
+		{Name: "kafka", ComponentChan: "C1"},
+		{Name: "kafka", ComponentChan: "C2"},
+		{Name: "postgres", ComponentChan: "C1"},
+	} {
+		if err := s.AddTag(tag); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := gormTagChans(t, s, "kafka"); !reflect.DeepEqual(got, []string{"C1", "C2"}) {
+		t.Errorf("kafka is on %v", got)
+	}
+	tags, err := s.QueryTag("postgres")
+	if err != nil || len(tags) != 1 || tags[0].Anchor != "U1" || tags[0].SupportChan != "S1" {
+		t.Errorf("QueryTag(postgres) = %+v, %v", tags, err)
+	}
+
+	// tag names and component channels are unique in the database itself
+	quiet := s.db.LogMode(false)
+	if err := quiet.Create(&Tag{Name: "kafka"}).Error; err == nil {
+		t.Error("a second tag named kafka was created")
+	}
+	if err := quiet.Create(&Component{ComponentChan: "C1"}).Error; err == nil {
+		t.Error("a second component C1 was created")
+	}
+
+	if err := s.AddAlias(TagAlias{Name: "kfk", TagName: "kafka"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddAlias(TagAlias{Name: "postgres", TagName: "kafka"}); err != ErrAliasTaken {
+		t.Errorf("aliasing a tag = %v, want ErrAliasTaken", err)
+	}
+	if got := gormTagChans(t, s, "kfk"); !reflect.DeepEqual(got, []string{"C1", "C2"}) {
+		t.Errorf("kfk is on %v", got)
+	}
+	all, n, err := s.GetAllTags()
+	if err != nil || n != 2 || len(all["kfk"]) != 2 || !reflect.DeepEqual(all["kafka"][0].Aliases, []string{"kfk"}) {
+		t.Errorf("GetAllTags = %+v, %d, %v", all, n, err)
+	}
+
+	if err := s.RemoveTag(TagInfo{Name: "kafka", ComponentChan: "C1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RemoveTag(TagInfo{Name: "kafka", ComponentChan: "C1"}); err != ErrTagNotOnComponent {
+		t.Errorf("untagging twice = %v, want ErrTagNotOnComponent", err)
+	}
+	if err := s.RemoveTag(TagInfo{Name: "kafka", ComponentChan: "C9"}); err != ErrNoComponent {
+		t.Errorf("untagging an unknown component = %v, want ErrNoComponent", err)
+	}
+	if got := gormTagChans(t, s, "kafka"); !reflect.DeepEqual(got, []string{"C2"}) {
+		t.Errorf("after untagging C1, kafka is on %v", got)
+	}
+
+	if err := s.DropTag("kafka"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DropTag("kafka"); err != ErrNoTag {
+		t.Errorf("dropping twice = %v, want ErrNoTag", err)
+	}
+	if _, err := s.QueryTag("kfk"); err != ErrNoTag {
+		t.Errorf("after the drop, QueryTag(kfk) = %v, want ErrNoTag", err)
+	}
+	// the name is free again, with no alias left over
+	if err := s.AddTag(TagInfo{Name: "kafka", ComponentChan: "C1"}); err != nil {
+		t.Fatal(err)
+	}
+	if tags, err := s.QueryTag("kafka"); err != nil || len(tags) != 1 || len(tags[0].Aliases) != 0 {
+		t.Errorf("kafka tagged again = %+v, %v", tags, err)
+	}
+
+	// untagging the last component drops the tag
+	if err := s.RemoveTag(TagInfo{Name: "postgres", ComponentChan: "C1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.QueryTag("postgres"); err != ErrNoTag {
+		t.Errorf("after untagging its last component, QueryTag(postgres) = %v, want ErrNoTag", err)
+	}
+}
@@ -6,6 +6,9 @@ package main
 
 import (
 	"fmt"
+	"net/http"
+	"strings"
+	"time"
 
 	"github.com/nlopes/slack"
 	log "github.com/sirupsen/logrus"
@@ -17,6 +20,13 @@ const (
 	addHelp
 	dropHelp
 	setHelp
+	migrateHelp
+	untagHelp
+	historyHelp
+	undoHelp
+	rolesHelp
+	rotationHelp
+	playbookHelp
 )
 
 // Various help messages
@@ -26,17 +36,471 @@ const (
 	noRelevantTag    = "I couldn't find anything relevant. Please contact your local (or remote) anchor if you think you have a tag which should be added"
 	alreadyAdded     = "Tag _%s_ is already marked for this component"
 	noTagInDB        = "Tag _%s_ is not in the database"
+	noTag            = "This tag is not in the database"
 	tagTooLong       = "Tag _%s_ is too long to add to the database"
 	invalidAnchor    = "The word submitted as the anchor ID does not appear to be a valid slack ID."
 	notWeblink       = "The word submitted as playbook URL does not appear to be a valid URL"
+	urlTooLong       = "The playbook URL or title is too long to add to the database"
+	componentExists  = "This component is already in the database - use _set_ to make adjustments to it"
+
+	tagNotOnComponent    = "Tag _%s_ is not marked for the component %s"
+	dbUnavailable        = "The database is temporarily unavailable - please try again in a few minutes"
+	noMigrations         = "This storage backend does not use schema migrations"
+	noHistory            = "No changes have been recorded for this yet"
+	nothingToUndo        = "You have no changes left to undo"
+	undoExpired          = "Your last change #%d is too old to undo - please ask an admin to revert it"
+	noSuchChange         = "There is no change #%d"
+	invalidChangeID      = "The change ID should be a number, as listed by _history_"
+	alreadyReverted      = "Change #%d has already been reverted"
+	revertConflict       = "Change #%d has been overwritten by a later change - check the history and fix it by hand"
+	notRevertible        = "Change #%d cannot be reverted automatically"
+	partialRevert        = "Only part of change #%d was reverted, the rest is unchanged. Reverted so far:\n"
+	notAdmin             = "Only acorn admins can do this"
+	notOwner             = "Only the anchor or maintainers of this component, or acorn admins, can do this"
+	invalidUser          = "The user does not appear to be a valid slack ID."
+	roleExists           = "This user already has this role"
+	noRole               = "This user does not have this role"
+	proposalQuestion     = "Only the component's owners can approve these tags"
+	primaryAnchorRole    = "Use _set [#component-channel] anchor @[anchor]_ to change the primary anchor"
+	removePrimaryAnchor  = "The primary anchor cannot be removed - set a new primary anchor instead"
+	anchorExists         = "This user already anchors this component"
+	noAnchor             = "This user does not anchor this component"
+	roleTooLong          = "The anchor role is too long to add to the database"
+	proposalSent         = "You don't own this component, so your tags were sent for approval as proposal #%d to %s"
+	noSuchProposal       = "There is no proposal #%d"
+	proposalClosed       = "Proposal #%d has already been decided or has expired"
+	proposalExpiredFmt   = "Your proposal #%d to tag %s expired before anyone approved it"
+	unexpectedError      = "Something went wrong handling this request - please reach out to a member of acorn project team if it keeps happening"
+	missingComponentInfo = "A support channel, anchor and playbook URL are all required to add a component"
+	confirmQuestion      = "Nothing is changed until you confirm"
+	confirmCancelled     = "Cancelled - nothing was changed"
+	confirmExpired       = "This confirmation has expired - please run the command again"
+	confirmNotOwner      = "Only the person who ran the command can confirm it"
+	invalidPeriod        = "The rotation period should look like _1w_, _14d_ or _12h_, and be at least an hour"
+	invalidDate          = "Dates should look like _2006-01-02_ or _2006-01-02 15:04_, in UTC"
+	dateInPast           = "This date is already in the past"
+	noRotation           = "This component has no rotation"
+	awaySet              = "You are away until %s - your components show their backup anchor until then"
+	notAway              = "You have not said you are away"
+	welcomeBack          = "Welcome back - your components show you as their anchor again"
+	aliasTaken           = "_%s_ is already a tag or an alias"
+	noAliasInDB          = "_%s_ is not an alias"
+	badRotation          = "Use _set [#component-channel] rotation @[anchor1] @[anchor2] ... every [period]_ - see _help rotation_"
+	infoTooLong          = "This is too long to add to the database - names can be %d characters, areas %d and descriptions %d"
+	linkTooLong          = "The link label or URL is too long to add to the database"
+	noLink               = "%s has no link labelled _%s_"
+	noPlaybooks          = "%s has no playbooks"
+	noPlaybook           = "%s has no playbook titled _%s_"
+	playbookExists       = "%s already has a playbook titled _%s_ - remove it first to replace it"
+	badPlaybook          = "Use _playbook add [#component-channel] \"[title]\" [url] [for tag1, tag2]_ or _playbook remove [#component-channel] \"[title]\"_"
+	redirectQuestion     = "Update the playbook to the new URL?"
+	redirectGone         = "This playbook has changed since - nothing was updated"
+	badLink              = "Use _set [#component-channel] link [label] [url]_ or _set [#component-channel] link remove [label]_"
 )
 
-func tagFmt(tag TagInfo) string {
-	return fmt.Sprintf("*tag:* %s, *anchor:* %s, *component-channel:* %s, *support-channel:* %s, *playbook:* %s\n", tag.Name, usrFormat(tag.Anchor), chanFormat(tag.ComponentChan), chanFormat(tag.SupportChan), tag.PlaybookURL)
+// layout of times shown in slack
+const timeFmt = "2006-01-02 15:04 MST"
+
+// formats a component matching a question, with its matched tags and the words which
+// matched them
+func matchFmt(m ComponentMatch) string {
+	label := "*tag:*"
+	if len(m.Tags) > 1 {
+		label = "*tags:*"
+	}
+	var names, words []string
+	for _, t := range m.Tags {
+		name := t.Name
+		if len(t.Aliases) != 0 {
+			name += fmt.Sprintf(" _(also %s)_", strings.Join(t.Aliases, ", "))
+		}
+		names = append(names, name)
+	}
+	for _, w := range m.Words {
+		words = append(words, fmt.Sprintf("\"%s\"", w))
+	}
+	tag := m.tag()
+	c := tag.component()
+	return fmt.Sprintf("%s %s _(matched %s)_, %s%s, *component-channel:* %s, *support-channel:* %s%s%s\n%s", label, strings.Join(names, ", "), strings.Join(words, ", "), componentNameFmt(c), anchorsFmt(c), chanFormat(c.ComponentChan), chanFormat(c.SupportChan), playbooksFmt(c.relevantPlaybooks(tag.Name), maxPlaybooks), linksFmt(c), descriptionFmt(c))
+}
+
+func matchesFmt(matches []ComponentMatch) string {
+	var lines []string
+	for _, m := range matches {
+		lines = append(lines, matchFmt(m))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func moreText(more int) string {
+	if more == 1 {
+		return "Show 1 more component"
+	}
+	return fmt.Sprintf("Show %d more components", more)
 }
 
 func componentFmt(c Component) string {
-	return fmt.Sprintf("*anchor:* %s, *component-channel:* %s, *support-channel:* %s, *playbook:* %s\n", usrFormat(c.AnchorSlackID), chanFormat(c.ComponentChan), chanFormat(c.SupportChan), c.PlaybookURL)
+	return fmt.Sprintf("%s%s, *component-channel:* %s, *support-channel:* %s%s%s\n%s", componentNameFmt(c), anchorsFmt(c), chanFormat(c.ComponentChan), chanFormat(c.SupportChan), playbooksFmt(c.relevantPlaybooks(""), 0), linksFmt(c), descriptionFmt(c))
+}
+
+// formats up to max playbooks as titled links, or all of them if max is 0
+func playbooksFmt(playbooks []Playbook, max int) string {
+	if len(playbooks) == 0 {
+		return ""
+	}
+	if len(playbooks) == 1 {
+		return ", *playbook:* " + playbookLink(playbooks[0])
+	}
+	var links []string
+	for i, p := range playbooks {
+		if max != 0 && i == max {
+			links = append(links, fmt.Sprintf("_and %d more_", len(playbooks)-max))
+			break
+		}
+		links = append(links, playbookLink(p))
+	}
+	return ", *playbooks:* " + strings.Join(links, ", ")
+}
+
+// formats a broken link check result
+func linkStatusFmt(s LinkStatus) string {
+	if s.Status == 0 {
+		return fmt.Sprintf(":warning: _unreachable since %s_", s.CheckedAt.UTC().Format(timeFmt))
+	}
+	return fmt.Sprintf(":warning: _%d %s since %s_", s.Status, http.StatusText(s.Status), s.CheckedAt.UTC().Format(timeFmt))
+}
+
+func brokenLinkFmt(p Playbook, s LinkStatus) string {
+	return fmt.Sprintf("The link of the playbook _%s_ of %s is broken: %s %s\nPlease replace it - see _help playbook_",
+		p.Title, chanFormat(p.ComponentChan), p.URL, linkStatusFmt(s))
+}
+
+func movedLinkFmt(p Playbook, s LinkStatus) string {
+	return fmt.Sprintf("The link of the playbook _%s_ of %s has moved permanently: %s → %s", p.Title, chanFormat(p.ComponentChan), p.URL, s.Redirect)
+}
+
+// formats a playbook section matching a question, with the component it belongs to
+func sectionFmt(m SectionMatch, c Component) string {
+	where := m.Playbook
+	if m.Heading != "" && m.Heading != m.Playbook {
+		where += " › " + m.Heading
+	}
+	return fmt.Sprintf("*found in:* <%s|%s> _(matching %s)_\n>%s\n%s", m.URL, where, strings.Join(m.Words, ", "), m.snippet(), componentFmt(c))
+}
+
+func playbookLink(p Playbook) string {
+	return fmt.Sprintf("<%s|%s>", p.URL, p.Title)
+}
+
+// formats every playbook of a component, one per line, with the tags it is scoped to
+func playbookListFmt(c Component) string {
+	if len(c.Playbooks) == 0 {
+		return fmt.Sprintf(noPlaybooks, chanFormat(c.ComponentChan))
+	}
+	lines := []string{fmt.Sprintf("Playbooks of %s:", chanFormat(c.ComponentChan))}
+	for _, p := range c.relevantPlaybooks("") {
+		line := "• " + playbookLink(p)
+		if p.Tags != "" {
+			line += fmt.Sprintf(" _(for %s)_", strings.Join(p.tagList(), ", "))
+		}
+		if p.checked() && p.broken() {
+			line += " " + linkStatusFmt(p.LinkStatus)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formats the display name and product area of a component, if it has them
+func componentNameFmt(c Component) string {
+	switch {
+	case c.DisplayName != "" && c.Area != "":
+		return fmt.Sprintf("*component:* %s _(%s)_, ", c.DisplayName, c.Area)
+	case c.DisplayName != "":
+		return fmt.Sprintf("*component:* %s, ", c.DisplayName)
+	case c.Area != "":
+		return fmt.Sprintf("*area:* %s, ", c.Area)
+	}
+	return ""
+}
+
+// formats the extra links of a component, each after its label
+func linksFmt(c Component) string {
+	var s string
+	for _, l := range c.Links {
+		s += fmt.Sprintf(", *%s:* %s", l.Label, l.URL)
+	}
+	return s
+}
+
+// formats the description of a component as a quote on the lines after it
+func descriptionFmt(c Component) string {
+	if c.Description == "" {
+		return ""
+	}
+	return ">" + c.Description + "\n"
+}
+
+// formats who to contact about a component, followed by the other anchors grouped by
+// role. If the anchor on duty is away, who to contact instead is shown with a note
+func anchorsFmt(c Component) string {
+	anchor, away := c.contact(time.Now())
+	s := "*anchor:* " + anchorFormat(anchor)
+	if away != nil {
+		if anchor == "" {
+			s = "*anchor:* " + chanFormat(c.SupportChan)
+		}
+		s += " " + awayFmt(*away)
+	}
+	roles, byRole := anchorsByRole(c.Anchors)
+	for _, role := range roles {
+		var users []string
+		for _, u := range byRole[role] {
+			users = append(users, anchorFormat(u))
+		}
+		s += fmt.Sprintf(", *%s:* %s", role, strings.Join(users, ", "))
+	}
+	return s
+}
+
+// formats the anchors of a component, one role per line, with the members of user
+// groups listed after the group
+func whoFmt(c Component, members map[string][]string) string {
+	onDuty := c.onDuty()
+	lines := []string{fmt.Sprintf("Anchoring %s:", chanFormat(c.ComponentChan)), "*anchor:* " + memberFmt(onDuty, members)}
+	if onDuty != c.AnchorSlackID {
+		lines = append(lines, "*primary:* "+memberFmt(c.AnchorSlackID, members))
+	}
+	roles, byRole := anchorsByRole(c.Anchors)
+	for _, role := range roles {
+		var anchors []string
+		for _, a := range byRole[role] {
+			anchors = append(anchors, memberFmt(a, members))
+		}
+		lines = append(lines, fmt.Sprintf("*%s:* %s", role, strings.Join(anchors, ", ")))
+	}
+	for _, anchor := range c.anchorIDs() {
+		if a, ok := c.away(anchor, time.Now()); ok {
+			lines = append(lines, awayFmt(a))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func memberFmt(anchor string, members map[string][]string) string {
+	if !isUserGroup(anchor) {
+		return anchorFormat(anchor)
+	}
+	var users []string
+	for _, u := range members[anchor] {
+		users = append(users, usrFormat(u))
+	}
+	if len(users) == 0 {
+		users = append(users, "_no members_")
+	}
+	return fmt.Sprintf("%s (%s)", anchorFormat(anchor), strings.Join(users, ", "))
+}
+
+func historyFmt(changes []Change) string {
+	var lines []string
+	for _, c := range changes {
+		line := fmt.Sprintf("*#%d* %s by %s in %s: `%s`", c.ID, c.CreatedAt.Format(timeFmt), usrFormat(c.ActorSlackID), chanFormat(c.Channel), c.Command)
+		if c.RevertOf != 0 {
+			line += fmt.Sprintf(" _(reverts #%d)_", c.RevertOf)
+		}
+		lines = append(lines, line)
+		for _, e := range c.Entries {
+			lines = append(lines, "    • "+auditEntryFmt(e))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func auditEntryFmt(e AuditEntry) string {
+	switch e.Action {
+	case actionTag, actionUntag, actionDrop:
+		before, aliases := entryTag(e.Before)
+		line := fmt.Sprintf("%s _%s_ on %s: %s → %s", e.Action, e.Tag, chanFormat(e.ComponentChan), chanListFmt(before), chanListFmt(e.After))
+		if e.After == "" && len(aliases) != 0 {
+			line += fmt.Sprintf(", with aliases _%s_", strings.Join(aliases, ", "))
+		}
+		return line
+	case actionAnchor:
+		return fmt.Sprintf("anchor of %s: %s → %s", chanFormat(e.ComponentChan), anchorOrNone(e.Before), anchorOrNone(e.After))
+	case actionAnchorAdd:
+		a := entryAnchor(e)
+		return fmt.Sprintf("added %s as %s anchor of %s", anchorFormat(a.SlackID), a.Role, chanFormat(e.ComponentChan))
+	case actionAnchorRemove:
+		a := entryAnchor(e)
+		return fmt.Sprintf("removed %s as %s anchor of %s", anchorFormat(a.SlackID), a.Role, chanFormat(e.ComponentChan))
+	case actionAddComponent:
+		return fmt.Sprintf("added component %s", chanFormat(e.ComponentChan))
+	case actionGrant:
+		role := entryRole(e)
+		return fmt.Sprintf("granted %s to %s", roleFmt(role), usrFormat(role.SlackID))
+	case actionRevoke:
+		role := entryRole(e)
+		return fmt.Sprintf("revoked %s from %s", roleFmt(role), usrFormat(role.SlackID))
+	case actionRotation:
+		return fmt.Sprintf("rotation of %s: %s → %s", chanFormat(e.ComponentChan),
+			rotationSummary(entryRotation(e.ComponentChan, e.Before)), rotationSummary(entryRotation(e.ComponentChan, e.After)))
+	case actionOverride:
+		o := entryOverride(e)
+		if e.After == "" {
+			return fmt.Sprintf("removed override of %s: %s until %s", chanFormat(e.ComponentChan), anchorFormat(o.SlackID), o.Until.UTC().Format(timeFmt))
+		}
+		return fmt.Sprintf("override of %s: %s until %s", chanFormat(e.ComponentChan), anchorFormat(o.SlackID), o.Until.UTC().Format(timeFmt))
+	case actionPlaybookAdd:
+		p := entryPlaybook(e)
+		return fmt.Sprintf("added playbook _%s_ to %s: %s", p.Title, chanFormat(e.ComponentChan), p.URL)
+	case actionPlaybookRemove:
+		p := entryPlaybook(e)
+		return fmt.Sprintf("removed playbook _%s_ from %s", p.Title, chanFormat(e.ComponentChan))
+	case actionPlaybookURL:
+		before, after := valuePlaybook(e.ComponentChan, e.Before), valuePlaybook(e.ComponentChan, e.After)
+		return fmt.Sprintf("playbook _%s_ of %s: %s → %s", after.Title, chanFormat(e.ComponentChan), before.URL, after.URL)
+	case actionLink:
+		if l := entryLink(e.ComponentChan, e.After); l != nil {
+			return fmt.Sprintf("link _%s_ of %s: %s", l.Label, chanFormat(e.ComponentChan), l.URL)
+		}
+		if l := entryLink(e.ComponentChan, e.Before); l != nil {
+			return fmt.Sprintf("removed link _%s_ of %s", l.Label, chanFormat(e.ComponentChan))
+		}
+	case actionAlias:
+		return fmt.Sprintf("added alias _%s_ to _%s_", e.After, e.Tag)
+	case actionUnalias:
+		return fmt.Sprintf("removed alias _%s_ from _%s_", e.Before, e.Tag)
+	case actionAway:
+		if a := entryAbsence(e.After); a != nil {
+			return fmt.Sprintf("%s away until %s", usrFormat(a.SlackID), a.Until.UTC().Format(timeFmt))
+		}
+		if a := entryAbsence(e.Before); a != nil {
+			return fmt.Sprintf("%s back", usrFormat(a.SlackID))
+		}
+	case actionDenied:
+		if e.ComponentChan == "" {
+			return fmt.Sprintf("denied, needs %s", e.After)
+		}
+		return fmt.Sprintf("denied on %s, needs %s", chanFormat(e.ComponentChan), e.After)
+	}
+	return fmt.Sprintf("%s of %s: %s → %s", e.Action, chanFormat(e.ComponentChan), orNone(e.Before), orNone(e.After))
+}
+
+func proposalFmt(p Proposal) string {
+	return fmt.Sprintf("*Proposal #%d:* %s would like to tag %s with _%s_. It expires %s",
+		p.ID, usrFormat(p.ProposerSlackID), chanFormat(p.ComponentChan), strings.Join(p.tagList(), "_, _"), p.ExpiresAt.Format(timeFmt))
+}
+
+// formats the anchor on duty, the next handoffs and the active overrides of a component
+func rotationFmt(c Component, now time.Time) string {
+	lines := []string{fmt.Sprintf("Anchoring %s: %s is on duty", chanFormat(c.ComponentChan), anchorOrNone(currentAnchor(c.AnchorSlackID, c.Rotation, c.Overrides, now)))}
+	if c.Rotation == nil {
+		lines = append(lines, "No rotation - the primary anchor is "+anchorOrNone(c.AnchorSlackID))
+	} else {
+		lines = append(lines, "*rotation:* "+rotationSummary(c.Rotation))
+		times, anchors := c.Rotation.handoffs(now, rotationShown)
+		for i := range times {
+			from := times[i].UTC().Format(timeFmt)
+			if !times[i].After(now) {
+				from = "now"
+			}
+			lines = append(lines, fmt.Sprintf("    • %s: %s", from, anchorFormat(anchors[i])))
+		}
+	}
+	for _, o := range c.Overrides {
+		lines = append(lines, fmt.Sprintf("*override:* %s until %s", anchorFormat(o.SlackID), o.Until.UTC().Format(timeFmt)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formats a note that someone is away. The end of an absence detected in slack is
+// often not known, so only the end of a declared absence is shown
+func awayFmt(a Absence) string {
+	if a.Source != awayManual {
+		return fmt.Sprintf("_(%s is away)_", usrFormat(a.SlackID))
+	}
+	return fmt.Sprintf("_(%s is away until %s)_", usrFormat(a.SlackID), a.Until.UTC().Format(timeFmt))
+}
+
+// formats the members and period of a rotation
+func rotationSummary(r *Rotation) string {
+	if r == nil {
+		return "_none_"
+	}
+	var members []string
+	for _, m := range r.memberList() {
+		members = append(members, anchorFormat(m))
+	}
+	return fmt.Sprintf("%s every %s from %s", strings.Join(members, ", "), periodFmt(r.Period), r.Start.UTC().Format(timeFmt))
+}
+
+// formats a rotation period in the largest whole unit
+func periodFmt(d time.Duration) string {
+	day := 24 * time.Hour
+	switch {
+	case d%(7*day) == 0:
+		return fmt.Sprintf("%dw", d/(7*day))
+	case d%day == 0:
+		return fmt.Sprintf("%dd", d/day)
+	}
+	return d.String()
+}
+
+func roleFmt(r Role) string {
+	if r.ComponentChan == "" {
+		return r.Name
+	}
+	return fmt.Sprintf("%s of %s", r.Name, chanFormat(r.ComponentChan))
+}
+
+// formats the users holding a list of roles
+func rolesFmt(roles []Role) string {
+	if len(roles) == 0 {
+		return "_none_"
+	}
+	var users []string
+	for _, r := range roles {
+		users = append(users, usrFormat(r.SlackID))
+	}
+	return strings.Join(users, ", ")
+}
+
+// formats a comma separated list of channel IDs
+func chanListFmt(chans string) string {
+	if chans == "" {
+		return "_none_"
+	}
+	var formatted []string
+	for _, c := range strings.Split(chans, ",") {
+		formatted = append(formatted, chanFormat(c))
+	}
+	return strings.Join(formatted, ", ")
+}
+
+func anchorOrNone(a string) string {
+	if a == "" {
+		return "_none_"
+	}
+	return anchorFormat(a)
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "_none_"
+	}
+	return s
+}
+
+func migrationFmt(status []MigrationStatus) string {
+	var lines []string
+	for _, st := range status {
+		applied := "_pending_"
+		if st.AppliedAt != nil {
+			applied = "applied " + st.AppliedAt.Format(timeFmt)
+		}
+		lines = append(lines, fmt.Sprintf("*%d* %s - %s", st.Version, st.Name, applied))
+	}
+	return strings.Join(lines, "\n")
 }
 
 // posts a help message on user join
@@ -67,37 +531,161 @@ func postHelp(ev *slack.MessageEvent, kind int) error {
 
 type _anchor: [component]_ to see the anchor and channel in charge of a product
 
+type _@[bot] who [#component-channel]_ to see who is anchoring a component right now, with anchor user groups expanded to their members
+
 type _help_ in this channel to see this message again at any time
 
 type _help tags_ for further information about adding tags
 
 type _help set_ for further information about changing components channels metadata
 
-type _help drop_ for further information about dropping tags`
+type _help add_ for further information about adding components
+
+type _help untag_ for further information about removing tags from a component
+
+type _help drop_ for further information about dropping tags
+
+type _help history_ for further information about seeing who changed a component or tag
+
+type _help undo_ for further information about reverting changes
+
+type _help roles_ for further information about who can change what
+
+type _help rotation_ for further information about anchor rotations, overrides and saying you are away
+
+type _help playbook_ for further information about a component's playbooks`
 
 	case kind == tagsHelp:
 		message = `To add tags to the bot, use the following syntax:
 
 _@[bot] tag [#component-channel] [tag1], [tag2], ..._
+
+If you are not the anchor or a maintainer of the component, the tags are sent to the anchor for approval first
+
+*Add or remove other names for a tag, like pg for postgres:*
+_@[bot] alias [tag] [alias1], [alias2], ..._
+_@[bot] unalias [alias1], [alias2], ..._
+Looking up an alias finds the tag it belongs to
 `
 
 	case kind == addHelp:
-		message = `Adding other items to the database is still in development. Check back later`
+		message = `To add a new component to the database, use the following syntax:
+
+_@[bot] add component [#component-channel] support [#support-channel] anchor @[anchor] playbook [url]_`
 
 	case kind == dropHelp:
 		message = `Drop a tag from the database using the following syntax:
 		
 _@[bot] drop [tag1], [tag2], ..._
 
-*Warning: This drop ALL tag associations with all component channels. Use with care*`
+*Warning: This drop ALL tag associations with all component channels. Use with care*
+
+The bot lists every component which would lose the tags and waits for you to confirm
+
+To remove a tag from a single component, use _@[bot] untag_ instead`
+
+	case kind == historyHelp:
+		message = `To see who changed a component or tag, use the following syntax:
+*Changes to a component:*
+_@[bot] history [#component-channel]_
+
+*Changes to a tag:*
+_@[bot] history tag [tag]_`
+
+	case kind == undoHelp:
+		message = `To revert a change, use the following syntax:
+*Undo your last change, if you still own what it changed:*
+_@[bot] undo_
+
+*Revert any change by ID (admins only):*
+_@[bot] revert [change-id]_
+
+Change IDs are listed by _@[bot] history_. Adding a component cannot be reverted. If reverting fails part way, the parts already reverted are listed`
+
+	case kind == rolesHelp:
+		message = `Only the anchor and maintainers of a component can change its tags, anchor and playbook. Acorn admins can change anything.
+*Grant or revoke maintainer of a component (owners and admins):*
+_@[bot] grant @[user] maintainer [#component-channel]_
+_@[bot] revoke @[user] maintainer [#component-channel]_
+
+*Grant or revoke admin (admins only):*
+_@[bot] grant @[user] admin_
+_@[bot] revoke @[user] admin_
+
+*Show who can change a component, or who the admins are:*
+_@[bot] roles [#component-channel]_
+_@[bot] roles_`
+
+	case kind == rotationHelp:
+		message = `Anchoring can rotate between several people, handing off every period. All times are UTC.
+*Set the rotation of a component, optionally from the first handoff:*
+_@[bot] set [#component-channel] rotation @[anchor1] @[anchor2] ... every [1w, 14d, 12h] from [2006-01-02] [15:04]_
+_@[bot] set [#component-channel] rotation off_
+
+*Put someone else on duty until a given time, for swaps:*
+_@[bot] override [#component-channel] @[anchor] until [2006-01-02] [15:04]_
+
+*Show who is on duty and the upcoming handoffs:*
+_@[bot] rotation [#component-channel]_
+
+*Let people know you are away, and back:*
+_@[bot] away until [2006-01-02] [15:04]_
+_@[bot] back_
+While the anchor is away, their backup anchor is shown instead, or the support channel if there is none. Anchors are also away while their slack status is one of the away emoji, like :palm_tree:, or while they pause notifications`
+
+	case kind == playbookHelp:
+		message = `A component can have several playbooks, like install, upgrade and troubleshooting guides.
+*Add a playbook, optionally only for some of the component's tags:*
+_@[bot] playbook add [#component-channel] "[title]" [url]_
+_@[bot] playbook add [#component-channel] "[title]" [url] for [tag1], [tag2], ..._
+Playbooks for a tag are shown first when someone asks about it
+
+*Remove a playbook:*
+_@[bot] playbook remove [#component-channel] "[title]"_
+
+*List the playbooks of a component:*
+_@[bot] playbook [#component-channel]_
+
+The playbook given when adding the component is titled Playbook, and can be changed with _@[bot] set [#component-channel] playbook [url]_`
+
+	case kind == untagHelp:
+		message = `Remove tags from a single component using the following syntax:
+
+_@[bot] untag [#component-channel] [tag1], [tag2], ..._
+
+A tag is only dropped from the database once no components are left`
+
+	case kind == migrateHelp:
+		message = `To manage the database schema, use the following syntax:
+*Show applied and pending migrations:*
+_@[bot] migrate status_
+
+*Apply pending migrations:*
+_@[bot] migrate up_`
 
 	case kind == setHelp:
 		message = `To set make adjustments for a component, use the following syntax:
 *Change Anchor:*
 _@[bot] set [#component-channel] anchor @[anchor]_
+The anchor can be a person or a user group, like @kafka-anchors
+The bot lists the tags routed to the new anchor and waits for you to confirm
+
+*Add or remove a backup anchor, or an anchor in another role:*
+_@[bot] set [#component-channel] anchor add @[anchor] role [backup, engineering, ...]_
+_@[bot] set [#component-channel] anchor remove @[anchor]_
 
 *Change playbook URL:*
-_@[bot] set [#component-channl] playbook [url]_`
+_@[bot] set [#component-channl] playbook [url]_
+
+*Describe the component:*
+_@[bot] set [#component-channel] name [display name]_
+_@[bot] set [#component-channel] description [a paragraph about the component]_
+_@[bot] set [#component-channel] area [product area]_
+Set any of them to _none_ to clear it
+
+*Add or remove a link, like docs, a dashboard or the repo:*
+_@[bot] set [#component-channel] link [label] [url]_
+_@[bot] set [#component-channel] link remove [label]_`
 
 	}
 
@@ -0,0 +1,220 @@
+/*
+Interactive messages - buttons posted by the bot, and the HTTP endpoint slack sends
+button clicks to.
+
+Commands which change a lot at once post a message listing exactly what will change,
+with Confirm and Cancel buttons, and hold the change as a pending action. Nothing is
+written until the user who ran the command confirms it. Pending actions are only kept
+in memory and expire after confirmTimeout, so a restart cancels them.
+
+Every request to the endpoint is signed by slack with the app's signing secret, which
+is checked before the payload is looked at.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nlopes/slack"
+	log "github.com/sirupsen/logrus"
+)
+
+// path of the slack interactivity request URL
+const interactionPath = "/slack/actions"
+
+// how long a confirmation stays valid
+const confirmTimeout = 10 * time.Minute
+
+// callback ID prefixes - the callback ID of an interactive message is the prefix,
+// a colon and an argument for the handler
+const callbackConfirm = "confirm"
+
+// button values of a confirmation
+const (
+	valueConfirm = "confirm"
+	valueCancel  = "cancel"
+)
+
+// signingSecret is used to verify requests from slack, see config.go
+var signingSecret string
+
+// interactionHandlers handle button clicks by callback ID prefix. The returned text
+// replaces the message holding the buttons
+var interactionHandlers = map[string]func(cb slack.InteractionCallback, arg string) string{
+	callbackConfirm:  handleConfirm,
+	callbackProposal: handleProposal,
+	callbackRedirect: handleRedirect,
+	callbackMore:     handleMore,
+}
+
+// pendingAction is a change waiting for confirmation
+type pendingAction struct {
+	user    string
+	expires time.Time
+	run     func() string // makes the change and returns the message to show
+}
+
+type pendingActions struct {
+	sync.Mutex
+	actions map[string]pendingAction
+}
+
+var pending = &pendingActions{actions: make(map[string]pendingAction)}
+
+// add stores an action and returns its ID. Expired actions are dropped on the way
+func (p *pendingActions) add(a pendingAction) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+	p.Lock()
+	defer p.Unlock()
+	now := time.Now()
+	for k, v := range p.actions {
+		if now.After(v.expires) {
+			delete(p.actions, k)
+		}
+	}
+	p.actions[id] = a
+	return id, nil
+}
+
+// take removes and returns the action if it belongs to the user and has not expired
+func (p *pendingActions) take(id, user string) (pendingAction, error) {
+	p.Lock()
+	defer p.Unlock()
+	a, ok := p.actions[id]
+	if !ok || time.Now().After(a.expires) {
+		delete(p.actions, id)
+		return a, ErrNoPendingAction
+	}
+	if a.user != user {
+		return a, ErrNotPendingActionOwner
+	}
+	delete(p.actions, id)
+	return a, nil
+}
+
+// confirm posts r.message to the user with Confirm and Cancel buttons. run is called
+// only if the user confirms in time
+func confirm(r response, run func() string) {
+	id, err := pending.add(pendingAction{user: r.user, expires: time.Now().Add(confirmTimeout), run: run})
+	if err != nil {
+		r.message = errMessage(err)
+		slackPrint(r)
+		return
+	}
+	attachment := slack.Attachment{
+		Text:       confirmQuestion,
+		CallbackID: callbackConfirm + ":" + id,
+		Color:      "warning",
+		Actions: []slack.AttachmentAction{
+			{Name: callbackConfirm, Text: "Confirm", Type: "button", Style: "danger", Value: valueConfirm},
+			{Name: callbackConfirm, Text: "Cancel", Type: "button", Value: valueCancel},
+		},
+	}
+	if _, err := postEphemeral(r.channel, r.user, r.message, attachment); err != nil {
+		log.WithFields(log.Fields{"user": r.user, "ERROR": err}).Error("Could not post confirmation")
+		pending.take(id, r.user)
+	}
+}
+
+func handleConfirm(cb slack.InteractionCallback, id string) string {
+	a, err := pending.take(id, cb.User.ID)
+	switch err {
+	case nil:
+	case ErrNoPendingAction:
+		return confirmExpired
+	case ErrNotPendingActionOwner:
+		return confirmNotOwner
+	default:
+		return errMessage(err)
+	}
+	actions := cb.ActionCallback.AttachmentActions
+	if len(actions) == 0 || actions[0].Value != valueConfirm {
+		log.WithField("user", cb.User.ID).Debug("Pending action cancelled")
+		return confirmCancelled
+	}
+	log.WithField("user", cb.User.ID).Debug("Pending action confirmed")
+	return a.run()
+}
+
+// serveInteractions listens for interactive message requests from slack
+func serveInteractions(port string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc(interactionPath, handleInteraction)
+	log.WithFields(log.Fields{"port": port, "path": interactionPath}).Info("Listening for slack interactions")
+	return http.ListenAndServe(":"+port, mux)
+}
+
+func handleInteraction(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	verifier, err := slack.NewSecretsVerifier(req.Header, signingSecret)
+	if err != nil {
+		log.WithField("ERROR", err).Warn("Rejected interaction request")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	body, err := ioutil.ReadAll(io.TeeReader(req.Body, &verifier))
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if err := verifier.Ensure(); err != nil {
+		log.WithField("ERROR", err).Warn("Rejected interaction request with a bad signature")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	var cb slack.InteractionCallback
+	if err := json.Unmarshal([]byte(form.Get("payload")), &cb); err != nil {
+		log.WithField("ERROR", err).Error("Could not parse interaction payload")
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	log.WithFields(log.Fields{"user": cb.User.ID, "callback": cb.CallbackID}).Debug("interaction:")
+
+	prefix, arg := cb.CallbackID, ""
+	if i := strings.Index(prefix, ":"); i >= 0 {
+		prefix, arg = prefix[:i], prefix[i+1:]
+	}
+	handler, ok := interactionHandlers[prefix]
+	if !ok {
+		log.WithField("callback", cb.CallbackID).Error("No handler for interaction")
+		http.Error(w, "unknown callback", http.StatusBadRequest)
+		return
+	}
+	msg := slack.Msg{Text: handler(cb, arg), ReplaceOriginal: true}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(msg); err != nil {
+		log.WithField("ERROR", err).Error("Could not write interaction response")
+	}
+}
+
+// ErrNoPendingAction is returned if a pending action does not exist or has expired
+var ErrNoPendingAction = errors.New("No pending action with this ID")
+
+// ErrNotPendingActionOwner is returned if someone other than its author confirms a pending action
+var ErrNotPendingActionOwner = errors.New("Pending action belongs to another user")
@@ -0,0 +1,106 @@
+/*
+Tests for receiving slack interactions.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+// sign returns the slack signature of a request body sent at ts
+func sign(secret, ts, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + ts + ":" + body))
+	return "v0=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestHandleInteractionSignature(t *testing.T) {
+	oldSecret := signingSecret
+	signingSecret = "s3cret"
+	interactionHandlers["test"] = func(cb slack.InteractionCallback, arg string) string {
+		return "handled " + arg
+	}
+	defer func() {
+		signingSecret = oldSecret
+		delete(interactionHandlers, "test")
+	}()
+	srv := httptest.NewServer(http.HandlerFunc(handleInteraction))
+	defer srv.Close()
+
+	body := url.Values{"payload": {`{"callback_id":"test:42","user":{"id":"U1"}}`}}.Encode()
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-10*time.Minute).Unix(), 10)
+	tests := []struct {
+		name      string
+		ts        string
+		signature string
+		body      string
+		status    int
+	}{
+		{"signed", now, sign("s3cret", now, body), body, http.StatusOK},
+		{"no signature", now, "", body, http.StatusUnauthorized},
+		{"no timestamp", "", sign("s3cret", now, body), body, http.StatusUnauthorized},
+		{"other secret", now, sign("other", now, body), body, http.StatusUnauthorized},
+		{"signature not hex", now, "v0=nothex", body, http.StatusUnauthorized},
+		{"body changed", now, sign("s3cret", now, body), body + "&x=1", http.StatusUnauthorized},
+		{"timestamp changed", stale, sign("s3cret", now, body), body, http.StatusUnauthorized},
+		{"replayed", stale, sign("s3cret", stale, body), body, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.ts != "" {
+				req.Header.Set("X-Slack-Request-Timestamp", tt.ts)
+			}
+			if tt.signature != "" {
+				req.Header.Set("X-Slack-Signature", tt.signature)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status %d, want %d", resp.StatusCode, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var msg slack.Msg
+			if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+				t.Fatal(err)
+			}
+			if msg.Text != "handled 42" || !msg.ReplaceOriginal {
+				t.Errorf("response %+v, want the handler's text replacing the original", msg)
+			}
+		})
+	}
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET status %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+}
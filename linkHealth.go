@@ -0,0 +1,343 @@
+/*
+Playbook link health.
+
+Wikis move and playbook links rot. Every linkCheckInterval a background job requests
+every playbook URL and records the HTTP status, and where the link redirects to if it
+moved permanently. When a link breaks, the anchor of its component gets a direct
+message. When a link redirects permanently, the anchor is offered to update the
+playbook to the new URL with a button. Each is only sent once per change, not on
+every check.
+
+A link is requested with HEAD, falling back to GET for servers which don't answer HEAD
+properly. Redirects are not followed blindly: permanent redirects (301 and 308) are
+followed up to maxLinkRedirects times to find the new URL, temporary ones are taken to
+mean the link works. A link which still redirects after that goes round in circles, and
+counts as broken.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nlopes/slack"
+	log "github.com/sirupsen/logrus"
+)
+
+// LinkStatus is the result of the last check of a playbook link
+type LinkStatus struct {
+	CheckedAt *time.Time
+	Status    int    // HTTP status, 0 if the link could not be reached
+	Redirect  string `gorm:"type:text"` // where the link permanently redirects to, if it does
+}
+
+// LinkStore stores the results of playbook link checks
+type LinkStore interface {
+	// GetPlaybooks returns the playbooks of every component
+	GetPlaybooks() ([]Playbook, error)
+	// GetPlaybook returns a playbook by ID, returning ErrNoPlaybook if there is none
+	GetPlaybook(id int) (Playbook, error)
+	// SetLinkStatus records the result of checking the link of a playbook
+	SetLinkStatus(id int, s LinkStatus) error
+	// ChangePlaybookURL sets the URL of a component's playbook and clears its link
+	// status, returning ErrNoPlaybook if the component has no playbook with the title
+	ChangePlaybookURL(componentChan, title, newURL string) error
+}
+
+// callback ID prefix and button values of a redirect offer
+const (
+	callbackRedirect = "redirect"
+	valueUpdate      = "update"
+	valueKeep        = "keep"
+)
+
+const (
+	linkTimeout      = 15 * time.Second
+	maxLinkRedirects = 5
+)
+
+// linkCheckInterval is how often playbook links are checked, see config.go. Links
+// are not checked if it is 0
+var linkCheckInterval = 24 * time.Hour
+
+// linkClient makes the requests of link checks
+var linkClient = &http.Client{Timeout: linkTimeout}
+
+// broken returns true if the link could not be reached or returned an error
+func (s LinkStatus) broken() bool {
+	return s.Status == 0 || s.Status >= http.StatusBadRequest
+}
+
+// checked returns true if the link has been checked
+func (s LinkStatus) checked() bool {
+	return s.CheckedAt != nil
+}
+
+// checkURL requests a link, following permanent redirects to find where it moved
+func checkURL(client *http.Client, link string, now time.Time) LinkStatus {
+	noFollow := *client
+	noFollow.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	st := LinkStatus{CheckedAt: &now}
+	current := link
+	for i := 0; i <= maxLinkRedirects; i++ {
+		res, err := requestLink(&noFollow, current)
+		if err != nil {
+			log.WithFields(log.Fields{"url": current, "ERROR": err}).Debug("Could not reach link")
+			st.Status = 0
+			return st
+		}
+		st.Status = res.StatusCode
+		if res.StatusCode != http.StatusMovedPermanently && res.StatusCode != http.StatusPermanentRedirect {
+			return st
+		}
+		next, err := res.Location()
+		if err != nil {
+			return st
+		}
+		current = next.String()
+		st.Redirect = current
+	}
+	// there's no point offering to update the link to somewhere which redirects too
+	log.WithFields(log.Fields{"url": link, "redirects": maxLinkRedirects}).Debug("Link redirects too many times")
+	return LinkStatus{CheckedAt: &now}
+}
+
+// requestLink sends a HEAD request for a link, then a GET request if HEAD fails
+func requestLink(client *http.Client, link string) (*http.Response, error) {
+	res, err := client.Head(link)
+	if err == nil {
+		res.Body.Close()
+		if res.StatusCode < http.StatusBadRequest {
+			return res, nil
+		}
+	}
+	res, err = client.Get(link)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	return res, nil
+}
+
+// sweepLinks checks every playbook link every linkCheckInterval
+func sweepLinks() {
+	if linkCheckInterval == 0 {
+		return
+	}
+	for range time.Tick(linkCheckInterval) {
+		checkLinks(linkClient, time.Now())
+	}
+}
+
+// checkLinks checks the link of every playbook, records the results and tells the
+// anchors about links which broke or moved since the last check
+func checkLinks(client *http.Client, now time.Time) {
+	playbooks, err := store.GetPlaybooks()
+	if err != nil {
+		log.WithField("ERROR", err).Error("Could not check playbook links")
+		return
+	}
+	for _, p := range playbooks {
+		st := checkURL(client, p.URL, now)
+		if err := store.SetLinkStatus(p.ID, st); err != nil {
+			log.WithFields(log.Fields{"playbook": p.ID, "ERROR": err}).Error("Could not record playbook link status")
+			continue
+		}
+		switch {
+		case st.broken() && (!p.checked() || !p.broken()):
+			log.WithFields(log.Fields{"component": p.ComponentChan, "url": p.URL, "status": st.Status}).Info("Playbook link is broken")
+			notifyAnchor(p.ComponentChan, brokenLinkFmt(p, st))
+		case !st.broken() && st.Redirect != "" && st.Redirect != p.Redirect:
+			log.WithFields(log.Fields{"component": p.ComponentChan, "url": p.URL, "redirect": st.Redirect}).Info("Playbook link moved")
+			notifyAnchor(p.ComponentChan, movedLinkFmt(p, st), slack.Attachment{
+				Text:       redirectQuestion,
+				CallbackID: fmt.Sprintf("%s:%d", callbackRedirect, p.ID),
+				Color:      "warning",
+				Actions: []slack.AttachmentAction{
+					{Name: callbackRedirect, Text: "Update", Type: "button", Style: "primary", Value: valueUpdate},
+					{Name: callbackRedirect, Text: "Keep", Type: "button", Value: valueKeep},
+				},
+			})
+		}
+	}
+}
+
+// notifyAnchor DMs who to contact about a component right now, or every member of
+// the anchor user group
+func notifyAnchor(componentChan, message string, attachments ...slack.Attachment) {
+	component, err := store.GetAnchor(componentChan)
+	if err != nil {
+		log.WithFields(log.Fields{"component": componentChan, "ERROR": err}).Error("Could not look up the anchor to notify")
+		return
+	}
+	anchor, _ := component.contact(time.Now())
+	if anchor == "" {
+		anchor = component.onDuty()
+	}
+	members, err := anchorMembers(anchor)
+	if err != nil {
+		log.WithFields(log.Fields{"anchor": anchor, "ERROR": err}).Error("Could not look up the anchor to notify")
+		return
+	}
+	for _, m := range members {
+		if err := postDM(m, message, attachments...); err != nil {
+			log.WithFields(log.Fields{"user": m, "ERROR": err}).Error("Could not notify the anchor")
+		}
+	}
+}
+
+// handleRedirect updates a playbook to the URL its link redirects to when an owner of
+// the component clicks Update
+func handleRedirect(cb slack.InteractionCallback, arg string) string {
+	id, err := strconv.Atoi(arg)
+	if err != nil {
+		return unexpectedError
+	}
+	p, err := store.GetPlaybook(id)
+	if err == ErrNoPlaybook {
+		return redirectGone
+	} else if err != nil {
+		return errMessage(err)
+	}
+	user := cb.User.ID
+	if ok, err := isOwner(user, p.ComponentChan); err != nil {
+		return errMessage(err)
+	} else if !ok {
+		return notOwner
+	}
+	actions := cb.ActionCallback.AttachmentActions
+	if len(actions) == 0 || actions[0].Value != valueUpdate {
+		return fmt.Sprintf("Kept the link of the playbook _%s_ of %s as %s", p.Title, chanFormat(p.ComponentChan), p.URL)
+	}
+	if p.Redirect == "" {
+		return redirectGone
+	}
+	if err := store.ChangePlaybookURL(p.ComponentChan, p.Title, p.Redirect); err != nil {
+		if err == ErrNoPlaybook {
+			return redirectGone
+		}
+		return errMessage(err)
+	}
+	updated := p
+	updated.URL = p.Redirect
+	r := response{user: user, channel: cb.Channel.ID}
+	change := newChange(r, fmt.Sprintf("update playbook %s of %s to its redirect", p.Title, chanFormat(p.ComponentChan)))
+	change.add(actionPlaybookURL, p.ComponentChan, "", playbookEntry(p), playbookEntry(updated))
+	recordChange(change)
+	cache.Load()
+	reindexPlaybooks(p.ComponentChan)
+	return fmt.Sprintf("Updated the playbook _%s_ of %s to %s", p.Title, chanFormat(p.ComponentChan), updated.URL)
+}
+
+// GetPlaybooks returns the playbooks of every component
+func (s *GormStore) GetPlaybooks() ([]Playbook, error) {
+	var playbooks []Playbook
+	if err := s.db.Order("id").Find(&playbooks).Error; err != nil {
+		return nil, s.fail("query playbooks", err)
+	}
+	return playbooks, nil
+}
+
+// GetPlaybook returns a playbook by ID, returning ErrNoPlaybook if there is none
+func (s *GormStore) GetPlaybook(id int) (Playbook, error) {
+	var playbooks []Playbook
+	if err := s.db.Where("id = ?", id).Find(&playbooks).Error; err != nil {
+		return Playbook{}, s.fail("query playbook", err)
+	}
+	if len(playbooks) == 0 {
+		return Playbook{}, ErrNoPlaybook
+	}
+	return playbooks[0], nil
+}
+
+// SetLinkStatus records the result of checking the link of a playbook
+func (s *GormStore) SetLinkStatus(id int, st LinkStatus) error {
+	err := s.db.Model(&Playbook{ID: id}).Updates(map[string]interface{}{
+		"checked_at": st.CheckedAt,
+		"status":     st.Status,
+		"redirect":   st.Redirect,
+	}).Error
+	if err != nil {
+		return s.fail("set link status", err)
+	}
+	return nil
+}
+
+// ChangePlaybookURL sets the URL of a component's playbook and clears its link status,
+// returning ErrNoPlaybook if the component has no playbook with the title
+func (s *GormStore) ChangePlaybookURL(componentChan, title, newURL string) error {
+	if err := checkPlaybook(Playbook{URL: newURL}); err != nil {
+		return err
+	}
+	res := s.db.Model(&Playbook{}).Where("component_chan = ? AND lower(title) = lower(?)", componentChan, title).Updates(map[string]interface{}{
+		"url":        newURL,
+		"checked_at": nil,
+		"status":     0,
+		"redirect":   "",
+	})
+	if res.Error != nil {
+		return s.fail("change playbook url", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return ErrNoPlaybook
+	}
+	log.WithFields(log.Fields{"component": componentChan, "title": title, "url": newURL}).Info("Changed playbook URL in DB")
+	return nil
+}
+
+// GetPlaybooks returns the playbooks of every component
+func (s *MemStore) GetPlaybooks() ([]Playbook, error) {
+	s.Lock()
+	defer s.Unlock()
+	return append([]Playbook(nil), s.playbooks...), nil
+}
+
+// GetPlaybook returns a playbook by ID, returning ErrNoPlaybook if there is none
+func (s *MemStore) GetPlaybook(id int) (Playbook, error) {
+	s.Lock()
+	defer s.Unlock()
+	for _, p := range s.playbooks {
+		if p.ID == id {
+			return p, nil
+		}
+	}
+	return Playbook{}, ErrNoPlaybook
+}
+
+// SetLinkStatus records the result of checking the link of a playbook
+func (s *MemStore) SetLinkStatus(id int, st LinkStatus) error {
+	s.Lock()
+	defer s.Unlock()
+	for i, p := range s.playbooks {
+		if p.ID == id {
+			s.playbooks[i].LinkStatus = st
+			return nil
+		}
+	}
+	return ErrNoPlaybook
+}
+
+// ChangePlaybookURL sets the URL of a component's playbook and clears its link status,
+// returning ErrNoPlaybook if the component has no playbook with the title
+func (s *MemStore) ChangePlaybookURL(componentChan, title, newURL string) error {
+	if err := checkPlaybook(Playbook{URL: newURL}); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	for i, p := range s.playbooks {
+		if p.ComponentChan == componentChan && sameTitle(p.Title, title) {
+			s.playbooks[i].URL = newURL
+			s.playbooks[i].LinkStatus = LinkStatus{}
+			return nil
+		}
+	}
+	return ErrNoPlaybook
+}
@@ -0,0 +1,76 @@
+/*
+Tests for playbook link checks, against a local HTTP server.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// linkServer serves the links the tests check
+func linkServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/missing", http.NotFound)
+	mux.HandleFunc("/nohead", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+	redirect := func(to string, code int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, to, code)
+		}
+	}
+	mux.HandleFunc("/moved", redirect("/moved-again", http.StatusMovedPermanently))
+	mux.HandleFunc("/moved-again", redirect("/ok", http.StatusPermanentRedirect))
+	mux.HandleFunc("/moved-away", redirect("/missing", http.StatusMovedPermanently))
+	mux.HandleFunc("/temporary", redirect("/missing", http.StatusFound))
+	mux.HandleFunc("/loop", redirect("/loop", http.StatusMovedPermanently))
+	return httptest.NewServer(mux)
+}
+
+func TestCheckURL(t *testing.T) {
+	srv := linkServer()
+	defer srv.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	now := time.Now()
+	tests := []struct {
+		name     string
+		link     string
+		status   int
+		redirect string
+		broken   bool
+	}{
+		{"ok", srv.URL + "/ok", http.StatusOK, "", false},
+		{"not found", srv.URL + "/missing", http.StatusNotFound, "", true},
+		{"no HEAD", srv.URL + "/nohead", http.StatusOK, "", false},
+		{"moved twice", srv.URL + "/moved", http.StatusOK, srv.URL + "/ok", false},
+		{"moved to a broken link", srv.URL + "/moved-away", http.StatusNotFound, srv.URL + "/missing", true},
+		{"temporary redirect", srv.URL + "/temporary", http.StatusFound, "", false},
+		{"redirect loop", srv.URL + "/loop", 0, "", true},
+		{"unreachable", closed.URL + "/ok", 0, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := checkURL(srv.Client(), tt.link, now)
+			if st.Status != tt.status || st.Redirect != tt.redirect {
+				t.Errorf("checkURL(%s) = %d %q, want %d %q", tt.link, st.Status, st.Redirect, tt.status, tt.redirect)
+			}
+			if st.broken() != tt.broken {
+				t.Errorf("checkURL(%s).broken() = %v, want %v", tt.link, st.broken(), tt.broken)
+			}
+			if !st.checked() || !st.CheckedAt.Equal(now) {
+				t.Errorf("checkURL(%s) checked at %v, want %v", tt.link, st.CheckedAt, now)
+			}
+		})
+	}
+}
@@ -0,0 +1,260 @@
+/*
+Matching the words of a question to tags.
+
+A Matcher looks at a few words of a question at a time - every single word, pair and
+triple of words - and returns the tags they may be about, each with a score from 0 to 1.
+There are matchers for:
+
+	exact     the words are a tag, or normalise to one, see normalize.go
+	alias     the words are an alias of a tag, see aliases.go
+	fuzzy     the words are close to a tag, by levenshtein ratio
+	fulltext  the words are in the name, description or area of a tag's component
+
+Matchers are combined with weights, set by the matchers setting in config.go, and the
+best weighted score of each tag is kept. A matcher with no weight is not used. By
+default exact, alias and fuzzy are used, which matches tags the way the bot always did.
+The weights only decide which match of a tag is kept: tags are ranked by the kind of
+match before the score, see tagResults.go, so no weight ranks a fuzzy match of one tag
+above an exact match of another.
+
+Matchers only need a TagSet, so they can be tried against a TagCache built from a fixed
+map of tags, without slack or a database.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"errors"
+	"sort"
+)
+
+// Candidate is a tag some words of a question may be about
+type Candidate struct {
+	Tag     string  // the canonical tag name
+	Words   string  // the words which matched
+	Score   float64 // how well they matched, 1 for a perfect match
+	Matcher string  // name of the matcher which found the tag
+}
+
+// Matcher finds the tags a few words of a question may be about
+type Matcher interface {
+	Match(words string, tags TagSet) []Candidate
+}
+
+// TagSet is the set of tags matchers look in. TagCache is one
+type TagSet interface {
+	// GetNames returns the names of every tag and alias
+	GetNames() []string
+	// Find returns the TagInfo of a tag or alias, with the canonical tag name
+	Find(name string) []TagInfo
+	// Similar returns the tags and aliases with a levenshtein ratio of at least
+	// minRatio to word, with their ratios
+	Similar(word string, minRatio float64) map[string]float64
+	// Normalized returns the tags and aliases which normalise to the same as words,
+	// see normalize.go
+	Normalized(words string) []string
+}
+
+// names of the matchers, as used in the config
+const (
+	matchExact    = "exact"
+	matchAlias    = "alias"
+	matchFuzzy    = "fuzzy"
+	matchFullText = "fulltext"
+)
+
+// matcherOrder lists the matchers from the best kind of match to the worst
+var matcherOrder = []string{matchExact, matchAlias, matchFuzzy, matchFullText}
+
+// defaultMatchers are the weights of the matchers used if none are configured
+var defaultMatchers = map[string]float64{
+	matchExact: 1,
+	matchAlias: 0.95,
+	matchFuzzy: 0.9,
+}
+
+// matcher matches the words of questions to tags, see supportBot.go
+var matcher Matcher = mustMatcher(defaultMatchers)
+
+// lookup returns the tags and aliases named words, or else the ones with the same
+// normalised form
+func lookup(words string, tags TagSet) []string {
+	if len(tags.Find(words)) != 0 {
+		return []string{words}
+	}
+	return tags.Normalized(words)
+}
+
+// exactMatcher matches tags by their name
+type exactMatcher struct{}
+
+// Match returns the tags named words
+func (exactMatcher) Match(words string, tags TagSet) []Candidate {
+	words = foldCase(words)
+	var candidates []Candidate
+	for _, name := range lookup(words, tags) {
+		if found := tags.Find(name); len(found) != 0 && found[0].Name == name {
+			candidates = append(candidates, Candidate{Tag: name, Words: words, Score: 1, Matcher: matchExact})
+		}
+	}
+	return candidates
+}
+
+// aliasMatcher matches tags by their aliases
+type aliasMatcher struct{}
+
+// Match returns the tags words are an alias of
+func (aliasMatcher) Match(words string, tags TagSet) []Candidate {
+	words = foldCase(words)
+	var candidates []Candidate
+	for _, name := range lookup(words, tags) {
+		if found := tags.Find(name); len(found) != 0 && found[0].Name != name {
+			candidates = append(candidates, Candidate{Tag: found[0].Name, Words: words, Score: 1, Matcher: matchAlias})
+		}
+	}
+	return candidates
+}
+
+// fuzzyMatcher matches tags and aliases with a levenshtein ratio of at least minRatio.
+// Words and tags shorter than minLength are too short to match fuzzily
+type fuzzyMatcher struct {
+	minRatio  float64
+	minLength int
+}
+
+// Match returns the tags close to words, unless words are a tag or alias already
+func (m fuzzyMatcher) Match(words string, tags TagSet) []Candidate {
+	words = foldCase(words)
+	if len(words) < m.minLength || len(lookup(words, tags)) != 0 {
+		return nil
+	}
+	var candidates []Candidate
+	for t, ratio := range tags.Similar(words, m.minRatio) {
+		if len(t) < m.minLength {
+			continue
+		}
+		if found := tags.Find(t); len(found) != 0 {
+			candidates = append(candidates, Candidate{Tag: found[0].Name, Words: words, Score: ratio, Matcher: matchFuzzy})
+		}
+	}
+	sortCandidates(candidates)
+	return candidates
+}
+
+// fullTextMatcher matches the tags of components whose name, description or area
+// contain all of the words
+type fullTextMatcher struct{}
+
+// Match returns the tags of the components described with words
+func (fullTextMatcher) Match(words string, tags TagSet) []Candidate {
+	query := indexWords(words)
+	if len(query) == 0 {
+		return nil
+	}
+	var candidates []Candidate
+	for _, t := range tags.GetNames() {
+		for _, info := range tags.Find(t) {
+			if info.Name != t {
+				break // an alias
+			}
+			if containsWords(info.DisplayName+" "+info.Description+" "+info.Area, query) {
+				candidates = append(candidates, Candidate{Tag: t, Words: foldCase(words), Score: 1, Matcher: matchFullText})
+				break
+			}
+		}
+	}
+	return candidates
+}
+
+// containsWords returns true if text contains every one of the indexed words
+func containsWords(text string, words []string) bool {
+	has := make(map[string]bool)
+	for _, w := range indexWords(text) {
+		has[w] = true
+	}
+	for _, w := range words {
+		if !has[w] {
+			return false
+		}
+	}
+	return true
+}
+
+// weightedMatcher is a matcher whose scores count for weight
+type weightedMatcher struct {
+	Matcher
+	weight float64
+}
+
+// compositeMatcher combines matchers, keeping the best weighted score of each tag
+type compositeMatcher []weightedMatcher
+
+// Match returns the tags any of the matchers found, best first
+func (c compositeMatcher) Match(words string, tags TagSet) []Candidate {
+	best := make(map[string]Candidate)
+	for _, m := range c {
+		for _, cand := range m.Match(words, tags) {
+			cand.Score *= m.weight
+			if b, ok := best[cand.Tag]; !ok || cand.Score > b.Score {
+				best[cand.Tag] = cand
+			}
+		}
+	}
+	candidates := make([]Candidate, 0, len(best))
+	for _, cand := range best {
+		candidates = append(candidates, cand)
+	}
+	sortCandidates(candidates)
+	return candidates
+}
+
+// sortCandidates sorts candidates best first
+func sortCandidates(candidates []Candidate) {
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Score != candidates[j].Score {
+			return candidates[i].Score > candidates[j].Score
+		}
+		return candidates[i].Tag < candidates[j].Tag
+	})
+}
+
+// newMatcher combines the matchers with a weight above 0, returning ErrNoMatcher for
+// names which aren't matchers
+func newMatcher(weights map[string]float64) (Matcher, error) {
+	var c compositeMatcher
+	for name := range weights {
+		if _, ok := matcherNames[name]; !ok {
+			return nil, ErrNoMatcher
+		}
+	}
+	// in a fixed order, so equal scores are resolved the same way every time
+	for _, name := range matcherOrder {
+		if w := weights[name]; w > 0 {
+			c = append(c, weightedMatcher{Matcher: matcherNames[name](), weight: w})
+		}
+	}
+	return c, nil
+}
+
+// matcherNames returns a new matcher by name. The fuzzy matcher takes the current
+// matchDistPercent and minWordLength
+var matcherNames = map[string]func() Matcher{
+	matchExact:    func() Matcher { return exactMatcher{} },
+	matchAlias:    func() Matcher { return aliasMatcher{} },
+	matchFuzzy:    func() Matcher { return fuzzyMatcher{minRatio: matchDistPercent, minLength: minWordLength} },
+	matchFullText: func() Matcher { return fullTextMatcher{} },
+}
+
+// mustMatcher is newMatcher for weights known to be valid
+func mustMatcher(weights map[string]float64) Matcher {
+	m, err := newMatcher(weights)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// ErrNoMatcher is returned for a matcher name which doesn't exist
+var ErrNoMatcher = errors.New("No such matcher - use exact, alias, fuzzy or fulltext")
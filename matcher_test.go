@@ -0,0 +1,138 @@
+/*
+Tests for matching the words of a question to a fixed set of tags.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// newMatcherCache returns the cache the matchers are tried against: four components,
+// one of them with an alias and two described
+func newMatcherCache(t *testing.T) *TagCache {
+	c := newTestCache(t, map[string][]string{
+		"kafka":         {"C1"},
+		"postgres":      {"C2"},
+		"elasticsearch": {"C3"},
+		"big data":      {"C4"},
+	})
+	s := c.store.(*MemStore)
+	for _, info := range []struct{ componentChan, field, value string }{
+		{"C1", infoName, "Kafka"},
+		{"C1", infoDescription, "Streams of events between services"},
+		{"C1", infoArea, "data"},
+		{"C3", infoDescription, "Search index of the logs"},
+		{"C4", infoArea, "data"},
+	} {
+		if err := s.ChangeInfo(info.componentChan, info.field, info.value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.AddAlias("pg", "postgres"); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestMatchers(t *testing.T) {
+	tags := newMatcherCache(t)
+	fuzzy := fuzzyMatcher{minRatio: 0.8, minLength: 4}
+	tests := []struct {
+		name    string
+		matcher Matcher
+		words   string
+		want    []Candidate
+	}{
+		{"exact", exactMatcher{}, "Kafka", []Candidate{{"kafka", "kafka", 1, matchExact}}},
+		{"exact normalised", exactMatcher{}, "big-data", []Candidate{{"big data", "big-data", 1, matchExact}}},
+		{"exact misses aliases", exactMatcher{}, "pg", nil},
+		{"exact misses typos", exactMatcher{}, "kafak", nil},
+		{"alias", aliasMatcher{}, "PG", []Candidate{{"postgres", "pg", 1, matchAlias}}},
+		{"alias misses tags", aliasMatcher{}, "postgres", nil},
+		{"fuzzy", fuzzy, "kafak", []Candidate{{"kafka", "kafak", 0.8, matchFuzzy}}},
+		{"fuzzy by one letter", fuzzy, "elasticsearh", []Candidate{{"elasticsearch", "elasticsearh", 0.96, matchFuzzy}}},
+		{"fuzzy misses tags", fuzzy, "kafka", nil},
+		{"fuzzy misses short words", fuzzy, "pgg", nil},
+		{"fuzzy misses distant words", fuzzy, "kayak", nil},
+		{"fulltext", fullTextMatcher{}, "the logs", []Candidate{{"elasticsearch", "the logs", 1, matchFullText}}},
+		{"fulltext stems", fullTextMatcher{}, "event streaming", []Candidate{{"kafka", "event streaming", 1, matchFullText}}},
+		{"fulltext needs every word", fullTextMatcher{}, "logs events", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.matcher.Match(tt.words, tags); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Match(%q) = %+v, want %+v", tt.words, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatcherWeights(t *testing.T) {
+	tags := newMatcherCache(t)
+	defer func(ratio float64, length int) {
+		matchDistPercent, minWordLength = ratio, length
+	}(matchDistPercent, minWordLength)
+	matchDistPercent, minWordLength = 0.8, 4
+	kafak := 0.8 // the ratio of kafak to kafka
+	tests := []struct {
+		name    string
+		weights map[string]float64
+		words   string
+		want    []Candidate
+	}{
+		{"exact", defaultMatchers, "kafka", []Candidate{{"kafka", "kafka", 1, matchExact}}},
+		{"alias", defaultMatchers, "pg", []Candidate{{"postgres", "pg", 0.95, matchAlias}}},
+		{"fuzzy", defaultMatchers, "kafak", []Candidate{{"kafka", "kafak", kafak * defaultMatchers[matchFuzzy], matchFuzzy}}},
+		{"best weight kept", map[string]float64{matchExact: 1, matchFullText: 0.5}, "kafka", []Candidate{{"kafka", "kafka", 1, matchExact}}},
+		{"best weight kept, reversed", map[string]float64{matchExact: 0.5, matchFullText: 1}, "kafka", []Candidate{{"kafka", "kafka", 1, matchFullText}}},
+		{"best first", map[string]float64{matchExact: 0.5, matchFullText: 1}, "data", []Candidate{
+			{"big data", "data", 1, matchFullText},
+			{"kafka", "data", 1, matchFullText},
+		}},
+		{"no weight", map[string]float64{matchExact: 1, matchFuzzy: 0}, "kafak", nil},
+		{"no matchers", map[string]float64{}, "kafka", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := newMatcher(tt.weights)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := m.Match(tt.words, tags)
+			if len(got) == 0 && len(tt.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Match(%q) = %+v, want %+v", tt.words, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewMatcher(t *testing.T) {
+	for _, weights := range []map[string]float64{
+		{"exact": 1, "soundex": 1},
+		{"Exact": 1},
+		{"": 0},
+	} {
+		if m, err := newMatcher(weights); err != ErrNoMatcher || m != nil {
+			t.Errorf("newMatcher(%v) = %v, %v, want ErrNoMatcher", weights, m, err)
+		}
+	}
+	m, err := newMatcher(map[string]float64{matchFullText: 1, matchExact: 0.5, matchFuzzy: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// in a fixed order, whatever the order of the weights
+	want := compositeMatcher{{exactMatcher{}, 0.5}, {fullTextMatcher{}, 1}}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("newMatcher = %+v, want %+v", m, want)
+	}
+}
@@ -0,0 +1,439 @@
+/*
+Versioned schema migrations for the gorm backed store.
+
+Migrations are numbered and forward only. Each one runs in a transaction and is
+recorded in the schema_migrations table, so it is applied exactly once. New schema
+changes must be added to the end of the migrations list - never edit a migration
+which has been released. Migrations use their own frozen copies of the models so
+later changes to Component or Tag do not change what an old migration does.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+)
+
+// Migrator is implemented by stores with a versioned schema
+type Migrator interface {
+	// MigrationStatus returns every known migration and when it was applied
+	MigrationStatus() ([]MigrationStatus, error)
+	// MigrateUp applies all pending migrations and returns the ones applied
+	MigrateUp() ([]MigrationStatus, error)
+}
+
+// MigrationStatus describes a migration. AppliedAt is nil if it is pending
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// schemaMigration is the record of an applied migration
+type schemaMigration struct {
+	Version   int    `gorm:"primary_key;auto_increment:false"`
+	Name      string `gorm:"type:varchar(100)"`
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+type migration struct {
+	version int
+	name    string
+	up      func(tx *gorm.DB) error
+}
+
+// migrations must be ordered by version
+var migrations = []migration{
+	{1, "create components, tags and tag_components", migrateBaseline},
+	{2, "unique tag names and component channels", migrateUniqueNames},
+	{3, "create changes and audit_entries", migrateAuditTrail},
+	{4, "track reverted changes", migrateRevertOf},
+	{5, "create roles", migrateRoles},
+	{6, "create proposals", migrateProposals},
+	{7, "create component_anchors", migrateComponentAnchors},
+	{8, "create rotations and anchor_overrides", migrateSchedules},
+	{9, "create absences", migrateAbsences},
+	{10, "create tag_aliases", migrateTagAliases},
+	{11, "add component info and create component_links", migrateComponentInfo},
+	{12, "create playbooks from components.playbook_url", migratePlaybooks},
+	{13, "add link status to playbooks", migrateLinkStatus},
+	{14, "unique playbook titles per component", migrateUniquePlaybooks},
+}
+
+// MigrationStatus returns every known migration and when it was applied
+func (s *GormStore) MigrationStatus() ([]MigrationStatus, error) {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	var status []MigrationStatus
+	for _, m := range migrations {
+		st := MigrationStatus{Version: m.version, Name: m.name}
+		if a, ok := applied[m.version]; ok {
+			st.AppliedAt = &a.AppliedAt
+		}
+		status = append(status, st)
+	}
+	return status, nil
+}
+
+// MigrateUp applies all pending migrations in order. Each migration runs in its
+// own transaction; if one fails, the ones before it stay applied
+func (s *GormStore) MigrateUp() ([]MigrationStatus, error) {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	var done []MigrationStatus
+	for _, m := range migrations {
+		if _, ok := applied[m.version]; ok {
+			continue
+		}
+		log.WithFields(log.Fields{"version": m.version, "name": m.name}).Info("applying migration")
+		record := schemaMigration{Version: m.version, Name: m.name, AppliedAt: time.Now()}
+		tx := s.db.Begin()
+		if err := m.up(tx); err != nil {
+			tx.Rollback()
+			log.WithFields(log.Fields{"version": m.version, "name": m.name}).Error("the migration has failed")
+			return done, s.fail("migrate", err)
+		}
+		if err := tx.Create(&record).Error; err != nil {
+			tx.Rollback()
+			return done, s.fail("record migration", err)
+		}
+		if err := tx.Commit().Error; err != nil {
+			return done, s.fail("commit migration", err)
+		}
+		done = append(done, MigrationStatus{Version: m.version, Name: m.name, AppliedAt: &record.AppliedAt})
+	}
+	return done, nil
+}
+
+// appliedMigrations returns the applied migrations by version, creating the
+// schema_migrations table if needed
+func (s *GormStore) appliedMigrations() (map[int]schemaMigration, error) {
+	if err := s.db.AutoMigrate(&schemaMigration{}).Error; err != nil {
+		return nil, s.fail("create schema_migrations", err)
+	}
+	var records []schemaMigration
+	if err := s.db.Find(&records).Error; err != nil {
+		return nil, s.fail("query schema_migrations", err)
+	}
+	applied := make(map[int]schemaMigration)
+	for _, r := range records {
+		applied[r.Version] = r
+	}
+	return applied, nil
+}
+
+// Schema as of migration 1. This matches what AutoMigrate created before versioned
+// migrations existed, so it is a no-op on those databases
+
+type schemaV1Component struct {
+	ID            int
+	AnchorSlackID string `gorm:"type:varchar(20)"`
+	PlaybookURL   string `gorm:"type:varchar(100)"`
+	ComponentChan string `gorm:"type:varchar(20)"`
+	SupportChan   string `gorm:"type:varchar(20)"`
+}
+
+func (schemaV1Component) TableName() string {
+	return "components"
+}
+
+type schemaV1Tag struct {
+	ID   int
+	Name string `gorm:"type:varchar(50)"`
+}
+
+func (schemaV1Tag) TableName() string {
+	return "tags"
+}
+
+type schemaV1TagComponent struct {
+	TagID       int `gorm:"primary_key;auto_increment:false"`
+	ComponentID int `gorm:"primary_key;auto_increment:false"`
+}
+
+func (schemaV1TagComponent) TableName() string {
+	return "tag_components"
+}
+
+func migrateBaseline(tx *gorm.DB) error {
+	return tx.AutoMigrate(&schemaV1Component{}, &schemaV1Tag{}, &schemaV1TagComponent{}).Error
+}
+
+// migrateUniqueNames fails if there are already duplicate tag names or component
+// channels - these need to be merged by hand before upgrading
+func migrateUniqueNames(tx *gorm.DB) error {
+	if err := tx.Model(&schemaV1Tag{}).AddUniqueIndex("idx_tags_name", "name").Error; err != nil {
+		return err
+	}
+	return tx.Model(&schemaV1Component{}).AddUniqueIndex("idx_components_component_chan", "component_chan").Error
+}
+
+// Schema added in migration 3
+
+type schemaV3Change struct {
+	ID           int
+	CreatedAt    time.Time
+	ActorSlackID string `gorm:"type:varchar(20)"`
+	Channel      string `gorm:"type:varchar(20)"`
+	Command      string `gorm:"type:text"`
+}
+
+func (schemaV3Change) TableName() string {
+	return "changes"
+}
+
+type schemaV3AuditEntry struct {
+	ID            int
+	ChangeID      int    `gorm:"index"`
+	Action        string `gorm:"type:varchar(20)"`
+	ComponentChan string `gorm:"type:varchar(20);index"`
+	Tag           string `gorm:"type:varchar(50);index"`
+	Before        string `gorm:"type:text"`
+	After         string `gorm:"type:text"`
+}
+
+func (schemaV3AuditEntry) TableName() string {
+	return "audit_entries"
+}
+
+func migrateAuditTrail(tx *gorm.DB) error {
+	return tx.AutoMigrate(&schemaV3Change{}, &schemaV3AuditEntry{}).Error
+}
+
+// Schema added in migration 4
+
+type schemaV4Change struct {
+	RevertOf int `gorm:"not null;default:0;index"`
+}
+
+func (schemaV4Change) TableName() string {
+	return "changes"
+}
+
+func migrateRevertOf(tx *gorm.DB) error {
+	return tx.AutoMigrate(&schemaV4Change{}).Error
+}
+
+// Schema added in migration 5
+
+type schemaV5Role struct {
+	ID            int
+	SlackID       string `gorm:"type:varchar(20);unique_index:idx_roles_user_role"`
+	Name          string `gorm:"type:varchar(20);unique_index:idx_roles_user_role"`
+	ComponentChan string `gorm:"type:varchar(20);unique_index:idx_roles_user_role;index"`
+}
+
+func (schemaV5Role) TableName() string {
+	return "roles"
+}
+
+func migrateRoles(tx *gorm.DB) error {
+	return tx.AutoMigrate(&schemaV5Role{}).Error
+}
+
+// Schema added in migration 6
+
+type schemaV6Proposal struct {
+	ID              int
+	CreatedAt       time.Time
+	ExpiresAt       time.Time `gorm:"index"`
+	ProposerSlackID string    `gorm:"type:varchar(20)"`
+	Channel         string    `gorm:"type:varchar(20)"`
+	ComponentChan   string    `gorm:"type:varchar(20)"`
+	Tags            string    `gorm:"type:text"`
+	Status          string    `gorm:"type:varchar(20);index"`
+	DeciderSlackID  string    `gorm:"type:varchar(20)"`
+}
+
+func (schemaV6Proposal) TableName() string {
+	return "proposals"
+}
+
+func migrateProposals(tx *gorm.DB) error {
+	return tx.AutoMigrate(&schemaV6Proposal{}).Error
+}
+
+// Schema added in migration 7
+
+type schemaV7ComponentAnchor struct {
+	ID            int
+	ComponentChan string `gorm:"type:varchar(20);unique_index:idx_component_anchors_chan_user"`
+	SlackID       string `gorm:"type:varchar(20);unique_index:idx_component_anchors_chan_user"`
+	Role          string `gorm:"type:varchar(20)"`
+}
+
+func (schemaV7ComponentAnchor) TableName() string {
+	return "component_anchors"
+}
+
+func migrateComponentAnchors(tx *gorm.DB) error {
+	return tx.AutoMigrate(&schemaV7ComponentAnchor{}).Error
+}
+
+// Schema added in migration 8
+
+type schemaV8Rotation struct {
+	ID            int
+	ComponentChan string        `gorm:"type:varchar(20);unique_index"`
+	Members       string        `gorm:"type:text"`
+	Period        time.Duration `gorm:"type:bigint"`
+	Start         time.Time
+}
+
+func (schemaV8Rotation) TableName() string {
+	return "rotations"
+}
+
+type schemaV8AnchorOverride struct {
+	ID            int
+	CreatedAt     time.Time
+	ComponentChan string `gorm:"type:varchar(20);index"`
+	SlackID       string `gorm:"type:varchar(20)"`
+	Until         time.Time
+}
+
+func (schemaV8AnchorOverride) TableName() string {
+	return "anchor_overrides"
+}
+
+func migrateSchedules(tx *gorm.DB) error {
+	return tx.AutoMigrate(&schemaV8Rotation{}, &schemaV8AnchorOverride{}).Error
+}
+
+// Schema added in migration 9
+
+type schemaV9Absence struct {
+	ID        int
+	CreatedAt time.Time
+	SlackID   string `gorm:"type:varchar(20);unique_index:idx_absences_user_source"`
+	Source    string `gorm:"type:varchar(20);unique_index:idx_absences_user_source"`
+	Until     time.Time
+}
+
+func (schemaV9Absence) TableName() string {
+	return "absences"
+}
+
+func migrateAbsences(tx *gorm.DB) error {
+	return tx.AutoMigrate(&schemaV9Absence{}).Error
+}
+
+// Schema added in migration 10
+
+type schemaV10TagAlias struct {
+	ID      int
+	Name    string `gorm:"type:varchar(50);unique_index"`
+	TagName string `gorm:"type:varchar(50);index"`
+}
+
+func (schemaV10TagAlias) TableName() string {
+	return "tag_aliases"
+}
+
+func migrateTagAliases(tx *gorm.DB) error {
+	return tx.AutoMigrate(&schemaV10TagAlias{}).Error
+}
+
+// Schema as of migration 11
+
+type schemaV11Component struct {
+	ID            int
+	AnchorSlackID string `gorm:"type:varchar(20)"`
+	PlaybookURL   string `gorm:"type:varchar(100)"`
+	ComponentChan string `gorm:"type:varchar(20)"`
+	SupportChan   string `gorm:"type:varchar(20)"`
+	DisplayName   string `gorm:"type:varchar(50)"`
+	Description   string `gorm:"type:text"`
+	Area          string `gorm:"type:varchar(30)"`
+}
+
+func (schemaV11Component) TableName() string {
+	return "components"
+}
+
+type schemaV11ComponentLink struct {
+	ID            int
+	ComponentChan string `gorm:"type:varchar(20);unique_index:idx_component_links_chan_label"`
+	Label         string `gorm:"type:varchar(30);unique_index:idx_component_links_chan_label"`
+	URL           string `gorm:"type:varchar(200)"`
+}
+
+func (schemaV11ComponentLink) TableName() string {
+	return "component_links"
+}
+
+func migrateComponentInfo(tx *gorm.DB) error {
+	return tx.AutoMigrate(&schemaV11Component{}, &schemaV11ComponentLink{}).Error
+}
+
+// Schema added in migration 12. The playbook_url column of components is copied to
+// playbooks and left in place, no longer used
+
+type schemaV12Playbook struct {
+	ID            int
+	ComponentChan string `gorm:"type:varchar(20);index"`
+	Title         string `gorm:"type:varchar(100)"`
+	URL           string `gorm:"type:text"`
+	Tags          string `gorm:"type:text"`
+}
+
+func (schemaV12Playbook) TableName() string {
+	return "playbooks"
+}
+
+func migratePlaybooks(tx *gorm.DB) error {
+	if err := tx.AutoMigrate(&schemaV12Playbook{}).Error; err != nil {
+		return err
+	}
+	var components []schemaV11Component
+	if err := tx.Where("playbook_url IS NOT NULL AND playbook_url <> ''").Find(&components).Error; err != nil {
+		return err
+	}
+	for _, c := range components {
+		p := schemaV12Playbook{ComponentChan: c.ComponentChan, Title: "Playbook", URL: c.PlaybookURL}
+		if err := tx.Create(&p).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Schema as of migration 13
+
+type schemaV13Playbook struct {
+	ID            int
+	ComponentChan string `gorm:"type:varchar(20);index"`
+	Title         string `gorm:"type:varchar(100)"`
+	URL           string `gorm:"type:text"`
+	Tags          string `gorm:"type:text"`
+	CheckedAt     *time.Time
+	Status        int
+	Redirect      string `gorm:"type:text"`
+}
+
+func (schemaV13Playbook) TableName() string {
+	return "playbooks"
+}
+
+func migrateLinkStatus(tx *gorm.DB) error {
+	return tx.AutoMigrate(&schemaV13Playbook{}).Error
+}
+
+// migrateUniquePlaybooks fails if a component already has two playbooks with the
+// same title - these need to be renamed or removed by hand before upgrading
+func migrateUniquePlaybooks(tx *gorm.DB) error {
+	return tx.Model(&schemaV13Playbook{}).AddUniqueIndex("idx_playbooks_component_chan_title", "component_chan", "title").Error
+}
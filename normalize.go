@@ -0,0 +1,113 @@
+/*
+Normalising questions and tags, so the way something is written doesn't stop it from
+matching. Text goes through these steps:
+
+1. Slack markup like <#C123|channel>, <@U123> and links is dropped
+2. Case is folded, so Kafka, KAFKA and kafka are the same
+3. Punctuation splits words, and apostrophes are dropped, so "kafka?" is kafka and
+   "kafka's" is kafkas. Only #, + and . are kept inside a word, so c#, c++, node.js
+   and .net stay apart from c, js and net, but "#kafka" and "kafka." are kafka
+4. Stop words are dropped, see the stop_words setting in config.go
+5. Words are reduced to their stem, so upgrades and upgrade are both upgrad, see porter.go
+
+The words of a question after step 4 are what tags are matched with, and they are
+shown as the matched words. Tags and aliases are matched by their normalised form as
+well as their name, see TagCache.Normalized. Tag names themselves are only stored with
+their case folded and the punctuation around them trimmed, since they are shown as they
+are.
+
+The playbook index uses all five steps for the words of playbooks and questions alike.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// defaultStopWords are the words left out of questions, unless the stop_words setting
+// says otherwise
+var defaultStopWords = []string{
+	"a", "an", "and", "are", "as", "at", "be", "by", "can", "do", "does", "for", "from",
+	"has", "have", "how", "i", "if", "in", "is", "it", "me", "my", "of", "on", "or",
+	"please", "the", "this", "that", "to", "we", "what", "when", "where", "who", "why",
+	"with", "you",
+}
+
+// stopWords are the words left out of questions, see config.go
+var stopWords = wordSet(defaultStopWords)
+
+var (
+	regSlackMarkup = regexp.MustCompile(`<[^>]*>`)
+	slackEntities  = strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">")
+	apostrophes    = strings.NewReplacer("'", "", "’", "")
+)
+
+// punctuation trimmed from around tag names. Other punctuation is part of names like
+// c# and node.js. A full stop is only trimmed from the end, for names like .net
+const tagPunctuation = `?!,;:."'()[]{}“”‘’…`
+
+// symbols which are part of a word of a question, see queryWords
+const wordSymbols = "#+."
+
+// wordSet returns the set of case folded words
+func wordSet(words []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range words {
+		set[foldCase(w)] = true
+	}
+	return set
+}
+
+// foldCase folds the case of text, so letters with several lower case forms, like
+// the final sigma, end up the same
+func foldCase(text string) string {
+	return strings.Map(func(r rune) rune {
+		return unicode.ToLower(unicode.ToUpper(r))
+	}, text)
+}
+
+// queryWords returns the words of text without markup, punctuation and stop words,
+// case folded
+func queryWords(text string) []string {
+	text = slackEntities.Replace(regSlackMarkup.ReplaceAllString(text, " "))
+	text = apostrophes.Replace(foldCase(text))
+	var words []string
+	for _, w := range strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune(wordSymbols, r)
+	}) {
+		// a word starting with # or + or ending with a full stop is punctuated
+		w = strings.TrimRight(strings.TrimLeft(w, "#+"), ".")
+		if w != "" && !stopWords[w] {
+			words = append(words, w)
+		}
+	}
+	return words
+}
+
+// stems returns the stems of the words of text, see queryWords
+func stems(text string) []string {
+	words := queryWords(text)
+	for i, w := range words {
+		words[i] = stem(w)
+	}
+	return words
+}
+
+// normalKey returns the normalised form of a tag or the words of a question, "" if
+// nothing is left of them
+func normalKey(text string) string {
+	return strings.Join(stems(text), " ")
+}
+
+// cleanTag returns a tag name as it is stored: without markup and the punctuation
+// around it, case folded
+func cleanTag(name string) string {
+	name = foldCase(regSlackMarkup.ReplaceAllString(name, " "))
+	name = strings.TrimLeft(name, strings.Replace(tagPunctuation, ".", "", 1)+" ")
+	return strings.Join(strings.Fields(strings.TrimRight(name, tagPunctuation+" ")), " ")
+}
@@ -0,0 +1,113 @@
+/*
+Tests for normalising questions and tags.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestQueryWords(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Is <#C1|kafka> KAFKA down?", []string{"kafka", "down"}},
+		{"Kafka's upgrades, please", []string{"kafkas", "upgrades"}},
+		{"c# or C++ and c", []string{"c#", "c++", "c"}},
+		{"node.js or .NET or js.", []string{"node.js", ".net", "js"}},
+		{"#kafka +1 kafka... c#.", []string{"kafka", "1", "kafka", "c#"}},
+		{"big-data (hadoop)", []string{"big", "data", "hadoop"}},
+		{"... + # ?", nil},
+	}
+	for _, tt := range tests {
+		if got := queryWords(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("queryWords(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestNormalKey(t *testing.T) {
+	same := [][]string{
+		{"kafka upgrades", "Kafka upgrade", "kafka, upgrading?"},
+		{"c#", "C#", "c#?"},
+		{"node.js", "Node.js."},
+	}
+	for _, names := range same {
+		for _, n := range names[1:] {
+			if normalKey(n) != normalKey(names[0]) {
+				t.Errorf("normalKey(%q) = %q, want %q like %q", n, normalKey(n), normalKey(names[0]), names[0])
+			}
+		}
+	}
+	different := []string{"c", "c#", "c++", "js", "node", "node.js", "net", ".net"}
+	keys := make(map[string]string)
+	for _, n := range different {
+		k := normalKey(n)
+		if other, ok := keys[k]; ok {
+			t.Errorf("normalKey(%q) = normalKey(%q) = %q", n, other, k)
+		}
+		keys[k] = n
+	}
+}
+
+func TestCleanTag(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"  Kafka  Connect. ", "kafka connect"},
+		{"“big data”", "big data"},
+		{"C#", "c#"},
+		{"c++,", "c++"},
+		{"(node.js)", "node.js"},
+		{".NET", ".net"},
+		{"<#C1|kafka>", ""},
+	}
+	for _, tt := range tests {
+		if got := cleanTag(tt.name); got != tt.want {
+			t.Errorf("cleanTag(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizedTags(t *testing.T) {
+	c := newTestCache(t, map[string][]string{
+		"c":       {"C1"},
+		"c#":      {"C2"},
+		"c++":     {"C3"},
+		"node.js": {"C4"},
+		"node":    {"C5"},
+		".net":    {"C6"},
+	})
+	matcher = mustMatcher(defaultMatchers)
+	tests := []struct {
+		question string
+		chans    []string
+	}{
+		{"<@B> who knows C#?", []string{"C2"}},
+		{"<@B> is c++ supported", []string{"C3"}},
+		{"<@B> c or c#", []string{"C1", "C2"}},
+		{"<@B> upgrading Node.js.", []string{"C4"}},
+		{"<@B> my node crashed", []string{"C5"}},
+		{"<@B> .NET builds", []string{"C6"}},
+		{"<@B> dotnet", nil},
+	}
+	for _, tt := range tests {
+		var chans []string
+		for _, m := range tagMatch(strings.Fields(tt.question)) {
+			chans = append(chans, m.tag().ComponentChan)
+		}
+		if !reflect.DeepEqual(chans, tt.chans) {
+			t.Errorf("tagMatch(%q) = %v, want %v", tt.question, chans, tt.chans)
+		}
+	}
+	if got := c.Normalized("C#?"); !reflect.DeepEqual(got, []string{"c#"}) {
+		t.Errorf("Normalized(C#?) = %q", got)
+	}
+}
@@ -0,0 +1,264 @@
+/*
+Permissions for commands which change routing data.
+
+There are three levels of access:
+
+1. Admins may change anything. Admins are listed in the config, or granted in the
+   database with @bot grant @user admin
+2. Owners of a component may change its tags, anchors, rotation and playbook, and grant
+   or revoke its maintainers. The anchors of a component, including everyone on its
+   rotation or an active override and the members of an anchoring user group, and
+   its maintainers are its owners
+3. Everyone else has read-only access
+
+Denied attempts are recorded in the audit trail.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+)
+
+// Role gives a slack user more than read-only access
+type Role struct {
+	ID            int
+	SlackID       string `gorm:"type:varchar(20)"`
+	Name          string `gorm:"type:varchar(20)"`
+	ComponentChan string `gorm:"type:varchar(20)"` // empty for global roles
+}
+
+// role names
+const (
+	roleAdmin      = "admin"
+	roleMaintainer = "maintainer"
+)
+
+// permission recorded for denied attempts on a component
+const permOwner = "owner"
+
+// PermissionStore stores the roles granted to users
+type PermissionStore interface {
+	// GrantRole adds a role, returning ErrRoleExists if the user already has it
+	GrantRole(r Role) error
+	// RevokeRole removes a role, returning ErrNoRole if the user does not have it
+	RevokeRole(r Role) error
+	// HasRole returns true if the user has the role
+	HasRole(r Role) (bool, error)
+	// ListRoles returns the roles on a component channel, or the global roles for ""
+	ListRoles(componentChan string) ([]Role, error)
+}
+
+// isAdmin returns true if the user is one of the configured bot admins, or has been
+// granted the admin role
+func isAdmin(user string) (bool, error) {
+	for _, a := range admins {
+		if strings.EqualFold(a, user) {
+			return true, nil
+		}
+	}
+	return store.HasRole(Role{SlackID: user, Name: roleAdmin})
+}
+
+// isOwner returns true if the user is an admin, or anchors or maintains the component
+func isOwner(user, componentChan string) (bool, error) {
+	if ok, err := isAdmin(user); ok || err != nil {
+		return ok, err
+	}
+	component, err := store.GetAnchor(componentChan)
+	if err != nil {
+		return false, err
+	}
+	for _, anchor := range component.anchorIDs() {
+		members, err := anchorMembers(anchor)
+		if err != nil {
+			return false, err
+		}
+		for _, m := range members {
+			if m == user {
+				return true, nil
+			}
+		}
+	}
+	return store.HasRole(Role{SlackID: user, Name: roleMaintainer, ComponentChan: componentChan})
+}
+
+// authorize checks that the user in the response owns every one of the component
+// channels, or is an admin if none are given. Denied attempts are recorded in the
+// audit trail and reported to the user
+func authorize(r response, command string, componentChans ...string) bool {
+	var (
+		ok  = true
+		err error
+	)
+	if len(componentChans) == 0 {
+		ok, err = isAdmin(r.user)
+	}
+	for _, componentChan := range componentChans {
+		if ok, err = isOwner(r.user, componentChan); !ok || err != nil {
+			break
+		}
+	}
+	if err != nil {
+		r.message = errMessage(err)
+		slackPrint(r)
+		return false
+	}
+	if ok {
+		return true
+	}
+
+	change := newChange(r, command)
+	if len(componentChans) == 0 {
+		change.add(actionDenied, "", "", "", roleAdmin)
+		r.message = notAdmin
+	} else {
+		for _, componentChan := range componentChans {
+			change.add(actionDenied, componentChan, "", "", permOwner)
+		}
+		r.message = notOwner
+	}
+	recordChange(change)
+	log.WithFields(log.Fields{"user": r.user, "command": command}).Info("Permission denied")
+	slackPrint(r)
+	return false
+}
+
+// roleEntry returns the audit entry value for a role
+func roleEntry(r Role) string {
+	return r.SlackID + " " + r.Name
+}
+
+// entryRole returns the role described by an audit entry for a grant or revoke
+func entryRole(e AuditEntry) Role {
+	v := e.After
+	if e.Action == actionRevoke {
+		v = e.Before
+	}
+	r := Role{ComponentChan: e.ComponentChan}
+	if f := strings.Fields(v); len(f) == 2 {
+		r.SlackID, r.Name = f[0], f[1]
+	}
+	return r
+}
+
+// GrantRole adds a role, returning ErrRoleExists if the user already has it
+func (s *GormStore) GrantRole(r Role) error {
+	ok, err := s.HasRole(r)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return ErrRoleExists
+	}
+	if err := s.db.Create(&r).Error; err != nil {
+		return s.fail("grant role", err)
+	}
+	log.WithFields(log.Fields{"user": r.SlackID, "role": r.Name, "component": r.ComponentChan}).Info("granted role")
+	return nil
+}
+
+// RevokeRole removes a role, returning ErrNoRole if the user does not have it
+func (s *GormStore) RevokeRole(r Role) error {
+	res := s.db.Where("slack_id = ? AND name = ? AND component_chan = ?", r.SlackID, r.Name, r.ComponentChan).Delete(&Role{})
+	if res.Error != nil {
+		return s.fail("revoke role", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return ErrNoRole
+	}
+	log.WithFields(log.Fields{"user": r.SlackID, "role": r.Name, "component": r.ComponentChan}).Info("revoked role")
+	return nil
+}
+
+// HasRole returns true if the user has the role
+func (s *GormStore) HasRole(r Role) (bool, error) {
+	var role Role
+	err := s.db.Where("slack_id = ? AND name = ? AND component_chan = ?", r.SlackID, r.Name, r.ComponentChan).First(&role).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return false, nil
+		}
+		return false, s.fail("query role", err)
+	}
+	return true, nil
+}
+
+// ListRoles returns the roles on a component channel, or the global roles for ""
+func (s *GormStore) ListRoles(componentChan string) ([]Role, error) {
+	var roles []Role
+	if err := s.db.Where("component_chan = ?", componentChan).Order("name, slack_id").Find(&roles).Error; err != nil {
+		return nil, s.fail("query roles", err)
+	}
+	return roles, nil
+}
+
+// GrantRole adds a role, returning ErrRoleExists if the user already has it
+func (s *MemStore) GrantRole(r Role) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.hasRole(r) {
+		return ErrRoleExists
+	}
+	r.ID = s.nextID
+	s.nextID++
+	s.roles = append(s.roles, r)
+	return nil
+}
+
+// RevokeRole removes a role, returning ErrNoRole if the user does not have it
+func (s *MemStore) RevokeRole(r Role) error {
+	s.Lock()
+	defer s.Unlock()
+	for i, role := range s.roles {
+		if sameRole(role, r) {
+			s.roles = append(s.roles[:i:i], s.roles[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNoRole
+}
+
+// HasRole returns true if the user has the role
+func (s *MemStore) HasRole(r Role) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.hasRole(r), nil
+}
+
+func (s *MemStore) hasRole(r Role) bool {
+	for _, role := range s.roles {
+		if sameRole(role, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// ListRoles returns the roles on a component channel, or the global roles for ""
+func (s *MemStore) ListRoles(componentChan string) (roles []Role, err error) {
+	s.Lock()
+	defer s.Unlock()
+	for _, role := range s.roles {
+		if role.ComponentChan == componentChan {
+			roles = append(roles, role)
+		}
+	}
+	return roles, nil
+}
+
+func sameRole(a, b Role) bool {
+	return a.SlackID == b.SlackID && a.Name == b.Name && a.ComponentChan == b.ComponentChan
+}
+
+// ErrRoleExists is returned if a user already has the role being granted
+var ErrRoleExists = errors.New("User already has this role")
+
+// ErrNoRole is returned if a user does not have the role being revoked
+var ErrNoRole = errors.New("User does not have this role")
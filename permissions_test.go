@@ -0,0 +1,182 @@
+/*
+Tests for permissions, backed by a MemStore.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestIsAdmin(t *testing.T) {
+	newTestCache(t, map[string][]string{"kafka": {"C1"}})
+	oldAdmins := admins
+	admins = []string{"UCONF"}
+	defer func() { admins = oldAdmins }()
+	if err := store.GrantRole(Role{SlackID: "UGRANT", Name: roleAdmin}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.GrantRole(Role{SlackID: "UMAINT", Name: roleMaintainer, ComponentChan: "C1"}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		user string
+		want bool
+	}{
+		{"UCONF", true},
+		{"uconf", true},
+		{"UGRANT", true},
+		{"UMAINT", false},
+		{"UC1", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got, err := isAdmin(tt.user); got != tt.want || err != nil {
+			t.Errorf("isAdmin(%q) = %v, %v, want %v", tt.user, got, err, tt.want)
+		}
+	}
+}
+
+func TestIsOwner(t *testing.T) {
+	newTestCache(t, map[string][]string{"kafka": {"C1", "C2"}})
+	stub := newSlackStub(map[string][]string{"S1": {"U5", "U6"}})
+	defer stub.Close()
+	oldAdmins := admins
+	admins = []string{"UADMIN"}
+	defer func() { admins = oldAdmins }()
+
+	// C1 is anchored by a user group, C2 by UC2, a backup, a rotation, an override
+	// and a maintainer
+	if err := store.ChangeAnchor("C1", "S1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range []error{
+		store.AddComponentAnchor(ComponentAnchor{ComponentChan: "C2", SlackID: "U7", Role: anchorBackup}),
+		store.SetRotation(Rotation{ComponentChan: "C2", Members: "U8,U9", Period: time.Hour, Start: time.Now().Add(-time.Minute)}),
+		store.AddOverride(AnchorOverride{ComponentChan: "C2", SlackID: "U10", Until: time.Now().Add(time.Hour)}),
+		store.AddOverride(AnchorOverride{ComponentChan: "C2", SlackID: "U11", Until: time.Now().Add(-time.Hour)}),
+		store.GrantRole(Role{SlackID: "UM", Name: roleMaintainer, ComponentChan: "C2"}),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		user, componentChan string
+		want                bool
+	}{
+		{"UADMIN", "C1", true},
+		{"U5", "C1", true},
+		{"U6", "C1", true},
+		{"S1", "C1", false},
+		{"UC1", "C1", false},
+		{"U5", "C2", false},
+		{"UC2", "C2", true},
+		{"U7", "C2", true},
+		{"U8", "C2", true},
+		{"U9", "C2", true},
+		{"U10", "C2", true},
+		{"U11", "C2", false},
+		{"UM", "C2", true},
+		{"UM", "C1", false},
+	}
+	for _, tt := range tests {
+		if got, err := isOwner(tt.user, tt.componentChan); got != tt.want || err != nil {
+			t.Errorf("isOwner(%q, %q) = %v, %v, want %v", tt.user, tt.componentChan, got, err, tt.want)
+		}
+	}
+	if _, err := isOwner("U5", "C9"); err != ErrNoComponent {
+		t.Errorf("isOwner of an unknown component = %v, want ErrNoComponent", err)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	newTestCache(t, map[string][]string{"kafka": {"C1", "C2"}})
+	stub := newSlackStub(nil)
+	defer stub.Close()
+	oldAdmins := admins
+	admins = []string{"UADMIN"}
+	defer func() { admins = oldAdmins }()
+
+	tests := []struct {
+		name   string
+		user   string
+		chans  []string
+		want   bool
+		denied []string // components of the denied entries, "" for an admin command
+	}{
+		{"owner", "UC1", []string{"C1"}, true, nil},
+		{"admin", "UADMIN", []string{"C1", "C2"}, true, nil},
+		{"admin command", "UADMIN", nil, true, nil},
+		{"not an owner", "UC2", []string{"C1"}, false, []string{"C1"}},
+		{"owner of only one", "UC1", []string{"C1", "C2"}, false, []string{"C1", "C2"}},
+		{"not an admin", "UC1", nil, false, []string{""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, _ := store.History(AuditEntry{}, 1)
+			r := response{user: tt.user, channel: "D1", isEphemeral: true}
+			if got := authorize(r, "<@B> "+tt.name, tt.chans...); got != tt.want {
+				t.Fatalf("authorize = %v, want %v", got, tt.want)
+			}
+			after, _ := store.History(AuditEntry{}, 1)
+			messages := stub.messages()
+			if tt.want {
+				if len(messages) != 0 || len(after) != len(before) {
+					t.Errorf("an allowed command said %q and recorded %+v", messages, after)
+				}
+				return
+			}
+			want := notOwner
+			if len(tt.chans) == 0 {
+				want = notAdmin
+			}
+			if !reflect.DeepEqual(messages, []string{want}) {
+				t.Errorf("a denied command said %q, want %q", messages, want)
+			}
+			if len(after) != 1 || (len(before) == 1 && after[0].ID == before[0].ID) {
+				t.Fatalf("the denied command wasn't recorded: %+v", after)
+			}
+			c := after[0]
+			var chans []string
+			for _, e := range c.Entries {
+				chans = append(chans, e.ComponentChan)
+				if e.Action != actionDenied {
+					t.Errorf("entry %+v, want %s", e, actionDenied)
+				}
+			}
+			if !c.denied() || c.ActorSlackID != tt.user || c.Command != "<@B> "+tt.name || !reflect.DeepEqual(chans, tt.denied) {
+				t.Errorf("the denied command was recorded as %+v", c)
+			}
+		})
+	}
+}
+
+func TestMemStoreRoleIDs(t *testing.T) {
+	s := NewMemStore()
+	a, b := Role{SlackID: "U1", Name: roleAdmin}, Role{SlackID: "U2", Name: roleAdmin}
+	for _, r := range []Role{a, b} {
+		if err := s.GrantRole(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a revoked role's ID is not given out again
+	if err := s.RevokeRole(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.GrantRole(Role{SlackID: "U3", Name: roleAdmin}); err != nil {
+		t.Fatal(err)
+	}
+	roles, _ := s.ListRoles("")
+	ids := make(map[int]bool)
+	for _, r := range roles {
+		if ids[r.ID] {
+			t.Errorf("roles share ID %d: %+v", r.ID, roles)
+		}
+		ids[r.ID] = true
+	}
+}
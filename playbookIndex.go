@@ -0,0 +1,399 @@
+/*
+A search index of playbook contents.
+
+Tags only cover what someone thought to tag, so questions about a specific error
+message often match nothing. The bot fetches the page of every playbook, splits it
+into sections at its headings and keeps an inverted index of the words of each section,
+one index per component. When no tag matches a question, the sections sharing the most
+of its uncommon words are answered instead, with the component they belong to.
+
+The index is only kept in memory. It is built at startup, refreshed every
+indexInterval, and a component's playbooks are indexed again whenever they change.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"html"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Section is the part of a playbook under one heading
+type Section struct {
+	ComponentChan string
+	Playbook      string // title of the playbook
+	URL           string // the playbook URL, pointing at the heading if it has an id
+	Heading       string
+	Text          string
+}
+
+// SectionMatch is a section matching a search, with the words it matched
+type SectionMatch struct {
+	Section
+	Score float64
+	Words []string
+}
+
+// ContentIndex is an inverted index of the words of playbook sections, per component
+type ContentIndex struct {
+	sync.RWMutex
+	components map[string]*componentIndex // keyed by component channel
+}
+
+type componentIndex struct {
+	sections []Section
+	postings map[string][]posting // word to the sections it is in
+}
+
+type posting struct {
+	section int
+	count   int
+}
+
+const (
+	maxPlaybookSize   = 5 << 20 // bytes of a playbook page which are indexed
+	maxSectionMatches = 3
+	snippetLength     = 200
+)
+
+// indexInterval is how often every playbook is indexed again, see config.go. Playbooks
+// are not indexed at all if it is 0
+var indexInterval = 6 * time.Hour
+
+// contentIndex is the index used to answer questions no tag matches
+var contentIndex = NewContentIndex()
+
+var (
+	regHTMLSkip    = regexp.MustCompile(`(?is)<script.*?</script>|<style.*?</style>|<!--.*?-->`)
+	regHTMLHeading = regexp.MustCompile(`(?is)<h[1-6]([^>]*)>(.*?)</h[1-6]>`)
+	regHTMLTitle   = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	regHTMLTag     = regexp.MustCompile(`(?s)<[^>]*>`)
+	regHTMLID      = regexp.MustCompile(`(?i)\bid\s*=\s*["']([^"']+)["']`)
+)
+
+// NewContentIndex returns an empty ContentIndex
+func NewContentIndex() *ContentIndex {
+	return &ContentIndex{components: make(map[string]*componentIndex)}
+}
+
+// indexWords returns the stems of the words of text which are indexed, see normalize.go
+func indexWords(text string) []string {
+	var words []string
+	for _, w := range queryWords(text) {
+		if len(w) > 1 {
+			words = append(words, stem(w))
+		}
+	}
+	return words
+}
+
+// newComponentIndex indexes the sections of a component's playbooks
+func newComponentIndex(sections []Section) *componentIndex {
+	idx := &componentIndex{sections: sections, postings: make(map[string][]posting)}
+	for i, s := range sections {
+		counts := make(map[string]int)
+		for _, w := range indexWords(s.Heading + " " + s.Text) {
+			counts[w]++
+		}
+		for w, n := range counts {
+			idx.postings[w] = append(idx.postings[w], posting{section: i, count: n})
+		}
+	}
+	return idx
+}
+
+// set replaces the indexed sections of a component
+func (ci *ContentIndex) set(componentChan string, sections []Section) {
+	idx := newComponentIndex(sections)
+	ci.Lock()
+	defer ci.Unlock()
+	if len(sections) == 0 {
+		delete(ci.components, componentChan)
+		return
+	}
+	ci.components[componentChan] = idx
+}
+
+// sections returns the indexed sections of a component
+func (ci *ContentIndex) sections(componentChan string) []Section {
+	ci.RLock()
+	defer ci.RUnlock()
+	if idx, ok := ci.components[componentChan]; ok {
+		return idx.sections
+	}
+	return nil
+}
+
+// Search returns up to max sections sharing at least half of the words of the query,
+// best first. Words found in fewer sections count for more
+func (ci *ContentIndex) Search(query string, max int) []SectionMatch {
+	words := make(map[string]string) // stems of the query to the words they are from
+	for _, w := range queryWords(query) {
+		if len(w) > 1 {
+			words[stem(w)] = w
+		}
+	}
+	if len(words) == 0 {
+		return nil
+	}
+	ci.RLock()
+	defer ci.RUnlock()
+	total := 0
+	df := make(map[string]int)
+	for _, idx := range ci.components {
+		total += len(idx.sections)
+		for w := range words {
+			df[w] += len(idx.postings[w])
+		}
+	}
+	var matches []SectionMatch
+	for _, idx := range ci.components {
+		found := make(map[int]*SectionMatch)
+		for w := range words {
+			idf := math.Log(1 + float64(total)/float64(df[w]+1))
+			for _, p := range idx.postings[w] {
+				m, ok := found[p.section]
+				if !ok {
+					m = &SectionMatch{Section: idx.sections[p.section]}
+					found[p.section] = m
+				}
+				m.Score += (1 + math.Log(float64(p.count))) * idf
+				m.Words = append(m.Words, words[w])
+			}
+		}
+		for _, m := range found {
+			if 2*len(m.Words) >= len(words) {
+				sort.Strings(m.Words)
+				matches = append(matches, *m)
+			}
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].URL < matches[j].URL
+	})
+	if len(matches) > max {
+		matches = matches[:max]
+	}
+	return matches
+}
+
+// snippet returns the part of the section around the first of the words
+func (m SectionMatch) snippet() string {
+	text := m.Text
+	start := 0
+	lower := strings.ToLower(text)
+	for _, w := range m.Words {
+		if i := strings.Index(lower, w); i >= 0 && (start == 0 || i < start) {
+			start = i
+		}
+	}
+	if start -= snippetLength / 4; start < 0 {
+		start = 0
+	}
+	for start > 0 && start < len(text) && text[start-1] != ' ' {
+		start-- // don't cut a word
+	}
+	end := start + snippetLength
+	if end >= len(text) {
+		end = len(text)
+	} else if i := strings.LastIndex(text[start:end], " "); i > 0 {
+		end = start + i
+	}
+	s := text[start:end]
+	if start > 0 {
+		s = "…" + s
+	}
+	if end < len(text) {
+		s += "…"
+	}
+	return s
+}
+
+// contentMatch answers with the playbook sections best matching the words, and the
+// components they belong to
+func contentMatch(words []string) (responses []string, match bool) {
+	for _, m := range contentIndex.Search(strings.Join(words, " "), maxSectionMatches) {
+		component, err := store.GetAnchor(m.ComponentChan)
+		if err != nil {
+			log.WithFields(log.Fields{"component": m.ComponentChan, "ERROR": err}).Warn("Indexed playbook of an unknown component")
+			continue
+		}
+		responses = append(responses, sectionFmt(m, component))
+	}
+	return responses, len(responses) != 0
+}
+
+// sweepIndex indexes every playbook now and every indexInterval
+func sweepIndex() {
+	if indexInterval == 0 {
+		return
+	}
+	contentIndex.refreshAll(linkClient)
+	for range time.Tick(indexInterval) {
+		contentIndex.refreshAll(linkClient)
+	}
+}
+
+// reindexPlaybooks indexes the playbooks of a component again in the background,
+// after they changed
+func reindexPlaybooks(componentChan string) {
+	if indexInterval == 0 {
+		return
+	}
+	go func() {
+		component, err := store.GetAnchor(componentChan)
+		if err != nil {
+			log.WithFields(log.Fields{"component": componentChan, "ERROR": err}).Error("Could not index playbooks")
+			return
+		}
+		contentIndex.refresh(linkClient, componentChan, component.Playbooks)
+	}()
+}
+
+// refreshAll indexes the playbooks of every component
+func (ci *ContentIndex) refreshAll(client *http.Client) {
+	playbooks, err := store.GetPlaybooks()
+	if err != nil {
+		log.WithField("ERROR", err).Error("Could not index playbooks")
+		return
+	}
+	byComponent := make(map[string][]Playbook)
+	for _, p := range playbooks {
+		byComponent[p.ComponentChan] = append(byComponent[p.ComponentChan], p)
+	}
+	ci.RLock()
+	for componentChan := range ci.components {
+		if _, ok := byComponent[componentChan]; !ok {
+			byComponent[componentChan] = nil
+		}
+	}
+	ci.RUnlock()
+	for componentChan, playbooks := range byComponent {
+		ci.refresh(client, componentChan, playbooks)
+	}
+}
+
+// refresh indexes the playbooks of a component. The sections of a playbook which
+// can't be fetched right now are kept from the last time
+func (ci *ContentIndex) refresh(client *http.Client, componentChan string, playbooks []Playbook) {
+	old := ci.sections(componentChan)
+	var sections []Section
+	for _, p := range playbooks {
+		s, err := fetchSections(client, p)
+		if err != nil {
+			log.WithFields(log.Fields{"component": componentChan, "url": p.URL, "ERROR": err}).Warn("Could not index playbook")
+			for _, o := range old {
+				if o.Playbook == p.Title {
+					sections = append(sections, o)
+				}
+			}
+			continue
+		}
+		sections = append(sections, s...)
+	}
+	ci.set(componentChan, sections)
+	log.WithFields(log.Fields{"component": componentChan, "sections": len(sections)}).Debug("Indexed playbooks")
+}
+
+// fetchSections fetches a playbook and splits it into sections
+func fetchSections(client *http.Client, p Playbook) ([]Section, error) {
+	res, err := client.Get(p.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= http.StatusBadRequest {
+		return nil, &LinkError{URL: p.URL, Status: res.StatusCode}
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, maxPlaybookSize))
+	if err != nil {
+		return nil, err
+	}
+	page := string(body)
+	if strings.Contains(res.Header.Get("Content-Type"), "html") || strings.HasPrefix(strings.TrimSpace(page), "<") {
+		return htmlSections(p, page), nil
+	}
+	return textSections(p, page), nil
+}
+
+// htmlSections splits an HTML page into sections at its headings
+func htmlSections(p Playbook, page string) []Section {
+	page = regHTMLSkip.ReplaceAllString(page, " ")
+	first := Section{ComponentChan: p.ComponentChan, Playbook: p.Title, URL: p.URL, Heading: p.Title}
+	if m := regHTMLTitle.FindStringSubmatch(page); m != nil {
+		first.Heading = htmlText(m[1])
+		page = strings.Replace(page, m[0], " ", 1)
+	}
+	sections := []Section{first}
+	last := 0
+	for _, m := range regHTMLHeading.FindAllStringSubmatchIndex(page, -1) {
+		sections[len(sections)-1].Text = htmlText(page[last:m[0]])
+		s := Section{ComponentChan: p.ComponentChan, Playbook: p.Title, URL: p.URL, Heading: htmlText(page[m[4]:m[5]])}
+		if id := regHTMLID.FindStringSubmatch(page[m[2]:m[3]]); id != nil {
+			s.URL = strings.Split(p.URL, "#")[0] + "#" + id[1]
+		}
+		sections = append(sections, s)
+		last = m[1]
+	}
+	sections[len(sections)-1].Text = htmlText(page[last:])
+	return nonEmpty(sections)
+}
+
+// htmlText returns the text of an HTML fragment on a single line
+func htmlText(fragment string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(regHTMLTag.ReplaceAllString(fragment, " "))), " ")
+}
+
+// textSections splits a plain text or markdown page into sections at lines starting
+// with #
+func textSections(p Playbook, page string) []Section {
+	sections := []Section{{ComponentChan: p.ComponentChan, Playbook: p.Title, URL: p.URL, Heading: p.Title}}
+	var lines []string
+	for _, line := range strings.Split(page, "\n") {
+		if strings.HasPrefix(line, "#") {
+			sections[len(sections)-1].Text = strings.Join(strings.Fields(strings.Join(lines, " ")), " ")
+			sections = append(sections, Section{ComponentChan: p.ComponentChan, Playbook: p.Title, URL: p.URL, Heading: strings.TrimSpace(strings.TrimLeft(line, "#"))})
+			lines = nil
+			continue
+		}
+		lines = append(lines, line)
+	}
+	sections[len(sections)-1].Text = strings.Join(strings.Fields(strings.Join(lines, " ")), " ")
+	return nonEmpty(sections)
+}
+
+// nonEmpty drops sections without text
+func nonEmpty(sections []Section) []Section {
+	var kept []Section
+	for _, s := range sections {
+		if s.Text != "" {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+// LinkError is returned if a link answers with an HTTP error
+type LinkError struct {
+	URL    string
+	Status int
+}
+
+func (e *LinkError) Error() string {
+	return e.URL + ": " + http.StatusText(e.Status)
+}
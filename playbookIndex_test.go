@@ -0,0 +1,121 @@
+/*
+Tests for the search index of playbook contents, against a local HTTP server.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testKafkaPlaybook = `<html><head><title>Kafka runbook</title><style>h2 { color: red }</style></head>
+<body>
+<p>Brokers of the event bus.</p>
+<h2 id="not-leader">NotLeaderForPartition errors</h2>
+<p>A producer logs NotLeaderForPartitionException while the leader of a partition
+moves. Wait for the preferred replica election to finish.</p>
+<h2>Disk full</h2>
+<p>Delete old log segments &amp; lower the retention.</p>
+</body></html>`
+
+const testZookeeperPlaybook = `Ensemble of five nodes.
+# Session expired
+Clients see SessionExpiredException after a long garbage collection pause.
+`
+
+// playbookServer serves the playbook pages the tests index
+func playbookServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/kafka", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, testKafkaPlaybook)
+	})
+	mux.HandleFunc("/zookeeper.md", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, testZookeeperPlaybook)
+	})
+	mux.HandleFunc("/missing", http.NotFound)
+	return httptest.NewServer(mux)
+}
+
+// sectionHeadings returns the headings and URLs of the indexed sections of a component
+func sectionHeadings(componentChan string) (headings []string) {
+	for _, s := range contentIndex.sections(componentChan) {
+		headings = append(headings, s.Heading+" "+s.URL)
+	}
+	return headings
+}
+
+func TestContentIndexFallback(t *testing.T) {
+	srv := playbookServer()
+	defer srv.Close()
+	newTestCache(t, map[string][]string{"kafka": {"C1"}, "zookeeper": {"C2"}})
+	oldIndex := contentIndex
+	contentIndex = NewContentIndex()
+	defer func() { contentIndex = oldIndex }()
+	for _, p := range []Playbook{
+		{ComponentChan: "C1", Title: "Kafka", URL: srv.URL + "/kafka"},
+		{ComponentChan: "C2", Title: "Zookeeper", URL: srv.URL + "/zookeeper.md"},
+	} {
+		if err := store.AddPlaybook(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	contentIndex.refreshAll(srv.Client())
+	kafka := []string{
+		"Kafka runbook " + srv.URL + "/kafka",
+		"NotLeaderForPartition errors " + srv.URL + "/kafka#not-leader",
+		"Disk full " + srv.URL + "/kafka",
+	}
+	if got := sectionHeadings("C1"); !reflect.DeepEqual(got, kafka) {
+		t.Errorf("C1 has the sections %q, want %q", got, kafka)
+	}
+	zookeeper := []string{"Zookeeper " + srv.URL + "/zookeeper.md", "Session expired " + srv.URL + "/zookeeper.md"}
+	if got := sectionHeadings("C2"); !reflect.DeepEqual(got, zookeeper) {
+		t.Errorf("C2 has the sections %q, want %q", got, zookeeper)
+	}
+
+	// no tag matches the question, so it is answered from the playbooks
+	words := strings.Fields("tag: NotLeaderForPartitionException from the producer")
+	if matches := tagMatch(words); len(matches) != 0 {
+		t.Fatalf("the question matched tags: %+v", matches)
+	}
+	responses, found := contentMatch(words[1:])
+	if !found || len(responses) != 1 {
+		t.Fatalf("contentMatch = %q, %v", responses, found)
+	}
+	c, _ := store.GetAnchor("C1")
+	want := fmt.Sprintf("*found in:* <%s/kafka#not-leader|Kafka › NotLeaderForPartition errors> _(matching notleaderforpartitionexception, producer)_\n", srv.URL)
+	if !strings.HasPrefix(responses[0], want) || !strings.HasSuffix(responses[0], componentFmt(c)) {
+		t.Errorf("contentMatch answered %q, want %q first and the component last", responses[0], want)
+	}
+	if responses, found := contentMatch(strings.Fields("certificate rotation")); found {
+		t.Errorf("an unrelated question was answered with %q", responses)
+	}
+
+	// a playbook which can't be fetched keeps its sections from the last time
+	contentIndex.refresh(srv.Client(), "C1", []Playbook{{ComponentChan: "C1", Title: "Kafka", URL: srv.URL + "/missing"}})
+	if got := sectionHeadings("C1"); !reflect.DeepEqual(got, kafka) {
+		t.Errorf("after the playbook broke, C1 has the sections %q, want %q", got, kafka)
+	}
+
+	// a removed playbook is no longer searched
+	if err := store.RemovePlaybook("C2", "Zookeeper"); err != nil {
+		t.Fatal(err)
+	}
+	contentIndex.refreshAll(srv.Client())
+	if got := sectionHeadings("C2"); len(got) != 0 {
+		t.Errorf("after its playbook was removed, C2 has the sections %q", got)
+	}
+	if responses, found := contentMatch(strings.Fields("SessionExpiredException")); found {
+		t.Errorf("a removed playbook answered %q", responses)
+	}
+}
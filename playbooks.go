@@ -0,0 +1,295 @@
+/*
+Playbooks of a component.
+
+A component can have any number of playbooks, like its install, upgrade and
+troubleshooting guides, each with a title. A playbook can be scoped to some of the
+component's tags, so a question about kafka-upgrade shows the upgrade guide first:
+
+	@bot playbook add #kafka "Upgrade guide" https://wiki.example.com/kafka/upgrade for kafka-upgrade, rolling restart
+	@bot playbook remove #kafka "Upgrade guide"
+	@bot playbook #kafka
+
+Playbooks without tags apply to the whole component. The playbook given when a
+component is added, and changed with set #kafka playbook url, is the one titled
+defaultPlaybookTitle.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+)
+
+// Playbook is a titled link to a component's documentation, optionally scoped to
+// some of its tags
+type Playbook struct {
+	ID            int
+	ComponentChan string `gorm:"type:varchar(20)"`
+	Title         string `gorm:"type:varchar(100)"`
+	URL           string `gorm:"type:text"`
+	Tags          string `gorm:"type:text"` // comma separated tag names, empty for the whole component
+
+	LinkStatus // the last check of the URL, see linkHealth.go
+}
+
+// defaultPlaybookTitle is the title of the playbook set with the component
+const defaultPlaybookTitle = "Playbook"
+
+// maxPlaybooks is how many playbooks are shown with a tag
+const maxPlaybooks = 3
+
+// MAX_TITLE_LENGTH should be length of the playbook title varchar in db
+const MAX_TITLE_LENGTH = 100
+
+// tagList returns the tags the playbook is scoped to
+func (p Playbook) tagList() []string {
+	if p.Tags == "" {
+		return nil
+	}
+	return strings.Split(p.Tags, ",")
+}
+
+// scoped returns true if the playbook is scoped to the tag
+func (p Playbook) scoped(tag string) bool {
+	for _, t := range p.tagList() {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// sameTitle returns true if two playbook titles are the same, ignoring case
+func sameTitle(a, b string) bool {
+	return strings.EqualFold(a, b)
+}
+
+// playbook returns the component's playbook with the title
+func (c Component) playbook(title string) (Playbook, bool) {
+	for _, p := range c.Playbooks {
+		if sameTitle(p.Title, title) {
+			return p, true
+		}
+	}
+	return Playbook{}, false
+}
+
+// playbookURL returns the URL of the component's default playbook, "" if it has none
+func (c Component) playbookURL() string {
+	p, _ := c.playbook(defaultPlaybookTitle)
+	return p.URL
+}
+
+// relevantPlaybooks returns the playbooks to show with a tag of the component: the
+// ones scoped to the tag, then the ones for the whole component. Playbooks scoped to
+// other tags are left out. For an empty tag, every playbook is returned
+func (c Component) relevantPlaybooks(tag string) []Playbook {
+	var scoped, general, other []Playbook
+	for _, p := range c.Playbooks {
+		switch {
+		case tag != "" && p.scoped(tag):
+			scoped = append(scoped, p)
+		case p.Tags == "":
+			general = append(general, p)
+		case tag == "":
+			other = append(other, p)
+		}
+	}
+	return append(append(scoped, general...), other...)
+}
+
+// playbookEntry returns the audit entry value for a playbook
+func playbookEntry(p Playbook) string {
+	return strings.Join([]string{p.Title, p.URL, p.Tags}, "\n")
+}
+
+// entryPlaybook returns the playbook described by an audit entry for adding or
+// removing a playbook
+func entryPlaybook(e AuditEntry) Playbook {
+	if e.Action == actionPlaybookRemove {
+		return valuePlaybook(e.ComponentChan, e.Before)
+	}
+	return valuePlaybook(e.ComponentChan, e.After)
+}
+
+// valuePlaybook returns the playbook of a component described by an audit entry value
+func valuePlaybook(componentChan, v string) Playbook {
+	p := Playbook{ComponentChan: componentChan}
+	if f := strings.SplitN(v, "\n", 3); len(f) == 3 {
+		p.Title, p.URL, p.Tags = f[0], f[1], f[2]
+	}
+	return p
+}
+
+// checkPlaybook returns an error if the playbook can't be stored
+func checkPlaybook(p Playbook) error {
+	if len(p.URL) > MAX_URL_LENGTH || len(p.Title) > MAX_TITLE_LENGTH {
+		return ErrURLTooLong
+	}
+	return nil
+}
+
+// AddPlaybook adds a playbook to a component, returning ErrPlaybookExists if the
+// component has a playbook with the same title
+func (s *GormStore) AddPlaybook(p Playbook) error {
+	if err := checkPlaybook(p); err != nil {
+		return err
+	}
+	component, err := s.findComponent(p.ComponentChan)
+	if err != nil {
+		return err
+	}
+	if _, ok := component.playbook(p.Title); ok {
+		return ErrPlaybookExists
+	}
+	p.ID = 0
+	if err := s.db.Create(&p).Error; err != nil {
+		return s.fail("add playbook", err)
+	}
+	log.WithFields(log.Fields{"component": p.ComponentChan, "title": p.Title, "url": p.URL}).Info("added playbook to component")
+	return nil
+}
+
+// RemovePlaybook removes the playbook with the title from a component, returning
+// ErrNoPlaybook if there is none
+func (s *GormStore) RemovePlaybook(componentChan, title string) error {
+	res := s.db.Where("component_chan = ? AND lower(title) = lower(?)", componentChan, title).Delete(&Playbook{})
+	if res.Error != nil {
+		return s.fail("remove playbook", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return ErrNoPlaybook
+	}
+	log.WithFields(log.Fields{"component": componentChan, "title": title}).Info("removed playbook from component")
+	return nil
+}
+
+// ChangePlaybook sets the URL of a component's default playbook, adding it if needed.
+// An empty URL removes it
+func (s *GormStore) ChangePlaybook(componentChan, newURL string) error {
+	if err := checkPlaybook(Playbook{URL: newURL}); err != nil {
+		return err
+	}
+	component, err := s.findComponent(componentChan)
+	if err != nil {
+		return err
+	}
+	p, ok := component.playbook(defaultPlaybookTitle)
+	switch {
+	case !ok && newURL == "":
+		return nil
+	case !ok:
+		p = Playbook{ComponentChan: componentChan, Title: defaultPlaybookTitle, URL: newURL}
+		err = s.db.Create(&p).Error
+	case newURL == "":
+		err = s.db.Delete(&p).Error
+	default:
+		err = s.db.Model(&p).Updates(map[string]interface{}{"url": newURL, "checked_at": nil, "status": 0, "redirect": ""}).Error
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"url": newURL, "component": componentChan}).Error("Failed to change playbookURL")
+		return s.fail("change playbook", err)
+	}
+	log.WithFields(log.Fields{"URL": newURL, "component": componentChan}).Info("Changed playbook URL in DB")
+	return nil
+}
+
+// loadPlaybooks loads the playbooks of the components selected by db into d
+func (s *GormStore) loadPlaybooks(db *gorm.DB, d componentDetails) error {
+	var playbooks []Playbook
+	if err := db.Order("id").Find(&playbooks).Error; err != nil {
+		return s.fail("query playbooks", err)
+	}
+	for _, p := range playbooks {
+		d.playbooks[p.ComponentChan] = append(d.playbooks[p.ComponentChan], p)
+	}
+	return nil
+}
+
+// AddPlaybook adds a playbook to a component, returning ErrPlaybookExists if the
+// component has a playbook with the same title
+func (s *MemStore) AddPlaybook(p Playbook) error {
+	if err := checkPlaybook(p); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.components[p.ComponentChan]; !ok {
+		return ErrNoComponent
+	}
+	for _, existing := range s.playbooks {
+		if existing.ComponentChan == p.ComponentChan && sameTitle(existing.Title, p.Title) {
+			return ErrPlaybookExists
+		}
+	}
+	p.ID = s.nextID
+	s.nextID++
+	s.playbooks = append(s.playbooks, p)
+	return nil
+}
+
+// RemovePlaybook removes the playbook with the title from a component, returning
+// ErrNoPlaybook if there is none
+func (s *MemStore) RemovePlaybook(componentChan, title string) error {
+	s.Lock()
+	defer s.Unlock()
+	for i, p := range s.playbooks {
+		if p.ComponentChan == componentChan && sameTitle(p.Title, title) {
+			s.playbooks = append(s.playbooks[:i:i], s.playbooks[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNoPlaybook
+}
+
+// ChangePlaybook sets the URL of a component's default playbook, adding it if needed.
+// An empty URL removes it
+func (s *MemStore) ChangePlaybook(componentChan, newURL string) error {
+	if err := checkPlaybook(Playbook{URL: newURL}); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.components[componentChan]; !ok {
+		return ErrNoComponent
+	}
+	for i, p := range s.playbooks {
+		if p.ComponentChan != componentChan || !sameTitle(p.Title, defaultPlaybookTitle) {
+			continue
+		}
+		if newURL == "" {
+			s.playbooks = append(s.playbooks[:i:i], s.playbooks[i+1:]...)
+		} else {
+			s.playbooks[i].URL = newURL
+			s.playbooks[i].LinkStatus = LinkStatus{}
+		}
+		return nil
+	}
+	if newURL != "" {
+		s.playbooks = append(s.playbooks, Playbook{ID: s.nextID, ComponentChan: componentChan, Title: defaultPlaybookTitle, URL: newURL})
+		s.nextID++
+	}
+	return nil
+}
+
+// componentPlaybooks returns the playbooks of a component
+func (s *MemStore) componentPlaybooks(componentChan string) (playbooks []Playbook) {
+	for _, p := range s.playbooks {
+		if p.ComponentChan == componentChan {
+			playbooks = append(playbooks, p)
+		}
+	}
+	return playbooks
+}
+
+// ErrPlaybookExists is returned if a component already has a playbook with the title
+var ErrPlaybookExists = errors.New("Component already has a playbook with this title")
+
+// ErrNoPlaybook is returned if a component has no playbook with the title
+var ErrNoPlaybook = errors.New("Component has no playbook with this title")
@@ -0,0 +1,132 @@
+/*
+Tests for the playbooks of a component, backed by a MemStore.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nlopes/slack"
+)
+
+func TestPlaybookCommands(t *testing.T) {
+	c := newTestCache(t, map[string][]string{"kafka": {"C1"}, "zookeeper": {"C1"}, "redis": {"C2"}})
+	stub := newSlackStub(nil)
+	defer stub.Close()
+	oldInterval := indexInterval
+	indexInterval = 0
+	defer func() { indexInterval = oldInterval }()
+	if _, err := c.AddAlias("zk", "zookeeper"); err != nil {
+		t.Fatal(err)
+	}
+
+	// each command runs after the ones before it, by the anchor UC1
+	tests := []struct {
+		text      string
+		message   string
+		playbooks []string // the playbooks of C1 afterwards, as "title|url|tags"
+	}{
+		{
+			`<@B> playbook add <#C1|c1> "Upgrade guide" <https://wiki/upgrade>`,
+			"Added the playbook _Upgrade guide_ to <#C1|c1>",
+			[]string{"Upgrade guide|https://wiki/upgrade|"},
+		},
+		{
+			`<@B> playbook add <#C1|c1> “Disk full” <https://wiki/disk|Disk> for kafka, ZK`,
+			"Added the playbook _Disk full_ to <#C1|c1>",
+			[]string{"Upgrade guide|https://wiki/upgrade|", "Disk full|https://wiki/disk|kafka,zookeeper"},
+		},
+		{
+			"<@B> playbook add <#C1|c1> Restart <https://wiki/restart> for zookeeper",
+			"Added the playbook _Restart_ to <#C1|c1>",
+			[]string{"Upgrade guide|https://wiki/upgrade|", "Disk full|https://wiki/disk|kafka,zookeeper", "Restart|https://wiki/restart|zookeeper"},
+		},
+		{
+			`<@B> playbook add <#C1|c1> "Failover" <https://wiki/failover> for redis`,
+			fmt.Sprintf(tagNotOnComponent, "redis", "<#C1|c1>"),
+			[]string{"Upgrade guide|https://wiki/upgrade|", "Disk full|https://wiki/disk|kafka,zookeeper", "Restart|https://wiki/restart|zookeeper"},
+		},
+		{
+			`<@B> playbook add <#C1|c1> "upgrade GUIDE" <https://wiki/upgrade2>`,
+			fmt.Sprintf(playbookExists, "<#C1|c1>", "upgrade GUIDE"),
+			[]string{"Upgrade guide|https://wiki/upgrade|", "Disk full|https://wiki/disk|kafka,zookeeper", "Restart|https://wiki/restart|zookeeper"},
+		},
+		{
+			`<@B> playbook add <#C1|c1> "Failover" wiki/failover`,
+			badPlaybook,
+			[]string{"Upgrade guide|https://wiki/upgrade|", "Disk full|https://wiki/disk|kafka,zookeeper", "Restart|https://wiki/restart|zookeeper"},
+		},
+		{
+			`<@B> playbook add <#C1|c1> "Failover" <https://wiki/failover> to kafka`,
+			badPlaybook,
+			[]string{"Upgrade guide|https://wiki/upgrade|", "Disk full|https://wiki/disk|kafka,zookeeper", "Restart|https://wiki/restart|zookeeper"},
+		},
+		{
+			`<@B> playbook remove <#C1|c1> "upgrade guide"`,
+			"Removed the playbook _Upgrade guide_ from <#C1|c1>",
+			[]string{"Disk full|https://wiki/disk|kafka,zookeeper", "Restart|https://wiki/restart|zookeeper"},
+		},
+		{
+			`<@B> playbook remove <#C1|c1> "Upgrade guide"`,
+			fmt.Sprintf(noPlaybook, "<#C1|c1>", "Upgrade guide"),
+			[]string{"Disk full|https://wiki/disk|kafka,zookeeper", "Restart|https://wiki/restart|zookeeper"},
+		},
+	}
+	for _, tt := range tests {
+		ev := &slack.MessageEvent{Msg: slack.Msg{User: "UC1", Channel: "D1", Text: tt.text}}
+		handleCommand(ev, strings.Fields(tt.text))
+		if got := stub.messages(); !reflect.DeepEqual(got, []string{tt.message}) {
+			t.Errorf("%q said %q, want %q", tt.text, got, tt.message)
+		}
+		component, err := store.GetAnchor("C1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var playbooks []string
+		for _, p := range component.Playbooks {
+			playbooks = append(playbooks, strings.Join([]string{p.Title, p.URL, p.Tags}, "|"))
+		}
+		if !reflect.DeepEqual(playbooks, tt.playbooks) {
+			t.Errorf("after %q, C1 has the playbooks %q, want %q", tt.text, playbooks, tt.playbooks)
+		}
+	}
+
+	// the cache is reloaded, so the playbooks are shown with their tags
+	if tags := cache.Find("zk"); len(tags) != 1 || len(tags[0].Playbooks) != 2 {
+		t.Errorf("the cache has %+v", tags)
+	}
+}
+
+func TestRelevantPlaybooks(t *testing.T) {
+	c := Component{Playbooks: []Playbook{
+		{Title: "Upgrade guide"},
+		{Title: "Disk full", Tags: "kafka"},
+		{Title: "Restart", Tags: "zookeeper"},
+		{Title: "Failover", Tags: "kafka,zookeeper"},
+		{Title: "Install"},
+	}}
+	tests := []struct {
+		tag    string
+		titles []string
+	}{
+		{"kafka", []string{"Disk full", "Failover", "Upgrade guide", "Install"}},
+		{"zookeeper", []string{"Restart", "Failover", "Upgrade guide", "Install"}},
+		{"redis", []string{"Upgrade guide", "Install"}},
+		{"", []string{"Upgrade guide", "Install", "Disk full", "Restart", "Failover"}},
+	}
+	for _, tt := range tests {
+		var titles []string
+		for _, p := range c.relevantPlaybooks(tt.tag) {
+			titles = append(titles, p.Title)
+		}
+		if !reflect.DeepEqual(titles, tt.titles) {
+			t.Errorf("relevantPlaybooks(%q) = %q, want %q", tt.tag, titles, tt.titles)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+/*
+The Porter stemmer, which reduces English words to their stem so "upgrades",
+"upgraded" and "upgrading" are all "upgrad". This is the algorithm as published in
+M.F. Porter, An algorithm for suffix stripping, Program 14(3), 1980, see
+https://tartarus.org/martin/PorterStemmer/
+
+Words are expected in lower case. Words which aren't plain ASCII letters are left alone.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import "strings"
+
+// suffix replacements of steps 2 and 3
+var (
+	porterStep2 = map[string]string{
+		"ational": "ate", "tional": "tion", "enci": "ence", "anci": "ance", "izer": "ize",
+		"abli": "able", "alli": "al", "entli": "ent", "eli": "e", "ousli": "ous",
+		"ization": "ize", "ation": "ate", "ator": "ate", "alism": "al", "iveness": "ive",
+		"fulness": "ful", "ousness": "ous", "aliti": "al", "iviti": "ive", "biliti": "ble",
+	}
+	porterStep3 = map[string]string{
+		"icate": "ic", "ative": "", "alize": "al", "iciti": "ic", "ical": "ic", "ful": "",
+		"ness": "",
+	}
+	porterStep4 = []string{
+		"al", "ance", "ence", "er", "ic", "able", "ible", "ant", "ement", "ment", "ent",
+		"ion", "ou", "ism", "ate", "iti", "ous", "ive", "ize",
+	}
+)
+
+// stem returns the stem of a lower case word
+func stem(w string) string {
+	if len(w) <= 2 {
+		return w
+	}
+	for i := 0; i < len(w); i++ {
+		if w[i] < 'a' || w[i] > 'z' {
+			return w
+		}
+	}
+	w = porterStep1a(w)
+	w = porterStep1b(w)
+	w = porterStep1c(w)
+	w = porterReplace(w, porterStep2)
+	w = porterReplace(w, porterStep3)
+	w = porterStep4Strip(w)
+	w = porterStep5(w)
+	return w
+}
+
+// consonant returns true if w[i] is a consonant: not a vowel, and not a y after a
+// consonant
+func consonant(w string, i int) bool {
+	switch w[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		return i == 0 || !consonant(w, i-1)
+	}
+	return true
+}
+
+// measure returns the number of vowel-consonant sequences in w
+func measure(w string) int {
+	n, i := 0, 0
+	for i < len(w) && consonant(w, i) {
+		i++
+	}
+	for i < len(w) {
+		for i < len(w) && !consonant(w, i) {
+			i++
+		}
+		if i == len(w) {
+			break
+		}
+		for i < len(w) && consonant(w, i) {
+			i++
+		}
+		n++
+	}
+	return n
+}
+
+// hasVowel returns true if w contains a vowel
+func hasVowel(w string) bool {
+	for i := range w {
+		if !consonant(w, i) {
+			return true
+		}
+	}
+	return false
+}
+
+// doubleConsonant returns true if w ends with two of the same consonant
+func doubleConsonant(w string) bool {
+	l := len(w)
+	return l >= 2 && w[l-1] == w[l-2] && consonant(w, l-1)
+}
+
+// cvc returns true if w ends consonant-vowel-consonant, where the last consonant
+// isn't w, x or y
+func cvc(w string) bool {
+	l := len(w)
+	if l < 3 || !consonant(w, l-3) || consonant(w, l-2) || !consonant(w, l-1) {
+		return false
+	}
+	return !strings.ContainsRune("wxy", rune(w[l-1]))
+}
+
+func porterStep1a(w string) string {
+	switch {
+	case strings.HasSuffix(w, "sses"), strings.HasSuffix(w, "ies"):
+		return w[:len(w)-2]
+	case strings.HasSuffix(w, "ss"):
+		return w
+	case strings.HasSuffix(w, "s"):
+		return w[:len(w)-1]
+	}
+	return w
+}
+
+func porterStep1b(w string) string {
+	if strings.HasSuffix(w, "eed") {
+		if measure(w[:len(w)-3]) > 0 {
+			return w[:len(w)-1]
+		}
+		return w
+	}
+	var s string
+	switch {
+	case strings.HasSuffix(w, "ed") && hasVowel(w[:len(w)-2]):
+		s = w[:len(w)-2]
+	case strings.HasSuffix(w, "ing") && hasVowel(w[:len(w)-3]):
+		s = w[:len(w)-3]
+	default:
+		return w
+	}
+	switch {
+	case strings.HasSuffix(s, "at"), strings.HasSuffix(s, "bl"), strings.HasSuffix(s, "iz"):
+		return s + "e"
+	case doubleConsonant(s) && !strings.ContainsRune("lsz", rune(s[len(s)-1])):
+		return s[:len(s)-1]
+	case measure(s) == 1 && cvc(s):
+		return s + "e"
+	}
+	return s
+}
+
+func porterStep1c(w string) string {
+	if strings.HasSuffix(w, "y") && hasVowel(w[:len(w)-1]) {
+		return w[:len(w)-1] + "i"
+	}
+	return w
+}
+
+// porterReplace replaces the longest of the suffixes w ends with, if the rest has a
+// vowel-consonant sequence
+func porterReplace(w string, suffixes map[string]string) string {
+	longest := ""
+	for suffix := range suffixes {
+		if len(suffix) > len(longest) && strings.HasSuffix(w, suffix) {
+			longest = suffix
+		}
+	}
+	if longest == "" {
+		return w
+	}
+	if s := w[:len(w)-len(longest)]; measure(s) > 0 {
+		return s + suffixes[longest]
+	}
+	return w
+}
+
+func porterStep4Strip(w string) string {
+	longest := ""
+	for _, suffix := range porterStep4 {
+		if len(suffix) > len(longest) && strings.HasSuffix(w, suffix) {
+			longest = suffix
+		}
+	}
+	if longest == "" {
+		return w
+	}
+	s := w[:len(w)-len(longest)]
+	if longest == "ion" && (s == "" || !strings.ContainsRune("st", rune(s[len(s)-1]))) {
+		return w
+	}
+	if measure(s) > 1 {
+		return s
+	}
+	return w
+}
+
+func porterStep5(w string) string {
+	if strings.HasSuffix(w, "e") {
+		s := w[:len(w)-1]
+		if m := measure(s); m > 1 || m == 1 && !cvc(s) {
+			w = s
+		}
+	}
+	if measure(w) > 1 && doubleConsonant(w) && strings.HasSuffix(w, "l") {
+		w = w[:len(w)-1]
+	}
+	return w
+}
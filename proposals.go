@@ -0,0 +1,311 @@
+/*
+Tag proposals from users who do not own a component.
+
+When someone who is not an owner of a component tags it, the tags are stored as a
+proposal and sent to the component's anchor as a direct message with Approve and
+Reject buttons. If the anchor is a user group, every member gets the message and the
+first to answer decides. Only approved proposals are added to the store and the
+cache. A proposal nobody answers expires after the configured proposal TTL; a
+background sweeper marks it expired and lets the proposer know.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/nlopes/slack"
+	log "github.com/sirupsen/logrus"
+)
+
+// Proposal is a set of tags proposed for a component by someone who does not own it
+type Proposal struct {
+	ID              int
+	CreatedAt       time.Time
+	ExpiresAt       time.Time
+	ProposerSlackID string `gorm:"type:varchar(20)"`
+	Channel         string `gorm:"type:varchar(20)"` // where the tags were proposed
+	ComponentChan   string `gorm:"type:varchar(20)"`
+	Tags            string `gorm:"type:text"` // comma separated
+	Status          string `gorm:"type:varchar(20)"`
+	DeciderSlackID  string `gorm:"type:varchar(20)"`
+}
+
+// proposal statuses
+const (
+	proposalPending  = "pending"
+	proposalApproved = "approved"
+	proposalRejected = "rejected"
+	proposalExpired  = "expired"
+)
+
+// callback ID prefix and button values of a proposal
+const (
+	callbackProposal = "proposal"
+	valueApprove     = "approve"
+	valueReject      = "reject"
+)
+
+// how often expired proposals are swept
+const proposalSweepInterval = 10 * time.Minute
+
+// ProposalStore stores tag proposals
+type ProposalStore interface {
+	// AddProposal stores a new proposal and sets its ID
+	AddProposal(p *Proposal) error
+	// GetProposal returns a proposal by ID
+	GetProposal(id int) (Proposal, error)
+	// DecideProposal sets the status of a pending proposal which has not expired,
+	// returning ErrProposalClosed otherwise
+	DecideProposal(id int, status, deciderSlackID string) error
+	// ExpireProposals marks pending proposals which expired before now and returns them
+	ExpireProposals(now time.Time) ([]Proposal, error)
+}
+
+// tagList returns the proposed tag names
+func (p Proposal) tagList() []string {
+	return strings.Split(p.Tags, ",")
+}
+
+// sendProposal DMs the proposal to the anchor of the component, or every member of
+// the anchor user group
+func sendProposal(p Proposal, anchor string) error {
+	members, err := anchorMembers(anchor)
+	if err != nil {
+		return err
+	}
+	attachment := slack.Attachment{
+		Text:       proposalQuestion,
+		CallbackID: fmt.Sprintf("%s:%d", callbackProposal, p.ID),
+		Color:      "good",
+		Actions: []slack.AttachmentAction{
+			{Name: callbackProposal, Text: "Approve", Type: "button", Style: "primary", Value: valueApprove},
+			{Name: callbackProposal, Text: "Reject", Type: "button", Value: valueReject},
+		},
+	}
+	err = ErrNoAnchorMembers
+	sent := 0
+	for _, m := range members {
+		if err = postDM(m, proposalFmt(p), attachment); err != nil {
+			log.WithFields(log.Fields{"proposal": p.ID, "user": m, "ERROR": err}).Error("Could not send proposal")
+			continue
+		}
+		sent++
+	}
+	if sent == 0 {
+		return err
+	}
+	return nil
+}
+
+// handleProposal approves or rejects a proposal when an owner of the component
+// clicks a button
+func handleProposal(cb slack.InteractionCallback, arg string) string {
+	id, err := strconv.Atoi(arg)
+	if err != nil {
+		return unexpectedError
+	}
+	p, err := store.GetProposal(id)
+	if err != nil {
+		if err == ErrNoProposal {
+			return fmt.Sprintf(noSuchProposal, id)
+		}
+		return errMessage(err)
+	}
+	user := cb.User.ID
+	if ok, err := isOwner(user, p.ComponentChan); err != nil {
+		return errMessage(err)
+	} else if !ok {
+		return notOwner
+	}
+	actions := cb.ActionCallback.AttachmentActions
+	status := proposalRejected
+	if len(actions) != 0 && actions[0].Value == valueApprove {
+		status = proposalApproved
+	}
+	if err := store.DecideProposal(id, status, user); err != nil {
+		if err == ErrProposalClosed {
+			return fmt.Sprintf(proposalClosed, id)
+		}
+		return errMessage(err)
+	}
+	log.WithFields(log.Fields{"proposal": id, "status": status, "user": user}).Info("Proposal decided")
+
+	message := fmt.Sprintf("Proposal #%d was %s by %s", id, status, usrFormat(user))
+	if status == proposalApproved {
+		r := response{user: user, channel: p.Channel}
+		change := newChange(r, fmt.Sprintf("approve proposal #%d by %s: %s", id, usrFormat(p.ProposerSlackID), p.Tags))
+		messages := addTags(p.ComponentChan, p.tagList(), change)
+		recordChange(change)
+		message += "\n" + strings.Join(messages, "\n")
+	}
+	if err := postDM(p.ProposerSlackID, message); err != nil {
+		log.WithFields(log.Fields{"proposal": id, "ERROR": err}).Error("Could not tell the proposer about the decision")
+	}
+	return message
+}
+
+// sweepProposals expires unanswered proposals every proposalSweepInterval
+func sweepProposals() {
+	for range time.Tick(proposalSweepInterval) {
+		checkProposals(time.Now())
+	}
+}
+
+// checkProposals expires the proposals nobody answered before now and tells their
+// proposers
+func checkProposals(now time.Time) {
+	expired, err := store.ExpireProposals(now)
+	if err != nil {
+		log.WithField("ERROR", err).Error("Could not expire proposals")
+		return
+	}
+	for _, p := range expired {
+		log.WithField("proposal", p.ID).Info("Proposal expired")
+		if err := postDM(p.ProposerSlackID, fmt.Sprintf(proposalExpiredFmt, p.ID, chanFormat(p.ComponentChan))); err != nil {
+			log.WithFields(log.Fields{"proposal": p.ID, "ERROR": err}).Error("Could not tell the proposer about the expiry")
+		}
+	}
+}
+
+// AddProposal stores a new proposal and sets its ID
+func (s *GormStore) AddProposal(p *Proposal) error {
+	if err := s.db.Create(p).Error; err != nil {
+		return s.fail("create proposal", err)
+	}
+	return nil
+}
+
+// GetProposal returns a proposal by ID
+func (s *GormStore) GetProposal(id int) (Proposal, error) {
+	var p Proposal
+	if err := s.db.First(&p, id).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return p, ErrNoProposal
+		}
+		return p, s.fail("query proposal", err)
+	}
+	return p, nil
+}
+
+// DecideProposal sets the status of a pending proposal which has not expired,
+// returning ErrProposalClosed otherwise
+func (s *GormStore) DecideProposal(id int, status, deciderSlackID string) error {
+	res := s.db.Model(&Proposal{}).
+		Where("id = ? AND status = ? AND expires_at > ?", id, proposalPending, time.Now()).
+		Updates(map[string]interface{}{"status": status, "decider_slack_id": deciderSlackID})
+	if res.Error != nil {
+		return s.fail("decide proposal", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return ErrProposalClosed
+	}
+	return nil
+}
+
+// ExpireProposals marks pending proposals which expired before now and returns them
+func (s *GormStore) ExpireProposals(now time.Time) ([]Proposal, error) {
+	var expired []Proposal
+	tx := s.db.Begin()
+	if err := tx.Where("status = ? AND expires_at <= ?", proposalPending, now).Find(&expired).Error; err != nil {
+		tx.Rollback()
+		return nil, s.fail("query expired proposals", err)
+	}
+	if len(expired) == 0 {
+		tx.Rollback()
+		return nil, nil
+	}
+	var ids []int
+	for i := range expired {
+		ids = append(ids, expired[i].ID)
+		expired[i].Status = proposalExpired
+	}
+	if err := tx.Model(&Proposal{}).Where("id in (?)", ids).Update("status", proposalExpired).Error; err != nil {
+		tx.Rollback()
+		return nil, s.fail("expire proposals", err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, s.fail("expire proposals", err)
+	}
+	return expired, nil
+}
+
+// AddProposal stores a new proposal and sets its ID
+func (s *MemStore) AddProposal(p *Proposal) error {
+	s.Lock()
+	defer s.Unlock()
+	p.ID = s.nextID
+	s.nextID++
+	if p.CreatedAt.IsZero() {
+		p.CreatedAt = time.Now()
+	}
+	s.proposals = append(s.proposals, *p)
+	return nil
+}
+
+// GetProposal returns a proposal by ID
+func (s *MemStore) GetProposal(id int) (Proposal, error) {
+	s.Lock()
+	defer s.Unlock()
+	p := s.proposal(id)
+	if p == nil {
+		return Proposal{}, ErrNoProposal
+	}
+	return *p, nil
+}
+
+// DecideProposal sets the status of a pending proposal which has not expired,
+// returning ErrProposalClosed otherwise
+func (s *MemStore) DecideProposal(id int, status, deciderSlackID string) error {
+	s.Lock()
+	defer s.Unlock()
+	p := s.proposal(id)
+	if p == nil {
+		return ErrNoProposal
+	}
+	if p.Status != proposalPending || !time.Now().Before(p.ExpiresAt) {
+		return ErrProposalClosed
+	}
+	p.Status, p.DeciderSlackID = status, deciderSlackID
+	return nil
+}
+
+// proposal returns the proposal with the ID, or nil if there is none
+func (s *MemStore) proposal(id int) *Proposal {
+	for i := range s.proposals {
+		if s.proposals[i].ID == id {
+			return &s.proposals[i]
+		}
+	}
+	return nil
+}
+
+// ExpireProposals marks pending proposals which expired before now and returns them
+func (s *MemStore) ExpireProposals(now time.Time) (expired []Proposal, err error) {
+	s.Lock()
+	defer s.Unlock()
+	for i := range s.proposals {
+		p := &s.proposals[i]
+		if p.Status == proposalPending && !now.Before(p.ExpiresAt) {
+			p.Status = proposalExpired
+			expired = append(expired, *p)
+		}
+	}
+	return expired, nil
+}
+
+// ErrNoProposal is returned if there is no proposal with the ID
+var ErrNoProposal = errors.New("No proposal with this ID")
+
+// ErrNoAnchorMembers is returned if an anchor user group has no members to send a proposal to
+var ErrNoAnchorMembers = errors.New("The anchor user group has no members")
+
+// ErrProposalClosed is returned if a proposal has already been decided or has expired
+var ErrProposalClosed = errors.New("Proposal is no longer pending")
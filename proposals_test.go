@@ -0,0 +1,233 @@
+/*
+Tests for tag proposals, backed by a MemStore.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+// setupProposals sets the globals proposals use, with UX proposing tags for C1, which
+// is anchored by UC1 and already tagged kafka. The returned function puts them back
+func setupProposals(t *testing.T, groups map[string][]string) (*slackStub, func()) {
+	newTestCache(t, map[string][]string{"kafka": {"C1"}})
+	stub := newSlackStub(groups)
+	oldAdmins := admins
+	admins = nil
+	return stub, func() {
+		admins = oldAdmins
+		stub.Close()
+	}
+}
+
+// clickProposal is the user clicking a button of the proposal with the ID
+func clickProposal(user, arg, value string) string {
+	return handleProposal(slack.InteractionCallback{
+		User: slack.User{ID: user},
+		ActionCallback: slack.ActionCallbacks{
+			AttachmentActions: []*slack.AttachmentAction{{Name: callbackProposal, Value: value}},
+		},
+	}, arg)
+}
+
+func TestProposeTags(t *testing.T) {
+	tests := []struct {
+		name   string
+		groups map[string][]string
+		anchor string
+		sentTo []string
+	}{
+		{"to the anchor", nil, "UC1", []string{"DUC1"}},
+		{"to a user group", map[string][]string{"S1": {"U5", "U6"}}, "S1", []string{"DU5", "DU6"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub, done := setupProposals(t, tt.groups)
+			defer done()
+			if err := store.ChangeAnchor("C1", tt.anchor); err != nil {
+				t.Fatal(err)
+			}
+			r := response{user: "UX", channel: "D1", isEphemeral: true}
+			text := "<@B> tag <#C1|c1> kafka, redis, Zookeeper"
+			proposeTags(text, strings.Fields(text), r)
+
+			posts := stub.posts()
+			proposals := store.(*MemStore).proposals
+			if len(proposals) != 1 {
+				t.Fatalf("proposed %+v", proposals)
+			}
+			p := proposals[0]
+			if p.Tags != "redis,zookeeper" || p.Status != proposalPending || p.ProposerSlackID != "UX" || p.ComponentChan != "C1" {
+				t.Fatalf("proposed %+v", p)
+			}
+			want := []slackPost{{"D1", fmt.Sprintf(alreadyAdded, "kafka")}}
+			for _, ch := range tt.sentTo {
+				want = append(want, slackPost{ch, proposalFmt(p)})
+			}
+			want = append(want, slackPost{"D1", fmt.Sprintf(proposalSent, p.ID, anchorFormat(tt.anchor))})
+			if !reflect.DeepEqual(posts, want) {
+				t.Errorf("posted %q, want %q", posts, want)
+			}
+			if cache.ContainsTag("redis") {
+				t.Error("a proposed tag was added before it was approved")
+			}
+		})
+	}
+}
+
+func TestHandleProposal(t *testing.T) {
+	stub, done := setupProposals(t, nil)
+	defer done()
+	propose := func(expires time.Duration) int {
+		p := Proposal{ExpiresAt: time.Now().Add(expires), ProposerSlackID: "UX", Channel: "D1", ComponentChan: "C1", Tags: "redis,zookeeper", Status: proposalPending}
+		if err := store.AddProposal(&p); err != nil {
+			t.Fatal(err)
+		}
+		return p.ID
+	}
+
+	// a non-owner can't decide
+	id := propose(time.Hour)
+	arg := strconv.Itoa(id)
+	if got := clickProposal("UY", arg, valueApprove); got != notOwner {
+		t.Errorf("a non-owner approving got %q", got)
+	}
+	if p, _ := store.GetProposal(id); p.Status != proposalPending {
+		t.Errorf("after a non-owner approved, the proposal is %s", p.Status)
+	}
+
+	// the owner approves
+	got := clickProposal("UC1", arg, valueApprove)
+	if want := fmt.Sprintf("Proposal #%d was approved by <@UC1>", id); !strings.HasPrefix(got, want) {
+		t.Errorf("approving got %q, want %q first", got, want)
+	}
+	if p, _ := store.GetProposal(id); p.Status != proposalApproved || p.DeciderSlackID != "UC1" {
+		t.Errorf("after approving, the proposal is %+v", p)
+	}
+	for _, tag := range []string{"redis", "zookeeper"} {
+		if !cache.ContainsTagInfo(TagInfo{Name: tag, ComponentChan: "C1"}) {
+			t.Errorf("approving didn't tag C1 %s", tag)
+		}
+	}
+	if posts := stub.posts(); len(posts) != 1 || posts[0] != (slackPost{"DUX", got}) {
+		t.Errorf("the proposer was sent %q", posts)
+	}
+	if h, _ := store.History(AuditEntry{ComponentChan: "C1"}, 1); len(h) != 1 || h[0].ActorSlackID != "UC1" || len(h[0].Entries) != 2 {
+		t.Errorf("the approval was recorded as %+v", h)
+	}
+
+	// it can't be decided again
+	if got := clickProposal("UC1", arg, valueReject); got != fmt.Sprintf(proposalClosed, id) {
+		t.Errorf("rejecting an approved proposal got %q", got)
+	}
+
+	// the owner rejects another
+	id = propose(time.Hour)
+	got = clickProposal("UC1", strconv.Itoa(id), valueReject)
+	if want := fmt.Sprintf("Proposal #%d was rejected by <@UC1>", id); got != want {
+		t.Errorf("rejecting got %q, want %q", got, want)
+	}
+	if p, _ := store.GetProposal(id); p.Status != proposalRejected {
+		t.Errorf("after rejecting, the proposal is %s", p.Status)
+	}
+	if posts := stub.posts(); len(posts) != 1 || posts[0] != (slackPost{"DUX", got}) {
+		t.Errorf("the proposer was sent %q", posts)
+	}
+
+	// an expired proposal can't be decided, even before it is swept
+	id = propose(-time.Minute)
+	if got := clickProposal("UC1", strconv.Itoa(id), valueApprove); got != fmt.Sprintf(proposalClosed, id) {
+		t.Errorf("approving an expired proposal got %q", got)
+	}
+	if cache.Count != 3 {
+		t.Errorf("%d tags after the rejected and expired proposals, want 3", cache.Count)
+	}
+
+	if got := clickProposal("UC1", "999", valueApprove); got != fmt.Sprintf(noSuchProposal, 999) {
+		t.Errorf("approving an unknown proposal got %q", got)
+	}
+	if got := clickProposal("UC1", "x", valueApprove); got != unexpectedError {
+		t.Errorf("approving proposal x got %q", got)
+	}
+}
+
+func TestExpireProposals(t *testing.T) {
+	g, done := newTestGormStore(t)
+	defer done()
+	now := time.Now()
+	for name, s := range map[string]Store{"mem": NewMemStore(), "sqlite": g} {
+		t.Run(name, func(t *testing.T) {
+			var ids []int
+			for _, p := range []Proposal{
+				{ExpiresAt: now.Add(-time.Hour), Status: proposalPending},
+				{ExpiresAt: now.Add(time.Hour), Status: proposalPending},
+				{ExpiresAt: now.Add(-time.Hour), Status: proposalRejected},
+				{ExpiresAt: now, Status: proposalPending},
+			} {
+				p.ProposerSlackID, p.ComponentChan, p.Tags = "UX", "C1", "redis"
+				if err := s.AddProposal(&p); err != nil {
+					t.Fatal(err)
+				}
+				ids = append(ids, p.ID)
+			}
+			expired, err := s.ExpireProposals(now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []int
+			for _, p := range expired {
+				got = append(got, p.ID)
+				if p.Status != proposalExpired {
+					t.Errorf("expired proposal %d is %s", p.ID, p.Status)
+				}
+			}
+			if want := []int{ids[0], ids[3]}; !reflect.DeepEqual(got, want) {
+				t.Errorf("expired %v, want %v", got, want)
+			}
+			for i, want := range []string{proposalExpired, proposalPending, proposalRejected, proposalExpired} {
+				if p, _ := s.GetProposal(ids[i]); p.Status != want {
+					t.Errorf("proposal %d is %s, want %s", ids[i], p.Status, want)
+				}
+			}
+			if err := s.DecideProposal(ids[0], proposalApproved, "UC1"); err != ErrProposalClosed {
+				t.Errorf("deciding an expired proposal = %v, want ErrProposalClosed", err)
+			}
+			if expired, err := s.ExpireProposals(now); err != nil || len(expired) != 0 {
+				t.Errorf("expiring again = %+v, %v", expired, err)
+			}
+		})
+	}
+}
+
+func TestCheckProposals(t *testing.T) {
+	stub, done := setupProposals(t, nil)
+	defer done()
+	now := time.Now()
+	var ids []int
+	for _, expires := range []time.Duration{-time.Minute, time.Hour} {
+		p := Proposal{ExpiresAt: now.Add(expires), ProposerSlackID: "UX", ComponentChan: "C1", Tags: "redis", Status: proposalPending}
+		if err := store.AddProposal(&p); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, p.ID)
+	}
+	checkProposals(now)
+	want := []slackPost{{"DUX", fmt.Sprintf(proposalExpiredFmt, ids[0], chanFormat("C1"))}}
+	if posts := stub.posts(); !reflect.DeepEqual(posts, want) {
+		t.Errorf("the sweep posted %q, want %q", posts, want)
+	}
+	checkProposals(now)
+	if posts := stub.posts(); len(posts) != 0 {
+		t.Errorf("sweeping again posted %q", posts)
+	}
+}
@@ -0,0 +1,417 @@
+/*
+Reverting changes recorded in the audit trail.
+
+A change is reverted by applying the inverse of each of its entries, newest first,
+through the tag cache so the database and the cache stay in step. The revert is
+itself recorded as a new change pointing at the one it reverts.
+
+Every entry is checked before anything is reverted, so a change overwritten since is
+left alone. The entries are then reverted one at a time, each in the database and the
+cache together, but not all in one transaction: if the database fails part way, the
+change stays partially reverted, and the entries reverted so far are recorded and
+shown. Adding a component cannot be reverted.
+
+Users can undo their own last change within the configured undo window, as long as
+they still have the permissions it needed; admins can revert any change by ID. Denied
+attempts cannot be reverted.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"errors"
+)
+
+// revertChange reverts every entry of a change as a new change made by the user
+// in the response. Nothing is changed if an entry can't be reverted, but an error
+// part way leaves the entries reverted so far in the returned change
+func revertChange(c Change, r response, command string) (*Change, error) {
+	reverted, err := store.IsReverted(c.ID)
+	if err != nil {
+		return nil, err
+	}
+	if reverted {
+		return nil, ErrAlreadyReverted
+	}
+	for _, e := range c.Entries {
+		if err := checkRevertible(e); err != nil {
+			return nil, err
+		}
+	}
+
+	rev := newChange(r, command)
+	rev.RevertOf = c.ID
+	defer recordChange(rev)
+	for i := len(c.Entries) - 1; i >= 0; i-- {
+		if err := revertEntry(c.Entries[i], rev); err != nil {
+			return rev, err
+		}
+	}
+	return rev, nil
+}
+
+// undoChans returns the component channels a user must own to undo a change - the
+// same ones the commands in it needed. admin is true if they needed an admin instead
+func undoChans(c Change) (chans []string, admin bool) {
+	seen := make(map[string]bool)
+	add := func(componentChan string) {
+		if !seen[componentChan] {
+			seen[componentChan] = true
+			chans = append(chans, componentChan)
+		}
+	}
+	for _, e := range c.Entries {
+		switch {
+		case e.Action == actionAddComponent:
+			admin = true
+		case (e.Action == actionGrant || e.Action == actionRevoke) && e.ComponentChan == "":
+			admin = true // the admin role
+		case e.ComponentChan != "":
+			add(e.ComponentChan)
+		case e.Tag != "":
+			// aliases belong to every component of their tag
+			for _, componentChan := range splitList(tagChans(e.Tag)) {
+				add(componentChan)
+			}
+		}
+	}
+	return chans, admin
+}
+
+// checkRevertible makes sure an entry still describes the current state, so a
+// revert doesn't overwrite someone else's later change
+func checkRevertible(e AuditEntry) error {
+	tag := TagInfo{Name: e.Tag, ComponentChan: e.ComponentChan}
+	switch e.Action {
+	case actionTag:
+		if !cache.ContainsTagInfo(tag) {
+			return ErrRevertConflict
+		}
+	case actionUntag, actionDrop:
+		// if the tag was added back since, only its aliases are restored - unless
+		// another tag took them in the meantime
+		_, aliases := entryTag(e.Before)
+		for _, alias := range aliases {
+			if cache.ContainsTag(alias) && cache.Canonical(alias) != e.Tag {
+				return ErrRevertConflict
+			}
+		}
+	case actionAlias:
+		if cache.Canonical(e.After) != e.Tag || e.After == e.Tag {
+			return ErrRevertConflict
+		}
+	case actionUnalias:
+		// nothing to check - adding the alias back fails if it was taken since
+	case actionGrant:
+		ok, err := store.HasRole(entryRole(e))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrRevertConflict
+		}
+	case actionRevoke:
+		// nothing to check - if the role was granted again since, reverting is a no-op
+	case actionAnchorAdd:
+		a := entryAnchor(e)
+		component, err := store.GetAnchor(e.ComponentChan)
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, anchor := range component.Anchors {
+			found = found || (anchor.SlackID == a.SlackID && anchor.Role == a.Role)
+		}
+		if !found {
+			return ErrRevertConflict
+		}
+	case actionAnchorRemove:
+		// nothing to check - adding the anchor back fails if they anchor it again since
+	case actionRotation:
+		component, err := store.GetAnchor(e.ComponentChan)
+		if err != nil {
+			return err
+		}
+		if rotationEntry(component.Rotation) != e.After {
+			return ErrRevertConflict
+		}
+	case actionOverride:
+		if e.After == "" {
+			break // nothing to check - putting the override back only adds to the schedule
+		}
+		o := entryOverride(e)
+		component, err := store.GetAnchor(e.ComponentChan)
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, active := range component.Overrides {
+			found = found || (active.SlackID == o.SlackID && active.Until.Equal(o.Until))
+		}
+		if !found {
+			return ErrRevertConflict
+		}
+	case actionAway:
+		a := entryAbsence(e.After)
+		if a == nil {
+			a = entryAbsence(e.Before)
+		}
+		if a == nil {
+			return ErrNotRevertible
+		}
+		current := ""
+		if active, err := store.GetAway(a.SlackID, awayManual); err == nil {
+			current = absenceEntry(&active)
+		} else if err != ErrNotAway {
+			return err
+		}
+		if current != e.After {
+			return ErrRevertConflict
+		}
+	case actionAnchor, actionPlaybook, actionName, actionDescription, actionArea:
+		component, err := store.GetAnchor(e.ComponentChan)
+		if err != nil {
+			return err
+		}
+		current := component.AnchorSlackID
+		switch e.Action {
+		case actionPlaybook:
+			current = component.playbookURL()
+		case actionName, actionDescription, actionArea:
+			current = component.info(e.Action)
+		}
+		if current != e.After {
+			return ErrRevertConflict
+		}
+	case actionPlaybookAdd:
+		p := entryPlaybook(e)
+		component, err := store.GetAnchor(e.ComponentChan)
+		if err != nil {
+			return err
+		}
+		if current, ok := component.playbook(p.Title); !ok || playbookEntry(current) != e.After {
+			return ErrRevertConflict
+		}
+	case actionPlaybookURL:
+		p := entryPlaybook(e)
+		component, err := store.GetAnchor(e.ComponentChan)
+		if err != nil {
+			return err
+		}
+		if current, ok := component.playbook(p.Title); !ok || current.URL != p.URL {
+			return ErrRevertConflict
+		}
+	case actionPlaybookRemove:
+		// nothing to check - adding the playbook back fails if the title was taken since
+	case actionLink:
+		component, err := store.GetAnchor(e.ComponentChan)
+		if err != nil {
+			return err
+		}
+		l := entryLink(e.ComponentChan, e.After)
+		if l == nil {
+			l = entryLink(e.ComponentChan, e.Before)
+		}
+		if l == nil {
+			return ErrNotRevertible
+		}
+		current := ""
+		if active, ok := component.link(l.Label); ok {
+			current = linkEntry(&active)
+		}
+		if current != e.After {
+			return ErrRevertConflict
+		}
+	default:
+		return ErrNotRevertible
+	}
+	return nil
+}
+
+// revertEntry applies the inverse of an entry and records it in rev
+func revertEntry(e AuditEntry, rev *Change) error {
+	tag := TagInfo{Name: e.Tag, ComponentChan: e.ComponentChan}
+	switch e.Action {
+	case actionTag:
+		before := tagEntry(e.Tag)
+		if err := cache.Untag(tag); err != nil {
+			return err
+		}
+		rev.add(actionUntag, e.ComponentChan, e.Tag, before, tagChans(e.Tag))
+	case actionUntag, actionDrop:
+		if !cache.ContainsTagInfo(tag) {
+			before := tagChans(e.Tag)
+			if err := cache.Add(tag); err != nil {
+				return err
+			}
+			rev.add(actionTag, e.ComponentChan, e.Tag, before, tagChans(e.Tag))
+		}
+		// aliases go with the last component, so bring back the ones which went
+		_, aliases := entryTag(e.Before)
+		for _, alias := range aliases {
+			if cache.Canonical(alias) == e.Tag {
+				continue
+			}
+			if _, err := cache.AddAlias(alias, e.Tag); err != nil {
+				if err == ErrAliasTaken {
+					return ErrRevertConflict
+				}
+				return err
+			}
+			rev.add(actionAlias, "", e.Tag, "", alias)
+		}
+	case actionAlias:
+		if _, err := cache.RemoveAlias(e.After); err != nil {
+			return err
+		}
+		rev.add(actionUnalias, "", e.Tag, e.After, "")
+	case actionUnalias:
+		if _, err := cache.AddAlias(e.Before, e.Tag); err != nil {
+			if err == ErrAliasTaken {
+				return ErrRevertConflict
+			}
+			return err
+		}
+		rev.add(actionAlias, "", e.Tag, "", e.Before)
+	case actionGrant:
+		if err := store.RevokeRole(entryRole(e)); err != nil {
+			return err
+		}
+		rev.add(actionRevoke, e.ComponentChan, "", e.After, "")
+	case actionRevoke:
+		if err := store.GrantRole(entryRole(e)); err != nil {
+			if err == ErrRoleExists {
+				return nil
+			}
+			return err
+		}
+		rev.add(actionGrant, e.ComponentChan, "", "", e.Before)
+	case actionAnchorAdd:
+		if err := store.RemoveComponentAnchor(e.ComponentChan, entryAnchor(e).SlackID); err != nil {
+			return err
+		}
+		rev.add(actionAnchorRemove, e.ComponentChan, "", e.After, "")
+		cache.Load()
+	case actionAnchorRemove:
+		if err := store.AddComponentAnchor(entryAnchor(e)); err != nil {
+			if err == ErrAnchorExists {
+				return ErrRevertConflict
+			}
+			return err
+		}
+		rev.add(actionAnchorAdd, e.ComponentChan, "", "", e.Before)
+		cache.Load()
+	case actionRotation:
+		var err error
+		if before := entryRotation(e.ComponentChan, e.Before); before != nil {
+			err = store.SetRotation(*before)
+		} else {
+			err = store.RemoveRotation(e.ComponentChan)
+		}
+		if err != nil {
+			return err
+		}
+		rev.add(actionRotation, e.ComponentChan, "", e.After, e.Before)
+		cache.Load()
+	case actionOverride:
+		var err error
+		if e.After == "" {
+			err = store.AddOverride(entryOverride(e))
+		} else if err = store.RemoveOverride(entryOverride(e)); err == ErrNoOverride {
+			return ErrRevertConflict
+		}
+		if err != nil {
+			return err
+		}
+		rev.add(actionOverride, e.ComponentChan, "", e.After, e.Before)
+		cache.Load()
+	case actionAway:
+		var err error
+		if before := entryAbsence(e.Before); before != nil {
+			err = store.SetAway(*before)
+		} else if after := entryAbsence(e.After); after != nil {
+			err = store.ClearAway(after.SlackID, awayManual)
+		}
+		if err != nil {
+			return err
+		}
+		rev.add(actionAway, "", "", e.After, e.Before)
+		cache.Load()
+	case actionAnchor:
+		if err := store.ChangeAnchor(e.ComponentChan, e.Before); err != nil {
+			return err
+		}
+		rev.add(actionAnchor, e.ComponentChan, "", e.After, e.Before)
+		cache.Load()
+	case actionPlaybook:
+		if err := store.ChangePlaybook(e.ComponentChan, e.Before); err != nil {
+			return err
+		}
+		rev.add(actionPlaybook, e.ComponentChan, "", e.After, e.Before)
+		cache.Load()
+		reindexPlaybooks(e.ComponentChan)
+	case actionPlaybookAdd:
+		if err := store.RemovePlaybook(e.ComponentChan, entryPlaybook(e).Title); err != nil {
+			if err == ErrNoPlaybook {
+				return ErrRevertConflict
+			}
+			return err
+		}
+		rev.add(actionPlaybookRemove, e.ComponentChan, "", e.After, "")
+		cache.Load()
+		reindexPlaybooks(e.ComponentChan)
+	case actionPlaybookRemove:
+		if err := store.AddPlaybook(entryPlaybook(e)); err != nil {
+			if err == ErrPlaybookExists {
+				return ErrRevertConflict
+			}
+			return err
+		}
+		rev.add(actionPlaybookAdd, e.ComponentChan, "", "", e.Before)
+		cache.Load()
+		reindexPlaybooks(e.ComponentChan)
+	case actionPlaybookURL:
+		before := valuePlaybook(e.ComponentChan, e.Before)
+		if err := store.ChangePlaybookURL(e.ComponentChan, before.Title, before.URL); err != nil {
+			if err == ErrNoPlaybook {
+				return ErrRevertConflict
+			}
+			return err
+		}
+		rev.add(actionPlaybookURL, e.ComponentChan, "", e.After, e.Before)
+		cache.Load()
+		reindexPlaybooks(e.ComponentChan)
+	case actionName, actionDescription, actionArea:
+		if err := store.ChangeInfo(e.ComponentChan, e.Action, e.Before); err != nil {
+			return err
+		}
+		rev.add(e.Action, e.ComponentChan, "", e.After, e.Before)
+		cache.Load()
+	case actionLink:
+		var err error
+		if before := entryLink(e.ComponentChan, e.Before); before != nil {
+			err = store.SetLink(*before)
+		} else if after := entryLink(e.ComponentChan, e.After); after != nil {
+			err = store.RemoveLink(e.ComponentChan, after.Label)
+		}
+		if err != nil {
+			return err
+		}
+		rev.add(actionLink, e.ComponentChan, "", e.After, e.Before)
+		cache.Load()
+	default:
+		return ErrNotRevertible
+	}
+	return nil
+}
+
+// ErrAlreadyReverted is returned if a change has already been reverted
+var ErrAlreadyReverted = errors.New("Change has already been reverted")
+
+// ErrRevertConflict is returned if something was changed again after the change being reverted
+var ErrRevertConflict = errors.New("Change has been overwritten by a later change")
+
+// ErrNotRevertible is returned for changes which cannot be reverted
+var ErrNotRevertible = errors.New("Change cannot be reverted")
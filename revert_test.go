@@ -0,0 +1,190 @@
+/*
+Tests for undoing and reverting changes, backed by a MemStore.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setupRevert sets the globals undo and revert use: a cache of the tags, a slack stub
+// and UADMIN as the only admin. The returned function puts them back
+func setupRevert(t *testing.T, tags map[string][]string) (*TagCache, *slackStub, func()) {
+	c := newTestCache(t, tags)
+	stub := newSlackStub(nil)
+	oldAdmins, oldWindow := admins, undoWindow
+	admins, undoWindow = []string{"UADMIN"}, time.Hour
+	return c, stub, func() {
+		admins, undoWindow = oldAdmins, oldWindow
+		stub.Close()
+	}
+}
+
+// hasMessage returns true if one of the messages starts with prefix
+func hasMessage(messages []string, prefix string) bool {
+	for _, m := range messages {
+		if strings.HasPrefix(m, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestUndoDrop(t *testing.T) {
+	c, stub, done := setupRevert(t, map[string][]string{"kafka": {"C1", "C2"}, "postgres": {"C1"}})
+	defer done()
+	if _, err := c.AddAlias("kfk", "kafka"); err != nil {
+		t.Fatal(err)
+	}
+	r := response{user: "UADMIN", channel: "D1", isEphemeral: true}
+
+	doDropTags("<@B> drop kafka", []string{"kafka"}, r)
+	if c.ContainsTag("kafka") || c.ContainsTag("kfk") {
+		t.Fatalf("kafka is still tagged: %v", c.GetNames())
+	}
+	undoChange("<@B> undo", r)
+	if got := stub.messages(); !hasMessage(got, "Reverted change #1") {
+		t.Errorf("undo said %q", got)
+	}
+	if got := findChans(c, "kafka"); !reflect.DeepEqual(got, []string{"C1", "C2"}) {
+		t.Errorf("after undo, kafka is on %v", got)
+	}
+	if got := c.Canonical("kfk"); got != "kafka" {
+		t.Errorf("after undo, kfk is an alias of %q", got)
+	}
+
+	// the undo itself can't be undone, and the drop has been
+	undoChange("<@B> undo", r)
+	if got := stub.messages(); !reflect.DeepEqual(got, []string{nothingToUndo}) {
+		t.Errorf("a second undo said %q", got)
+	}
+}
+
+func TestUndoUntagWithAliases(t *testing.T) {
+	c, stub, done := setupRevert(t, map[string][]string{"kafka": {"C1"}})
+	defer done()
+	for _, alias := range []string{"kfk", "event bus"} {
+		if _, err := c.AddAlias(alias, "kafka"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := response{user: "UC1", channel: "D1", isEphemeral: true}
+
+	text := "<@B> untag <#C1|c1> kafka"
+	untagTags(text, strings.Fields(text), r)
+	if c.ContainsTag("kafka") || c.ContainsTag("kfk") || c.ContainsTag("event bus") {
+		t.Fatalf("untagging the last component left %v", c.GetNames())
+	}
+	undoChange("<@B> undo", r)
+	if got := stub.messages(); !hasMessage(got, "Reverted change #1") {
+		t.Errorf("undo said %q", got)
+	}
+	if got := findChans(c, "kafka"); !reflect.DeepEqual(got, []string{"C1"}) {
+		t.Errorf("after undo, kafka is on %v", got)
+	}
+	for _, alias := range []string{"kfk", "event bus"} {
+		if got := c.Canonical(alias); got != "kafka" {
+			t.Errorf("after undo, %s is an alias of %q", alias, got)
+		}
+	}
+}
+
+func TestRevertAnchorChange(t *testing.T) {
+	_, stub, done := setupRevert(t, map[string][]string{"kafka": {"C1"}})
+	defer done()
+	if err := store.AddComponentAnchor(ComponentAnchor{ComponentChan: "C1", SlackID: "U2", Role: anchorBackup}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the backup becomes the primary anchor, and is no longer a backup
+	words := strings.Fields("<@B> set <#C1|c1> anchor <@U2>")
+	doSetAnchor(words, response{user: "UC1", channel: "D1", isEphemeral: true})
+	if c, _ := store.GetAnchor("C1"); c.AnchorSlackID != "U2" || len(c.Anchors) != 0 {
+		t.Fatalf("after the change, C1 is anchored by %s and %+v", c.AnchorSlackID, c.Anchors)
+	}
+
+	admin := response{user: "UADMIN", channel: "D1", isEphemeral: true}
+	text := "<@B> revert #1"
+	revertByID(text, strings.Fields(text), admin)
+	if got := stub.messages(); !hasMessage(got, "Reverted change #1") {
+		t.Errorf("revert said %q", got)
+	}
+	c, _ := store.GetAnchor("C1")
+	if c.AnchorSlackID != "UC1" || len(c.Anchors) != 1 || c.Anchors[0].SlackID != "U2" || c.Anchors[0].Role != anchorBackup {
+		t.Errorf("after the revert, C1 is anchored by %s and %+v", c.AnchorSlackID, c.Anchors)
+	}
+	if tags := cache.Find("kafka"); len(tags) != 1 || tags[0].Anchor != "UC1" {
+		t.Errorf("after the revert, the cache has %+v", tags)
+	}
+
+	revertByID(text, strings.Fields(text), admin)
+	if got := stub.messages(); !reflect.DeepEqual(got, []string{fmt.Sprintf(alreadyReverted, 1)}) {
+		t.Errorf("a second revert said %q", got)
+	}
+}
+
+func TestUndoWindow(t *testing.T) {
+	c, stub, done := setupRevert(t, map[string][]string{"kafka": {"C1"}, "postgres": {"C1"}})
+	defer done()
+	undoWindow = time.Minute
+	r := response{user: "UC1", channel: "D1", isEphemeral: true}
+
+	// untag kafka two minutes ago
+	tag := TagInfo{Name: "kafka", ComponentChan: "C1"}
+	change := newChange(r, "<@B> untag <#C1|c1> kafka")
+	change.add(actionUntag, "C1", "kafka", tagEntry("kafka"), "")
+	if err := c.Untag(tag); err != nil {
+		t.Fatal(err)
+	}
+	change.CreatedAt = time.Now().Add(-2 * time.Minute)
+	recordChange(change)
+
+	undoChange("<@B> undo", r)
+	if got := stub.messages(); !reflect.DeepEqual(got, []string{fmt.Sprintf(undoExpired, 1)}) {
+		t.Errorf("undo after the window said %q", got)
+	}
+	if c.ContainsTag("kafka") {
+		t.Error("undo after the window tagged kafka again")
+	}
+
+	undoWindow = time.Hour
+	undoChange("<@B> undo", r)
+	if got := stub.messages(); !hasMessage(got, "Reverted change #1") {
+		t.Errorf("undo within the window said %q", got)
+	}
+	if !c.ContainsTagInfo(tag) {
+		t.Error("undo within the window didn't tag kafka again")
+	}
+}
+
+func TestUndoNeedsOwnership(t *testing.T) {
+	c, stub, done := setupRevert(t, map[string][]string{"kafka": {"C1"}, "postgres": {"C1"}})
+	defer done()
+	r := response{user: "UC1", channel: "D1", isEphemeral: true}
+	text := "<@B> untag <#C1|c1> kafka"
+	untagTags(text, strings.Fields(text), r)
+	stub.messages()
+
+	// UC1 hands the component over before undoing
+	if err := store.ChangeAnchor("C1", "U2"); err != nil {
+		t.Fatal(err)
+	}
+	undoChange("<@B> undo", r)
+	if got := stub.messages(); !reflect.DeepEqual(got, []string{notOwner}) {
+		t.Errorf("undo by a former owner said %q", got)
+	}
+	if c.ContainsTag("kafka") {
+		t.Error("undo by a former owner tagged kafka again")
+	}
+	h, err := store.History(AuditEntry{ComponentChan: "C1"}, 1)
+	if err != nil || len(h) != 1 || !h[0].denied() || h[0].ActorSlackID != "UC1" {
+		t.Errorf("the denied undo was recorded as %+v, %v", h, err)
+	}
+}
@@ -0,0 +1,396 @@
+/*
+Anchor rotations and overrides.
+
+A component can have a rotation: an ordered list of anchors, handing off to the next
+one every period, starting from the first handoff time. An override puts someone else
+on duty until a given time, for swaps. The anchor on duty is worked out whenever it is
+shown - an active override wins over the rotation, and the rotation over the primary
+anchor stored on the component.
+
+All times are UTC.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+)
+
+// Rotation is the anchor rotation of a component
+type Rotation struct {
+	ID            int
+	ComponentChan string        `gorm:"type:varchar(20)"`
+	Members       string        `gorm:"type:text"` // comma separated anchors, in rotation order
+	Period        time.Duration `gorm:"type:bigint"`
+	Start         time.Time     // handoff to the first member
+}
+
+// AnchorOverride puts an anchor on duty for a component until a given time
+type AnchorOverride struct {
+	ID            int
+	CreatedAt     time.Time
+	ComponentChan string `gorm:"type:varchar(20)"`
+	SlackID       string `gorm:"type:varchar(20)"`
+	Until         time.Time
+}
+
+// ScheduleStore stores anchor rotations and overrides. Active overrides and the
+// rotation are loaded with components and tags
+type ScheduleStore interface {
+	// SetRotation sets the rotation of a component, replacing any existing one
+	SetRotation(r Rotation) error
+	// RemoveRotation removes the rotation of a component, returning ErrNoRotation if
+	// it has none
+	RemoveRotation(componentChan string) error
+	// AddOverride adds an override to a component
+	AddOverride(o AnchorOverride) error
+	// RemoveOverride removes an override matching the component, anchor and end
+	// time, returning ErrNoOverride if there is none
+	RemoveOverride(o AnchorOverride) error
+}
+
+const (
+	minRotationPeriod = time.Hour
+	// number of handoffs shown by the rotation command
+	rotationShown = 6
+	// layouts accepted for dates and times
+	dateLayout     = "2006-01-02"
+	dateTimeLayout = "2006-01-02 15:04"
+)
+
+// memberList returns the members of the rotation in order
+func (r Rotation) memberList() []string {
+	return splitList(r.Members)
+}
+
+// anchorAt returns the rotation member on duty at t, or "" if the rotation has not
+// started yet
+func (r Rotation) anchorAt(t time.Time) string {
+	members := r.memberList()
+	if len(members) == 0 || r.Period <= 0 || t.Before(r.Start) {
+		return ""
+	}
+	n := int64(t.Sub(r.Start) / r.Period)
+	return members[n%int64(len(members))]
+}
+
+// handoffs returns the next n handoffs after t, including the current one if the
+// rotation has started
+func (r Rotation) handoffs(t time.Time, n int) (times []time.Time, anchors []string) {
+	members := r.memberList()
+	if len(members) == 0 || r.Period <= 0 {
+		return nil, nil
+	}
+	i := int64(0)
+	if !t.Before(r.Start) {
+		i = int64(t.Sub(r.Start) / r.Period)
+	}
+	for ; len(times) < n; i++ {
+		times = append(times, r.Start.Add(time.Duration(i)*r.Period))
+		anchors = append(anchors, members[i%int64(len(members))])
+	}
+	return times, anchors
+}
+
+// currentAnchor returns the anchor on duty at now: the latest active override, then
+// the rotation, then the primary anchor
+func currentAnchor(primary string, rotation *Rotation, overrides []AnchorOverride, now time.Time) string {
+	for i := len(overrides) - 1; i >= 0; i-- {
+		if o := overrides[i]; now.Before(o.Until) {
+			return o.SlackID
+		}
+	}
+	if rotation != nil {
+		if a := rotation.anchorAt(now); a != "" {
+			return a
+		}
+	}
+	return primary
+}
+
+// onDuty returns the anchor on duty for a component right now
+func (c Component) onDuty() string {
+	return currentAnchor(c.AnchorSlackID, c.Rotation, c.Overrides, time.Now())
+}
+
+// scheduledAnchors returns everyone who anchors the component through its rotation
+// or an active override
+func (c Component) scheduledAnchors() (anchors []string) {
+	if c.Rotation != nil {
+		anchors = append(anchors, c.Rotation.memberList()...)
+	}
+	for _, o := range c.Overrides {
+		anchors = append(anchors, o.SlackID)
+	}
+	return anchors
+}
+
+// parsePeriod parses a rotation period like 1w, 14d or 12h
+func parsePeriod(s string) (time.Duration, error) {
+	var (
+		d   time.Duration
+		err error
+	)
+	switch {
+	case strings.HasSuffix(s, "w"), strings.HasSuffix(s, "d"):
+		n, convErr := strconv.Atoi(s[:len(s)-1])
+		if convErr != nil {
+			return 0, ErrInvalidPeriod
+		}
+		d = time.Duration(n) * 24 * time.Hour
+		if strings.HasSuffix(s, "w") {
+			d *= 7
+		}
+	default:
+		if d, err = time.ParseDuration(s); err != nil {
+			return 0, ErrInvalidPeriod
+		}
+	}
+	if d < minRotationPeriod {
+		return 0, ErrInvalidPeriod
+	}
+	return d, nil
+}
+
+// parseDate parses a date, optionally followed by a time, from the start of words.
+// It returns the number of words used
+func parseDate(words []string) (time.Time, int, error) {
+	if len(words) > 1 {
+		if t, err := time.Parse(dateTimeLayout, words[0]+" "+words[1]); err == nil {
+			return t, 2, nil
+		}
+	}
+	if len(words) > 0 {
+		if t, err := time.Parse(dateLayout, words[0]); err == nil {
+			return t, 1, nil
+		}
+	}
+	return time.Time{}, 0, ErrInvalidDate
+}
+
+// rotationEntry returns the audit entry value for a rotation
+func rotationEntry(r *Rotation) string {
+	if r == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s %d %s", r.Members, int64(r.Period/time.Second), r.Start.UTC().Format(time.RFC3339))
+}
+
+// entryRotation returns the rotation described by an audit entry value, nil for none.
+// The members come first and may contain spaces
+func entryRotation(componentChan, v string) *Rotation {
+	f := strings.Fields(v)
+	if len(f) < 3 {
+		return nil
+	}
+	n := len(f)
+	secs, err := strconv.ParseInt(f[n-2], 10, 64)
+	if err != nil {
+		return nil
+	}
+	start, err := time.Parse(time.RFC3339, f[n-1])
+	if err != nil {
+		return nil
+	}
+	members := strings.Join(f[:n-2], " ")
+	return &Rotation{ComponentChan: componentChan, Members: members, Period: time.Duration(secs) * time.Second, Start: start}
+}
+
+// overrideEntry returns the audit entry value for an override
+func overrideEntry(o AnchorOverride) string {
+	return o.SlackID + " " + o.Until.UTC().Format(time.RFC3339)
+}
+
+// entryOverride returns the override described by an audit entry for adding or
+// removing an override
+func entryOverride(e AuditEntry) AnchorOverride {
+	v := e.After
+	if v == "" {
+		v = e.Before
+	}
+	o := AnchorOverride{ComponentChan: e.ComponentChan}
+	if f := strings.Fields(v); len(f) == 2 {
+		o.SlackID = f[0]
+		o.Until, _ = time.Parse(time.RFC3339, f[1])
+	}
+	return o
+}
+
+// SetRotation sets the rotation of a component, replacing any existing one
+func (s *GormStore) SetRotation(r Rotation) error {
+	if _, err := s.findComponent(r.ComponentChan); err != nil {
+		return err
+	}
+	tx := s.db.Begin()
+	if err := tx.Where("component_chan = ?", r.ComponentChan).Delete(&Rotation{}).Error; err != nil {
+		tx.Rollback()
+		return s.fail("replace rotation", err)
+	}
+	r.ID = 0
+	if err := tx.Create(&r).Error; err != nil {
+		tx.Rollback()
+		return s.fail("create rotation", err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return s.fail("set rotation", err)
+	}
+	log.WithFields(log.Fields{"component": r.ComponentChan, "members": r.Members, "period": r.Period}).Info("set rotation")
+	return nil
+}
+
+// RemoveRotation removes the rotation of a component, returning ErrNoRotation if it
+// has none
+func (s *GormStore) RemoveRotation(componentChan string) error {
+	res := s.db.Where("component_chan = ?", componentChan).Delete(&Rotation{})
+	if res.Error != nil {
+		return s.fail("remove rotation", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return ErrNoRotation
+	}
+	return nil
+}
+
+// AddOverride adds an override to a component
+func (s *GormStore) AddOverride(o AnchorOverride) error {
+	if _, err := s.findComponent(o.ComponentChan); err != nil {
+		return err
+	}
+	if err := s.db.Create(&o).Error; err != nil {
+		return s.fail("create override", err)
+	}
+	log.WithFields(log.Fields{"component": o.ComponentChan, "anchor": o.SlackID, "until": o.Until}).Info("added override")
+	return nil
+}
+
+// RemoveOverride removes an override matching the component, anchor and end time,
+// returning ErrNoOverride if there is none
+func (s *GormStore) RemoveOverride(o AnchorOverride) error {
+	var overrides []AnchorOverride
+	if err := s.db.Where("component_chan = ? AND slack_id = ?", o.ComponentChan, o.SlackID).Find(&overrides).Error; err != nil {
+		return s.fail("query overrides", err)
+	}
+	for _, existing := range overrides {
+		if existing.Until.Equal(o.Until) {
+			if err := s.db.Delete(&existing).Error; err != nil {
+				return s.fail("remove override", err)
+			}
+			return nil
+		}
+	}
+	return ErrNoOverride
+}
+
+// loadSchedules loads the rotations and active overrides of the components selected
+// by db into d
+func (s *GormStore) loadSchedules(db *gorm.DB, d componentDetails) error {
+	var (
+		rotations []Rotation
+		overrides []AnchorOverride
+	)
+	if err := db.Find(&rotations).Error; err != nil {
+		return s.fail("query rotations", err)
+	}
+	if err := db.Where("until > ?", time.Now().UTC()).Order("id").Find(&overrides).Error; err != nil {
+		return s.fail("query overrides", err)
+	}
+	for _, r := range rotations {
+		d.rotations[r.ComponentChan] = r
+	}
+	for _, o := range overrides {
+		d.overrides[o.ComponentChan] = append(d.overrides[o.ComponentChan], o)
+	}
+	return nil
+}
+
+// SetRotation sets the rotation of a component, replacing any existing one
+func (s *MemStore) SetRotation(r Rotation) error {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.components[r.ComponentChan]; !ok {
+		return ErrNoComponent
+	}
+	s.rotations[r.ComponentChan] = r
+	return nil
+}
+
+// RemoveRotation removes the rotation of a component, returning ErrNoRotation if it
+// has none
+func (s *MemStore) RemoveRotation(componentChan string) error {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.rotations[componentChan]; !ok {
+		return ErrNoRotation
+	}
+	delete(s.rotations, componentChan)
+	return nil
+}
+
+// AddOverride adds an override to a component
+func (s *MemStore) AddOverride(o AnchorOverride) error {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.components[o.ComponentChan]; !ok {
+		return ErrNoComponent
+	}
+	if o.CreatedAt.IsZero() {
+		o.CreatedAt = time.Now()
+	}
+	o.ID = s.nextID
+	s.nextID++
+	s.overrides = append(s.overrides, o)
+	return nil
+}
+
+// RemoveOverride removes an override matching the component, anchor and end time,
+// returning ErrNoOverride if there is none
+func (s *MemStore) RemoveOverride(o AnchorOverride) error {
+	s.Lock()
+	defer s.Unlock()
+	for i, existing := range s.overrides {
+		if existing.ComponentChan == o.ComponentChan && existing.SlackID == o.SlackID && existing.Until.Equal(o.Until) {
+			s.overrides = append(s.overrides[:i:i], s.overrides[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNoOverride
+}
+
+// schedule returns the rotation and active overrides of a component
+func (s *MemStore) schedule(componentChan string) (*Rotation, []AnchorOverride) {
+	var (
+		rotation  *Rotation
+		overrides []AnchorOverride
+	)
+	if r, ok := s.rotations[componentChan]; ok {
+		rotation = &r
+	}
+	now := time.Now()
+	for _, o := range s.overrides {
+		if o.ComponentChan == componentChan && now.Before(o.Until) {
+			overrides = append(overrides, o)
+		}
+	}
+	return rotation, overrides
+}
+
+// ErrNoRotation is returned if a component has no rotation
+var ErrNoRotation = errors.New("Component has no rotation")
+
+// ErrNoOverride is returned if there is no matching override
+var ErrNoOverride = errors.New("No matching override")
+
+// ErrInvalidPeriod is returned if a rotation period cannot be parsed or is too short
+var ErrInvalidPeriod = errors.New("Invalid rotation period")
+
+// ErrInvalidDate is returned if a date cannot be parsed
+var ErrInvalidDate = errors.New("Invalid date")
@@ -0,0 +1,172 @@
+/*
+Tests for anchor rotations and overrides.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testStart = time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+
+func testRotation() Rotation {
+	return Rotation{ComponentChan: "C1", Members: "U1,U2,U3", Period: 7 * 24 * time.Hour, Start: testStart}
+}
+
+func TestAnchorAt(t *testing.T) {
+	week := 7 * 24 * time.Hour
+	tests := []struct {
+		name string
+		at   time.Time
+		want string
+	}{
+		{"before the start", testStart.Add(-time.Nanosecond), ""},
+		{"on the start", testStart, "U1"},
+		{"just before a handoff", testStart.Add(week - time.Nanosecond), "U1"},
+		{"on a handoff", testStart.Add(week), "U2"},
+		{"last member", testStart.Add(2*week + time.Hour), "U3"},
+		{"wrapped around", testStart.Add(3 * week), "U1"},
+		{"wrapped around twice", testStart.Add(7*week + time.Hour), "U2"},
+	}
+	for _, tt := range tests {
+		if got := testRotation().anchorAt(tt.at); got != tt.want {
+			t.Errorf("%s: anchorAt = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if got := (Rotation{Period: week, Start: testStart}).anchorAt(testStart); got != "" {
+		t.Errorf("a rotation without members has %q on duty", got)
+	}
+}
+
+func TestHandoffs(t *testing.T) {
+	week := 7 * 24 * time.Hour
+	tests := []struct {
+		name    string
+		at      time.Time
+		first   time.Time
+		anchors []string
+	}{
+		{"before the start", testStart.Add(-48 * time.Hour), testStart, []string{"U1", "U2", "U3", "U1"}},
+		{"on a handoff", testStart.Add(week), testStart.Add(week), []string{"U2", "U3", "U1", "U2"}},
+		{"between handoffs", testStart.Add(2*week + time.Hour), testStart.Add(2 * week), []string{"U3", "U1", "U2", "U3"}},
+	}
+	for _, tt := range tests {
+		times, anchors := testRotation().handoffs(tt.at, 4)
+		if len(times) != 4 || !times[0].Equal(tt.first) || !reflect.DeepEqual(anchors, tt.anchors) {
+			t.Errorf("%s: handoffs = %v %v, want %v first and %v", tt.name, times, anchors, tt.first, tt.anchors)
+			continue
+		}
+		for i := 1; i < len(times); i++ {
+			if times[i].Sub(times[i-1]) != week {
+				t.Errorf("%s: handoffs %d and %d are %v apart", tt.name, i-1, i, times[i].Sub(times[i-1]))
+			}
+		}
+	}
+}
+
+func TestCurrentAnchor(t *testing.T) {
+	r := testRotation()
+	now := testStart.Add(time.Hour)
+	override := func(id string, until time.Duration) AnchorOverride {
+		return AnchorOverride{ComponentChan: "C1", SlackID: id, Until: now.Add(until)}
+	}
+	tests := []struct {
+		name      string
+		rotation  *Rotation
+		overrides []AnchorOverride
+		now       time.Time
+		want      string
+	}{
+		{"primary", nil, nil, now, "U0"},
+		{"rotation", &r, nil, now, "U1"},
+		{"rotation not started", &r, nil, testStart.Add(-time.Hour), "U0"},
+		{"override", &r, []AnchorOverride{override("U9", time.Hour)}, now, "U9"},
+		{"override without a rotation", nil, []AnchorOverride{override("U9", time.Hour)}, now, "U9"},
+		{"latest override", &r, []AnchorOverride{override("U8", time.Hour), override("U9", time.Hour)}, now, "U9"},
+		{"latest override expired", &r, []AnchorOverride{override("U8", time.Hour), override("U9", -time.Minute)}, now, "U8"},
+		{"override ends", &r, []AnchorOverride{override("U9", 0)}, now, "U1"},
+	}
+	for _, tt := range tests {
+		if got := currentAnchor("U0", tt.rotation, tt.overrides, tt.now); got != tt.want {
+			t.Errorf("%s: currentAnchor = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParsePeriod(t *testing.T) {
+	tests := []struct {
+		period string
+		want   time.Duration
+		err    error
+	}{
+		{"1w", 7 * 24 * time.Hour, nil},
+		{"14d", 14 * 24 * time.Hour, nil},
+		{"12h", 12 * time.Hour, nil},
+		{"90m", 90 * time.Minute, nil},
+		{"30m", 0, ErrInvalidPeriod},
+		{"0d", 0, ErrInvalidPeriod},
+		{"-1w", 0, ErrInvalidPeriod},
+		{"1.5d", 0, ErrInvalidPeriod},
+		{"w", 0, ErrInvalidPeriod},
+		{"weekly", 0, ErrInvalidPeriod},
+		{"", 0, ErrInvalidPeriod},
+	}
+	for _, tt := range tests {
+		if got, err := parsePeriod(tt.period); got != tt.want || err != tt.err {
+			t.Errorf("parsePeriod(%q) = %v, %v, want %v, %v", tt.period, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		words []string
+		want  time.Time
+		used  int
+		err   error
+	}{
+		{[]string{"2024-03-04", "09:30", "U1"}, time.Date(2024, 3, 4, 9, 30, 0, 0, time.UTC), 2, nil},
+		{[]string{"2024-03-04", "U1"}, time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC), 1, nil},
+		{[]string{"2024-03-04"}, time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC), 1, nil},
+		{[]string{"2024-02-30"}, time.Time{}, 0, ErrInvalidDate},
+		{[]string{"tomorrow", "09:30"}, time.Time{}, 0, ErrInvalidDate},
+		{nil, time.Time{}, 0, ErrInvalidDate},
+	}
+	for _, tt := range tests {
+		got, used, err := parseDate(tt.words)
+		if !got.Equal(tt.want) || used != tt.used || err != tt.err {
+			t.Errorf("parseDate(%q) = %v, %d, %v, want %v, %d, %v", tt.words, got, used, err, tt.want, tt.used, tt.err)
+		}
+	}
+}
+
+func TestRotationEntry(t *testing.T) {
+	est := time.FixedZone("EST", -5*60*60)
+	for _, r := range []Rotation{
+		testRotation(),
+		{ComponentChan: "C1", Members: "U1", Period: 90 * time.Minute, Start: testStart.In(est)},
+		{ComponentChan: "C1", Members: "U1, U2,  U3", Period: 24 * time.Hour, Start: testStart},
+	} {
+		got := entryRotation("C1", rotationEntry(&r))
+		if got == nil {
+			t.Errorf("entryRotation(rotationEntry(%+v)) = nil", r)
+			continue
+		}
+		if !reflect.DeepEqual(got.memberList(), r.memberList()) || got.Period != r.Period || !got.Start.Equal(r.Start) || got.ComponentChan != "C1" {
+			t.Errorf("entryRotation(rotationEntry(%+v)) = %+v", r, *got)
+		}
+	}
+	if got := rotationEntry(nil); got != "" {
+		t.Errorf("rotationEntry(nil) = %q", got)
+	}
+	for _, v := range []string{"", "U1 3600", "U1 hour 2024-03-04T09:00:00Z", "U1 3600 monday"} {
+		if got := entryRotation("C1", v); got != nil {
+			t.Errorf("entryRotation(%q) = %+v, want nil", v, *got)
+		}
+	}
+}
@@ -19,10 +19,10 @@ import (
 
 // getBotChannel gets a userID from a username provided as a string. This is easy to provide as a startup option or env variable.
 // For further consideration - can this be done using ONLY a token?
-func getBotID(botName string, sc *slack.Client) (botID string) {
+func getBotID(botName string, sc *slack.Client) (botID string, err error) {
 	users, err := sc.GetUsers()
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
 	for _, user := range users {
 		if user.Name == botName {
@@ -31,15 +31,14 @@ func getBotID(botName string, sc *slack.Client) (botID string) {
 			return
 		}
 	}
-	log.Fatal("Could not find a userID for the botID provided")
-	return
+	return "", ErrNoBotUser
 }
 
 // getBotChannel gets a chanID from a channel name provided as a string. This is easy to provide as a startup option or env variable.
-func getBotChannel(chanName string, sc *slack.Client) (chanID string) {
+func getBotChannel(chanName string, sc *slack.Client) (chanID string, err error) {
 	channels, err := sc.GetChannels(true)
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
 	for _, channel := range channels {
 		if channel.Name == chanName {
@@ -48,8 +47,7 @@ func getBotChannel(chanName string, sc *slack.Client) (chanID string) {
 			return
 		}
 	}
-	log.Fatal("Could not find a channelID for the channel name provided")
-	return
+	return "", ErrNoBotChannel
 }
 
 // Print messages to slack. Accepts response struct and returns any errors on the print
@@ -69,6 +67,14 @@ func usrFormat(u string) string {
 	return fmt.Sprintf("<@%s>", u)
 }
 
+// formats an anchor, which may be a user or a user group, so they get tagged in slack
+func anchorFormat(a string) string {
+	if isUserGroup(a) {
+		return fmt.Sprintf("<!subteam^%s>", a)
+	}
+	return usrFormat(a)
+}
+
 // formats a channel ID to allow channel linking update to slack
 func chanFormat(c string) string {
 	return fmt.Sprintf("<#%s>", c)
@@ -85,6 +91,23 @@ func usrTrim(u string) string {
 	return strings.Trim(u, "<@>")
 }
 
+// user groups come from slack in format <!subteam^S0123ABCD|@group> or <!subteam^S0123ABCD>
+const subteamPrefix = "<!subteam^"
+
+// trims an anchor, which may be a user or a user group, to just the ID
+func anchorTrim(a string) string {
+	if strings.HasPrefix(a, subteamPrefix) {
+		s := strings.TrimSuffix(strings.TrimPrefix(a, subteamPrefix), ">")
+		return strings.Split(s, "|")[0]
+	}
+	return usrTrim(a)
+}
+
+// user group IDs start with S, user IDs with U or W
+func isUserGroup(id string) bool {
+	return strings.HasPrefix(id, "S")
+}
+
 // urls come from slack in format <https://google.com|Google>
 func urlTrim(url string) string {
 	s := strings.Trim(url, "<>")
@@ -100,12 +123,21 @@ func getChanName(id string) (string, error) {
 		if err.Error() == "channel_not_found" {
 			return "", ErrNoChannel
 		}
-		log.Panic(err)
+		return "", err
 	}
 	return channel.Name, nil
 }
 
+// validateAnchorName checks that an anchor is a slack user or an enabled user group
 func validateAnchorName(n string) bool {
+	if isUserGroup(n) {
+		_, err := getUserGroup(n)
+		return err == nil
+	}
+	return validateUserName(n)
+}
+
+func validateUserName(n string) bool {
 	_, err := sc.GetUserInfo(n)
 	if err != nil {
 		return false
@@ -114,8 +146,48 @@ func validateAnchorName(n string) bool {
 
 }
 
+// getUserGroup looks up an enabled user group by ID
+func getUserGroup(id string) (slack.UserGroup, error) {
+	groups, err := sc.GetUserGroups()
+	if err != nil {
+		log.WithField("id", id).Error("API call to get user groups failed")
+		return slack.UserGroup{}, err
+	}
+	for _, g := range groups {
+		if g.ID == id && g.DateDelete == 0 {
+			return g, nil
+		}
+	}
+	return slack.UserGroup{}, ErrNoUserGroup
+}
+
+// anchorMembers returns the users behind an anchor - the members of a user group,
+// or just the anchor if it is a user
+func anchorMembers(anchor string) ([]string, error) {
+	if !isUserGroup(anchor) {
+		return []string{anchor}, nil
+	}
+	return sc.GetUserGroupMembers(anchor)
+}
+
+// postMessage posts r.message with attachments, which slackPrint can't send over RTM
+func postMessage(r response, attachments ...slack.Attachment) error {
+	if r.isEphemeral {
+		_, err := postEphemeral(r.channel, r.user, r.message, attachments...)
+		return err
+	}
+	_, _, err := sc.PostMessage(
+		r.channel,
+		slack.MsgOptionText(r.message, false),
+		slack.MsgOptionAttachments(attachments...),
+		slack.MsgOptionAsUser(true),
+		slack.MsgOptionTS(r.threadTS),
+	)
+	return err
+}
+
 // Cleans up Ephemeral message posting, see issue: https://github.com/nlopes/slack/issues/191
-func postEphemeral(channel, user, text string) (string, error) {
+func postEphemeral(channel, user, text string, attachments ...slack.Attachment) (string, error) {
 	params := slack.PostMessageParameters{
 		AsUser: true,
 	}
@@ -123,9 +195,34 @@ func postEphemeral(channel, user, text string) (string, error) {
 		channel,
 		user,
 		slack.MsgOptionText(text, params.EscapeText),
+		slack.MsgOptionAttachments(attachments...),
 		slack.MsgOptionPostMessageParameters(params),
 	)
 }
 
+// postDM sends a direct message from the bot to a user
+func postDM(user, text string, attachments ...slack.Attachment) error {
+	_, _, channel, err := sc.OpenIMChannel(user)
+	if err != nil {
+		return err
+	}
+	_, _, err = sc.PostMessage(
+		channel,
+		slack.MsgOptionText(text, false),
+		slack.MsgOptionAttachments(attachments...),
+		slack.MsgOptionAsUser(true),
+	)
+	return err
+}
+
 // ErrNoChannel is returned if there is no channel in slack with this name
 var ErrNoChannel = errors.New("No channel exists in slack with this name")
+
+// ErrNoUserGroup is returned if there is no enabled user group in slack with this ID
+var ErrNoUserGroup = errors.New("No user group exists in slack with this ID")
+
+// ErrNoBotUser is returned if there is no slack user with the configured bot name
+var ErrNoBotUser = errors.New("Could not find a userID for the bot name provided")
+
+// ErrNoBotChannel is returned if there is no slack channel with the configured bot channel name
+var ErrNoBotChannel = errors.New("Could not find a channelID for the channel name provided")
@@ -0,0 +1,171 @@
+/*
+Tests for the slack helpers, and a stand-in for the slack web API for tests of
+commands which post to slack.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nlopes/slack"
+)
+
+// slackStub answers the slack API calls the bot makes and keeps the messages posted
+// to it. Every user and channel exists, and the direct message channel of a user is D
+// and their ID; user groups are the ones it is given
+type slackStub struct {
+	*httptest.Server
+	sync.Mutex
+	groups   map[string][]string          // members of each user group, by ID
+	profiles map[string]slack.UserProfile // profiles of the users, by ID
+	dnd      map[string]slack.DNDStatus   // do not disturb of the users, by ID
+	posted   []slackPost
+}
+
+// slackPost is a message posted to the slack stub
+type slackPost struct {
+	channel string
+	text    string
+}
+
+// newSlackStub starts a slack stub and points sc and rtm at it. Messages only reach
+// it when posted ephemerally, as rtm never connects
+func newSlackStub(groups map[string][]string) *slackStub {
+	s := &slackStub{groups: groups}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	sc = slack.New("xoxb-test", slack.OptionAPIURL(s.URL+"/"))
+	rtm = sc.NewRTM()
+	return s
+}
+
+func (s *slackStub) serve(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	s.Lock()
+	defer s.Unlock()
+	resp := map[string]interface{}{"ok": true}
+	switch path.Base(r.URL.Path) {
+	case "chat.postEphemeral", "chat.postMessage":
+		s.posted = append(s.posted, slackPost{channel: r.Form.Get("channel"), text: r.Form.Get("text")})
+		resp["channel"], resp["ts"], resp["message_ts"] = r.Form.Get("channel"), "1", "1"
+	case "im.open":
+		resp["channel"] = map[string]string{"id": "D" + r.Form.Get("user")}
+	case "usergroups.list":
+		var groups []slack.UserGroup
+		for id := range s.groups {
+			groups = append(groups, slack.UserGroup{ID: id, Handle: "group-" + id})
+		}
+		resp["usergroups"] = groups
+	case "usergroups.users.list":
+		members, ok := s.groups[r.Form.Get("usergroup")]
+		if !ok {
+			resp = map[string]interface{}{"ok": false, "error": "no_such_subteam"}
+		}
+		resp["users"] = members
+	case "users.info":
+		id := r.Form.Get("user")
+		resp["user"] = slack.User{ID: id, Name: "user-" + id, Profile: s.profiles[id]}
+	case "dnd.info":
+		json.NewEncoder(w).Encode(struct {
+			slack.DNDStatus
+			OK bool `json:"ok"`
+		}{s.dnd[r.Form.Get("user")], true})
+		return
+	case "channels.info", "conversations.info":
+		id := r.Form.Get("channel")
+		resp["channel"] = map[string]string{"id": id, "name": "chan-" + id}
+	default:
+		resp = map[string]interface{}{"ok": false, "error": "unknown_method"}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// messages returns the text of the messages posted since it or posts was last called
+func (s *slackStub) messages() (texts []string) {
+	for _, p := range s.posts() {
+		texts = append(texts, p.text)
+	}
+	return texts
+}
+
+// posts returns the messages posted since it or messages was last called
+func (s *slackStub) posts() []slackPost {
+	s.Lock()
+	defer s.Unlock()
+	posted := s.posted
+	s.posted = nil
+	return posted
+}
+
+func TestAnchorTrim(t *testing.T) {
+	tests := []struct {
+		anchor string
+		id     string
+		format string
+	}{
+		{"<@U1>", "U1", "<@U1>"},
+		{"<@W1>", "W1", "<@W1>"},
+		{"<!subteam^S1|@kafka-anchors>", "S1", "<!subteam^S1>"},
+		{"<!subteam^S1>", "S1", "<!subteam^S1>"},
+	}
+	for _, tt := range tests {
+		id := anchorTrim(tt.anchor)
+		if id != tt.id || anchorFormat(id) != tt.format {
+			t.Errorf("anchorTrim(%q) = %q, formatted %q, want %q, %q", tt.anchor, id, anchorFormat(id), tt.id, tt.format)
+		}
+	}
+}
+
+func TestUserGroupAnchor(t *testing.T) {
+	newTestCache(t, map[string][]string{"kafka": {"C1"}})
+	stub := newSlackStub(map[string][]string{"S1": {"U5", "U6"}, "S2": nil})
+	defer stub.Close()
+
+	for id, want := range map[string]bool{"U1": true, "S1": true, "S2": true, "S9": false} {
+		if got := validateAnchorName(id); got != want {
+			t.Errorf("validateAnchorName(%q) = %v, want %v", id, got, want)
+		}
+	}
+
+	// a group is confirmed as the anchor with its mention, and an unknown one refused
+	run := func(text string) []string {
+		handleCommand(&slack.MessageEvent{Msg: slack.Msg{User: "UC1", Channel: "D1", Text: text}}, strings.Fields(text))
+		return stub.messages()
+	}
+	want := "This will change the anchor of <#C1> from <@UC1> to <!subteam^S1> for the tags: kafka"
+	if got := run("<@B> set <#C1|c1> anchor <!subteam^S1|@kafka-anchors>"); !reflect.DeepEqual(got, []string{want}) {
+		t.Errorf("setting a group anchor said %q, want %q", got, want)
+	}
+	if got := run("<@B> set <#C1|c1> anchor <!subteam^S9|@gone>"); !reflect.DeepEqual(got, []string{invalidAnchor}) {
+		t.Errorf("setting an unknown group said %q", got)
+	}
+
+	if err := store.ChangeAnchor("C1", "S1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddComponentAnchor(ComponentAnchor{ComponentChan: "C1", SlackID: "S2", Role: anchorBackup}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddComponentAnchor(ComponentAnchor{ComponentChan: "C1", SlackID: "U7", Role: anchorBackup}); err != nil {
+		t.Fatal(err)
+	}
+	cache.Load()
+	if tags := cache.Find("kafka"); len(tags) != 1 || anchorsFmt(tags[0].component()) != "*anchor:* <!subteam^S1>, *backup:* <!subteam^S2>, <@U7>" {
+		t.Errorf("the cache has %+v", tags)
+	}
+
+	// who expands the groups to their members
+	want = "Anchoring <#C1>:\n*anchor:* <!subteam^S1> (<@U5>, <@U6>)\n*backup:* <!subteam^S2> (_no members_), <@U7>"
+	if got := run("<@B> who <#C1|c1>"); !reflect.DeepEqual(got, []string{want}) {
+		t.Errorf("who said %q, want %q", got, want)
+	}
+}
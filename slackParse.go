@@ -20,11 +20,12 @@ package main
 import (
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/nlopes/slack"
 	log "github.com/sirupsen/logrus"
-	lv "github.com/texttheater/golang-levenshtein/levenshtein"
 )
 
 type response struct {
@@ -42,17 +43,58 @@ var (
 	minWordLength    = 4
 )
 
+// Command settings, see config.go
+var (
+	undoWindow  = time.Hour
+	admins      []string
+	proposalTTL = 72 * time.Hour
+)
+
 // regex definitions
 
 var (
-	regHelp     = regexp.MustCompile(`^(?i)help[\?]?$`)
-	regTags     = regexp.MustCompile(`^(?i)tag[s]?[\:]?$`)
-	regAdd      = regexp.MustCompile(`(?i)add$`)
-	regAnchor   = regexp.MustCompile(`(?i)anchor$`)
-	regSet      = regexp.MustCompile(`(?i)set$`)
-	regDrop     = regexp.MustCompile(`(?i)drop$`)
-	regPlaybook = regexp.MustCompile(`(?i)playbook$`)
-	weblink     = regexp.MustCompile(`^<http.+>$`) // slack doesn't handle printing <link>
+	regHelp      = regexp.MustCompile(`^(?i)help[\?]?$`)
+	regTags      = regexp.MustCompile(`^(?i)tag[s]?[\:]?$`)
+	regAdd       = regexp.MustCompile(`(?i)add$`)
+	regComponent = regexp.MustCompile(`(?i)component$`)
+	regSupport   = regexp.MustCompile(`(?i)support$`)
+	regAnchor    = regexp.MustCompile(`(?i)anchor$`)
+	regSet       = regexp.MustCompile(`(?i)set$`)
+	regDrop      = regexp.MustCompile(`(?i)drop$`)
+	regUntag     = regexp.MustCompile(`(?i)untag$`)
+	regPlaybook  = regexp.MustCompile(`(?i)playbook$`)
+	regMigrate   = regexp.MustCompile(`(?i)migrate$`)
+	regStatus    = regexp.MustCompile(`(?i)status$`)
+	regUp        = regexp.MustCompile(`(?i)up$`)
+	regHistory   = regexp.MustCompile(`(?i)history$`)
+	regUndo      = regexp.MustCompile(`(?i)undo$`)
+	regRevert    = regexp.MustCompile(`(?i)revert$`)
+	regGrant     = regexp.MustCompile(`(?i)grant$`)
+	regRevoke    = regexp.MustCompile(`(?i)revoke$`)
+	regRoles     = regexp.MustCompile(`(?i)roles$`)
+	regAdmin     = regexp.MustCompile(`(?i)admin$`)
+	regMaintain  = regexp.MustCompile(`(?i)maintainer$`)
+	regRemove    = regexp.MustCompile(`(?i)remove$`)
+	regRole      = regexp.MustCompile(`(?i)role$`)
+	regWho       = regexp.MustCompile(`(?i)who$`)
+	regRotation  = regexp.MustCompile(`(?i)rotation$`)
+	regOverride  = regexp.MustCompile(`(?i)override$`)
+	regEvery     = regexp.MustCompile(`(?i)every$`)
+	regFrom      = regexp.MustCompile(`(?i)from$`)
+	regUntil     = regexp.MustCompile(`(?i)until$`)
+	regOff       = regexp.MustCompile(`(?i)off$`)
+	regAway      = regexp.MustCompile(`^(?i)away$`)
+	regBack      = regexp.MustCompile(`^(?i)back$`)
+	regAlias     = regexp.MustCompile(`(?i)alias$`)
+	regUnalias   = regexp.MustCompile(`(?i)unalias$`)
+	regName      = regexp.MustCompile(`(?i)name$`)
+	regDescribe  = regexp.MustCompile(`(?i)description$`)
+	regArea      = regexp.MustCompile(`(?i)area$`)
+	regLink      = regexp.MustCompile(`(?i)link$`)
+	regNone      = regexp.MustCompile(`^(?i)none$`)
+	regFor       = regexp.MustCompile(`^(?i)for$`)
+	regTitle     = regexp.MustCompile(`^["“”]([^"“”]+)["“”]`) // slack may send curly quotes
+	weblink      = regexp.MustCompile(`^<http.+>$`)           // slack doesn't handle printing <link>
 
 )
 
@@ -114,14 +156,28 @@ func handleHelp(ev *slack.MessageEvent, words []string) error {
 	switch {
 	case len(words) == 1:
 		postHelp(ev, baseHelp)
-	case len(words) > 1 && regTags.MatchString(words[1]):
+	case len(words) > 1 && (regTags.MatchString(words[1]) || regAlias.MatchString(words[1])):
 		postHelp(ev, tagsHelp)
 	case len(words) > 1 && regAdd.MatchString(words[1]):
 		postHelp(ev, addHelp)
 	case len(words) > 1 && regDrop.MatchString(words[1]):
 		postHelp(ev, dropHelp)
+	case len(words) > 1 && regUntag.MatchString(words[1]):
+		postHelp(ev, untagHelp)
 	case len(words) > 1 && (regAnchor.MatchString(words[1]) || regSet.MatchString(words[1])):
 		postHelp(ev, setHelp)
+	case len(words) > 1 && regMigrate.MatchString(words[1]):
+		postHelp(ev, migrateHelp)
+	case len(words) > 1 && regHistory.MatchString(words[1]):
+		postHelp(ev, historyHelp)
+	case len(words) > 1 && (regUndo.MatchString(words[1]) || regRevert.MatchString(words[1])):
+		postHelp(ev, undoHelp)
+	case len(words) > 1 && (regGrant.MatchString(words[1]) || regRevoke.MatchString(words[1]) || regRoles.MatchString(words[1])):
+		postHelp(ev, rolesHelp)
+	case len(words) > 1 && regPlaybook.MatchString(words[1]):
+		postHelp(ev, playbookHelp)
+	case len(words) > 1 && (regRotation.MatchString(words[1]) || regOverride.MatchString(words[1]) || regAway.MatchString(words[1])):
+		postHelp(ev, rotationHelp)
 	default:
 		postHelp(ev, baseHelp)
 	}
@@ -131,106 +187,45 @@ func handleHelp(ev *slack.MessageEvent, words []string) error {
 
 // handlesKeywords passed via the "tag" option
 func handleKeywords(ev *slack.MessageEvent, words []string) error {
-	var (
-		responses  []string
-		foundMatch bool
-	)
 	r := response{user: ev.User, channel: ev.Channel, isEphemeral: false, isIM: false}
 	r.setResponseContext(ev)
 
-	responses, foundMatch = tagMatch(words)
-	if foundMatch {
-		r.message = strings.Join(responses[:], "\n")
-	} else {
-		r.message = noRelevantTag
+	matches := tagMatch(words)
+	switch {
+	case len(matches) > maxComponentMatches:
+		r.message = matchesFmt(matches[:maxComponentMatches])
+		return postMessage(r, moreButton(strings.Join(words[1:], " "), maxComponentMatches, len(matches)-maxComponentMatches))
+	case len(matches) != 0:
+		r.message = matchesFmt(matches)
+	default:
+		// no tag for it, but a playbook may cover it
+		if responses, found := contentMatch(words[1:]); found {
+			r.message = strings.Join(responses, "\n")
+		} else {
+			r.message = noRelevantTag
+		}
 	}
 	slackPrint(r)
 	return nil
 
 }
 
-func tagMatch(words []string) (responses []string, match bool) {
-	tagsCache := cache.GetNames()
-	incomingTags := make(chan TagInfo)
-
-	words = words[1:]
-	complete := make(chan bool)
-	defer close(complete)
-	c1 := 0
-	for _, word := range words {
-		go lvSearch(word, tagsCache, incomingTags, complete)
-		c1++
-
-	}
-
-	c2 := 0
-	for i := 0; i < len(words)-1; i++ {
-		word := strings.Join(words[i:i+2], " ")
-		go lvSearch(word, tagsCache, incomingTags, complete)
-		c2++
-	}
-
-	c3 := 0
-	for i := 0; i < len(words)-2; i++ {
-		word := strings.Join(words[i:i+3], " ")
-		go lvSearch(word, tagsCache, incomingTags, complete)
-		c3++
-	}
-
-	c := c1 + c2 + c3
-	go func(c int, complete chan bool, incomingTags chan TagInfo) {
-		counter := 0
-		for range complete {
-			counter++
-			if counter == c {
-				close(incomingTags)
-				break
-			}
-		}
-	}(c, complete, incomingTags)
-	for tag := range incomingTags {
-		match = true
-		responses = append(responses, tagFmt(tag))
-	}
-	return responses, match
+// tagMatch returns the components whose tags the words after the first match, best first
+func tagMatch(words []string) []ComponentMatch {
+	return rankMatches(matchTags(queryWords(strings.Join(words[1:], " ")), matcher, cache), cache)
 }
 
-func lvSearch(word string, tagsCache []string, found chan TagInfo, complete chan bool) {
-	if len(word) < minWordLength {
-		if cache.ContainsTag(word) {
-			log.WithField("tag", word).Debug("found exact match in cache")
-			for _, tag := range cache.Find(word) {
-				found <- tag
-			}
-		}
-	} else {
-		if cache.ContainsTag(word) {
-			log.WithField("tag", word).Debug("found exact match in cache")
-			for _, tag := range cache.Find(word) {
-				found <- tag
-
-			}
-		} else {
-			for _, t := range tagsCache {
-				if len(t) < minWordLength {
-					continue
-				}
-				dist := lv.RatioForStrings([]rune(word), []rune(t), lv.DefaultOptions)
-				log.WithFields(log.Fields{"s1": t, "s2": word, "dist": dist}).Debug("Levenshtein ratio")
-				if dist == 1 {
-					log.WithField("tag", t).Error("Using lv dist for an exact match - this should not have occured")
-					break // Due to exact match, the response should be populated.
-				} else if dist >= matchDistPercent {
-					log.WithField("tag", t).Debug("Found fuzzy match")
-					for _, tag := range cache.Find(t) {
-						found <- tag
-					}
-				}
-
-			}
+// matchTags returns the tags every word, pair and triple of words may be about
+func matchTags(words []string, m Matcher, tags TagSet) (candidates []Candidate) {
+	for n := 1; n <= 3; n++ {
+		for i := 0; i+n <= len(words); i++ {
+			ngram := strings.Join(words[i:i+n], " ")
+			found := m.Match(ngram, tags)
+			log.WithFields(log.Fields{"words": ngram, "candidates": len(found)}).Debug("Matched words")
+			candidates = append(candidates, found...)
 		}
 	}
-	complete <- true
+	return candidates
 }
 
 func handleAnchor(ev *slack.MessageEvent, words []string) error {
@@ -238,17 +233,9 @@ func handleAnchor(ev *slack.MessageEvent, words []string) error {
 	r.setResponseContext(ev)
 
 	word := words[1]
-	component, err := GetAnchor(chanTrim(word))
+	component, err := store.GetAnchor(chanTrim(word))
 	if err != nil {
-		if err == ErrNoComponent {
-			r.message = noComponentInDB
-			slackPrint(r)
-		} else if err == ErrNoChannel {
-			r.message = noChannelInSlack
-			slackPrint(r)
-		} else {
-			log.Panic(err)
-		}
+		r.message = errMessage(err)
 	} else {
 		r.message = componentFmt(component)
 	}
@@ -270,35 +257,91 @@ func handleCommand(ev *slack.MessageEvent, words []string) error {
 			postHelp(ev, tagsHelp)
 			return nil
 		} // TODO: clean this up
-		setTags(ev.Text, words, r)
+		// tags from anyone but the owners are proposed to the anchor instead
+		ok, err := isOwner(r.user, chanTrim(words[2]))
+		switch {
+		case err != nil:
+			r.message = errMessage(err)
+			slackPrint(r)
+		case ok:
+			setTags(ev.Text, words, r)
+		default:
+			proposeTags(ev.Text, words, r)
+		}
 
 	case regDrop.MatchString(words[1]):
 		if len(words) < 3 {
 			postHelp(ev, dropHelp)
 		}
 		dropTags(ev.Text, words, r)
-		/*	case regAdd.MatchString(words[1]):
-			switch {
-			case len(words) < 5:
-				postHelp(ev, addHelp)
-			case words[2] == "component":
-				postHelp(ev, addHelp) // TODO finish building matrix/DB to add component
-			case words[4] == "as":
-				postHelp(ev, addHelp) // TODO finish building matrix/DB to add anchor
-			} */
+	case regUnalias.MatchString(words[1]): // @bot unalias alias1, alias2
+		if len(words) < 3 {
+			postHelp(ev, tagsHelp)
+			return nil
+		}
+		removeAliases(ev.Text, r)
+	case regAlias.MatchString(words[1]): // @bot alias tag alias1, alias2
+		if len(words) < 4 {
+			postHelp(ev, tagsHelp)
+			return nil
+		}
+		addAliases(ev.Text, r)
+	case regUntag.MatchString(words[1]): // @bot untag #channel tag1, tag2
+		if len(words) < 4 {
+			postHelp(ev, untagHelp)
+			return nil
+		}
+		if authorize(r, ev.Text, chanTrim(words[2])) {
+			untagTags(ev.Text, words, r)
+		}
+	case regAdd.MatchString(words[1]): // @bot add component #channel support #channel anchor @anchor playbook url
+		if len(words) < 10 || !regComponent.MatchString(words[2]) {
+			postHelp(ev, addHelp)
+			return nil
+		}
+		if authorize(r, ev.Text) {
+			addComponent(words, r)
+		}
 	case regHelp.MatchString(words[1]):
 		handleHelp(ev, words[1:])
-	case regSet.MatchString(words[1]): // @bot set #channel {anchor, playbook} {@anchor, url}
+	case regSet.MatchString(words[1]): // @bot set #channel {anchor [add, remove], rotation, playbook, name, description, area, link [remove]} {@anchor [role role], @anchor... every period, url, text, label [url]}
 		if len(words) < 5 {
 			postHelp(ev, setHelp)
 			return nil
 		}
 		switch {
 		case regAnchor.MatchString(words[3]):
-			setAnchor(words, r)
+			if !authorize(r, ev.Text, chanTrim(words[2])) {
+				return nil
+			}
+			switch {
+			case len(words) > 5 && regAdd.MatchString(words[4]):
+				addAnchor(words, r)
+			case len(words) > 5 && regRemove.MatchString(words[4]):
+				removeAnchor(words, r)
+			default:
+				setAnchor(words, r)
+			}
+
+		case regRotation.MatchString(words[3]):
+			if authorize(r, ev.Text, chanTrim(words[2])) {
+				setRotation(words, r)
+			}
 
 		case regPlaybook.MatchString(words[3]):
-			setPlaybook(words, r)
+			if authorize(r, ev.Text, chanTrim(words[2])) {
+				setPlaybook(words, r)
+			}
+
+		case regName.MatchString(words[3]), regDescribe.MatchString(words[3]), regArea.MatchString(words[3]):
+			if authorize(r, ev.Text, chanTrim(words[2])) {
+				setInfo(words, r)
+			}
+
+		case regLink.MatchString(words[3]):
+			if authorize(r, ev.Text, chanTrim(words[2])) {
+				setLink(words, r)
+			}
 
 		default:
 			postHelp(ev, setHelp)
@@ -307,6 +350,114 @@ func handleCommand(ev *slack.MessageEvent, words []string) error {
 	case regAnchor.MatchString(words[1]):
 		handleAnchor(ev, words[1:])
 
+	case regPlaybook.MatchString(words[1]): // @bot playbook [add, remove] #channel ["title"] [url] [for tag1, tag2]
+		switch {
+		case len(words) == 3:
+			showPlaybooks(chanTrim(words[2]), r)
+		case len(words) > 5 && regAdd.MatchString(words[2]):
+			if authorize(r, ev.Text, chanTrim(words[3])) {
+				addPlaybook(ev.Text, words, r)
+			}
+		case len(words) > 4 && regRemove.MatchString(words[2]):
+			if authorize(r, ev.Text, chanTrim(words[3])) {
+				removePlaybook(ev.Text, words, r)
+			}
+		default:
+			postHelp(ev, playbookHelp)
+		}
+
+	case regWho.MatchString(words[1]): // @bot who #channel
+		if len(words) < 3 {
+			postHelp(ev, baseHelp)
+			return nil
+		}
+		showWho(chanTrim(words[2]), r)
+
+	case regRotation.MatchString(words[1]): // @bot rotation #channel
+		if len(words) < 3 {
+			postHelp(ev, rotationHelp)
+			return nil
+		}
+		showRotation(chanTrim(words[2]), r)
+
+	case regAway.MatchString(words[1]): // @bot away until date [time]
+		if len(words) < 4 || !regUntil.MatchString(words[2]) {
+			postHelp(ev, rotationHelp)
+			return nil
+		}
+		setAway(words, r)
+
+	case regBack.MatchString(words[1]): // @bot back
+		setBack(words, r)
+
+	case regOverride.MatchString(words[1]): // @bot override #channel @anchor until date [time]
+		if len(words) < 6 || !regUntil.MatchString(words[4]) {
+			postHelp(ev, rotationHelp)
+			return nil
+		}
+		if authorize(r, ev.Text, chanTrim(words[2])) {
+			setOverride(words, r)
+		}
+
+	case regHistory.MatchString(words[1]): // @bot history {#channel, tag [tag]}
+		switch {
+		case len(words) == 3:
+			showHistory(AuditEntry{ComponentChan: chanTrim(words[2])}, r)
+		case len(words) > 3 && regTags.MatchString(words[2]):
+			showHistory(AuditEntry{Tag: cache.Canonical(cleanTag(strings.Join(words[3:], " ")))}, r)
+		default:
+			postHelp(ev, historyHelp)
+		}
+
+	case regUndo.MatchString(words[1]): // @bot undo
+		undoChange(ev.Text, r)
+
+	case regRevert.MatchString(words[1]): // @bot revert [change-id]
+		if len(words) < 3 {
+			postHelp(ev, undoHelp)
+			return nil
+		}
+		if authorize(r, ev.Text) {
+			revertByID(ev.Text, words, r)
+		}
+
+	case regGrant.MatchString(words[1]), regRevoke.MatchString(words[1]): // @bot {grant, revoke} @user {admin, maintainer #channel}
+		role, ok := parseRole(words)
+		if !ok {
+			postHelp(ev, rolesHelp)
+			return nil
+		}
+		var chans []string // admins are managed by admins, maintainers by the component's owners
+		if role.ComponentChan != "" {
+			chans = append(chans, role.ComponentChan)
+		}
+		if authorize(r, ev.Text, chans...) {
+			changeRole(ev.Text, role, regGrant.MatchString(words[1]), r)
+		}
+
+	case regRoles.MatchString(words[1]): // @bot roles [#channel]
+		if len(words) > 2 {
+			showRoles(chanTrim(words[2]), r)
+		} else {
+			showRoles("", r)
+		}
+
+	case regMigrate.MatchString(words[1]): // @bot migrate {status, up}
+		if len(words) < 3 {
+			postHelp(ev, migrateHelp)
+			return nil
+		}
+		switch {
+		case regStatus.MatchString(words[2]):
+			migrateStatus(r)
+		case regUp.MatchString(words[2]):
+			if authorize(r, ev.Text) {
+				migrateUp(r)
+			}
+		default:
+			postHelp(ev, migrateHelp)
+		}
+
 	default:
 		handleKeywords(ev, words)
 
@@ -315,110 +466,1116 @@ func handleCommand(ev *slack.MessageEvent, words []string) error {
 }
 
 func setTags(text string, words []string, r response) {
-	tag := TagInfo{ComponentChan: chanTrim(words[2])}
+	change := newChange(r, text)
+	defer recordChange(change)
+	for _, message := range addTags(chanTrim(words[2]), tagCleanup(text, reqAdd), change) {
+		r.message = message
+		slackPrint(r)
+	}
+}
+
+// addTags adds tags to a component, recording them in change, and returns the
+// messages to show
+func addTags(componentChan string, tagList []string, change *Change) (messages []string) {
+	tag := TagInfo{ComponentChan: componentChan}
 	count := 0
-	tagList := tagCleanup(text, reqAdd)
 	for _, word := range tagList {
-		tag.Name = word
+		tag.Name = cache.Canonical(word)
 		if !cache.ContainsTagInfo(tag) {
+			before := tagChans(tag.Name)
 			if err := cache.Add(tag); err != nil {
-				if err == ErrNoComponent {
-					r.message = noComponentInDB
-					slackPrint(r)
-					break
-				} else if err == ErrNoChannel {
-					r.message = noChannelInSlack
-					slackPrint(r)
-					break
-				} else if err == ErrTagTooLong {
-					r.message = fmt.Sprintf(tagTooLong, tag.Name)
-					slackPrint(r)
+				if err == ErrTagTooLong {
+					messages = append(messages, fmt.Sprintf(tagTooLong, tag.Name))
 					continue
-
 				}
+				messages = append(messages, errMessage(err))
+				break
 			}
+			change.add(actionTag, tag.ComponentChan, tag.Name, before, tagChans(tag.Name))
 			count++
 		} else {
-			r.message = fmt.Sprintf(alreadyAdded, tag.Name)
-			slackPrint(r)
+			messages = append(messages, fmt.Sprintf(alreadyAdded, tag.Name))
 		}
 	}
 	if count != 0 {
-		r.message = fmt.Sprintf("Added %d tags to the component %s", count, words[2])
+		messages = append(messages, fmt.Sprintf("Added %d tags to the component %s", count, chanFormat(componentChan)))
+	}
+	return messages
+}
+
+// proposeTags sends tags from someone who does not own the component to the anchor
+// on duty for approval, or to whoever stands in for them while they are away
+func proposeTags(text string, words []string, r response) {
+	component, err := store.GetAnchor(chanTrim(words[2]))
+	if err != nil {
+		r.message = errMessage(err)
+		slackPrint(r)
+		return
+	}
+	var tagList []string
+	for _, word := range tagCleanup(text, reqAdd) {
+		word = cache.Canonical(word)
+		switch {
+		case cache.ContainsTagInfo(TagInfo{Name: word, ComponentChan: component.ComponentChan}):
+			r.message = fmt.Sprintf(alreadyAdded, word)
+			slackPrint(r)
+		case len(word) > MAX_TAG_LENGTH:
+			r.message = fmt.Sprintf(tagTooLong, word)
+			slackPrint(r)
+		default:
+			tagList = append(tagList, word)
+		}
+	}
+	if len(tagList) == 0 {
+		return
+	}
+	p := Proposal{
+		ExpiresAt:       time.Now().Add(proposalTTL),
+		ProposerSlackID: r.user,
+		Channel:         r.channel,
+		ComponentChan:   component.ComponentChan,
+		Tags:            strings.Join(tagList, ","),
+		Status:          proposalPending,
+	}
+	if err := store.AddProposal(&p); err != nil {
+		r.message = errMessage(err)
+		slackPrint(r)
+		return
+	}
+	anchor, _ := component.contact(time.Now())
+	if anchor == "" {
+		anchor = component.onDuty()
+	}
+	if err := sendProposal(p, anchor); err != nil {
+		log.WithFields(log.Fields{"proposal": p.ID, "ERROR": err}).Error("Could not send proposal to the anchor")
+		r.message = errMessage(err)
 		slackPrint(r)
+		return
 	}
+	r.message = fmt.Sprintf(proposalSent, p.ID, anchorFormat(anchor))
+	slackPrint(r)
 }
 
+// dropTags asks the user to confirm dropping the tags, listing every component which
+// would lose them
 func dropTags(text string, words []string, r response) {
+	var tagList, lines, chans []string
+	seen := make(map[string]bool)
+	for _, word := range tagCleanup(text, reqDrop) {
+		word = cache.Canonical(word)
+		if !cache.ContainsTag(word) {
+			r.message = fmt.Sprintf(noTagInDB, word)
+			slackPrint(r)
+			continue
+		}
+		tagList = append(tagList, word)
+		lines = append(lines, fmt.Sprintf("_%s_ from %s", word, chanListFmt(tagChans(word))))
+		for _, tag := range cache.Find(word) {
+			if !seen[tag.ComponentChan] {
+				seen[tag.ComponentChan] = true
+				chans = append(chans, tag.ComponentChan)
+			}
+		}
+	}
+	if len(tagList) == 0 || !authorize(r, text, chans...) {
+		return
+	}
+	r.message = "This will drop the following tags from the database:\n" + strings.Join(lines, "\n")
+	confirm(r, func() string { return doDropTags(text, tagList, r) })
+}
+
+// doDropTags drops confirmed tags and returns the message to show
+func doDropTags(text string, tagList []string, r response) string {
+	var messages []string
 	count := 0
-	tagList := tagCleanup(text, reqDrop)
+	change := newChange(r, text)
+	defer recordChange(change)
 	for _, word := range tagList {
 		if !cache.ContainsTag(word) {
-			r.message = fmt.Sprintf(noTagInDB, word)
+			messages = append(messages, fmt.Sprintf(noTagInDB, word))
+			continue
+		}
+		tags, before := cache.Find(word), tagEntry(word)
+		if err := cache.Drop(word); err != nil {
+			messages = append(messages, errMessage(err))
+			break
+		}
+		for _, tag := range tags {
+			change.add(actionDrop, tag.ComponentChan, word, before, "")
+		}
+		count++
+	}
+	if count != 0 {
+		messages = append(messages, fmt.Sprintf("Dropped %d tags from the database", count))
+	}
+	return strings.Join(messages, "\n")
+}
+
+// addAliases adds aliases to a tag, if the user owns every component of the tag
+func addAliases(text string, r response) {
+	t, aliases := aliasCleanup(text)
+	t = cache.Canonical(t)
+	if !cache.ContainsTag(t) {
+		r.message = fmt.Sprintf(noTagInDB, t)
+		slackPrint(r)
+		return
+	}
+	if !authorize(r, text, splitList(tagChans(t))...) {
+		return
+	}
+	count := 0
+	change := newChange(r, text)
+	defer recordChange(change)
+	for _, alias := range aliases {
+		if _, err := cache.AddAlias(alias, t); err != nil {
+			switch err {
+			case ErrAliasTaken:
+				r.message = fmt.Sprintf(aliasTaken, alias)
+			case ErrTagTooLong:
+				r.message = fmt.Sprintf(tagTooLong, alias)
+			default:
+				r.message = errMessage(err)
+				slackPrint(r)
+				return
+			}
 			slackPrint(r)
-		} else {
-			cache.Drop(word)
-			count++
+			continue
 		}
+		change.add(actionAlias, "", t, "", alias)
+		count++
 	}
 	if count != 0 {
-		r.message = fmt.Sprintf("Dropped %d tags from the database", count)
+		r.message = fmt.Sprintf("Added %d aliases to the tag _%s_", count, t)
 		slackPrint(r)
 	}
 }
 
-func setAnchor(words []string, r response) {
-	if !validateAnchorName(usrTrim(words[4])) {
+// removeAliases removes aliases, if the user owns every component of their tags
+func removeAliases(text string, r response) {
+	var aliases, chans []string
+	for _, alias := range tagCleanup(text, reqUnalias) {
+		alias = strings.ToLower(alias)
+		if cache.Canonical(alias) == alias {
+			r.message = fmt.Sprintf(noAliasInDB, alias)
+			slackPrint(r)
+			continue
+		}
+		aliases = append(aliases, alias)
+		chans = append(chans, splitList(tagChans(alias))...)
+	}
+	if len(aliases) == 0 || !authorize(r, text, chans...) {
+		return
+	}
+	count := 0
+	change := newChange(r, text)
+	defer recordChange(change)
+	for _, alias := range aliases {
+		t, err := cache.RemoveAlias(alias)
+		if err != nil {
+			if err == ErrNoAlias {
+				r.message = fmt.Sprintf(noAliasInDB, alias)
+				slackPrint(r)
+				continue
+			}
+			r.message = errMessage(err)
+			slackPrint(r)
+			return
+		}
+		change.add(actionUnalias, "", t, alias, "")
+		count++
+	}
+	if count != 0 {
+		r.message = fmt.Sprintf("Removed %d aliases", count)
+		slackPrint(r)
+	}
+}
+
+func untagTags(text string, words []string, r response) {
+	tag := TagInfo{ComponentChan: chanTrim(words[2])}
+	count := 0
+	change := newChange(r, text)
+	defer recordChange(change)
+	tagList := tagCleanup(text, reqUntag)
+	for _, word := range tagList {
+		tag.Name = cache.Canonical(word)
+		before := tagEntry(tag.Name)
+		if err := cache.Untag(tag); err != nil {
+			switch err {
+			case ErrNoTag:
+				r.message = fmt.Sprintf(noTagInDB, word)
+			case ErrTagNotOnComponent:
+				r.message = fmt.Sprintf(tagNotOnComponent, word, words[2])
+			default:
+				r.message = errMessage(err)
+				slackPrint(r)
+				return
+			}
+			slackPrint(r)
+			continue
+		}
+		change.add(actionUntag, tag.ComponentChan, tag.Name, before, tagChans(tag.Name))
+		count++
+	}
+	if count != 0 {
+		r.message = fmt.Sprintf("Removed %d tags from the component %s", count, words[2])
+		slackPrint(r)
+	}
+}
+
+func addComponent(words []string, r response) {
+	c := Component{ComponentChan: chanTrim(words[3])}
+	for i := 4; i+1 < len(words); i += 2 {
+		switch {
+		case regSupport.MatchString(words[i]):
+			c.SupportChan = chanTrim(words[i+1])
+		case regAnchor.MatchString(words[i]):
+			c.AnchorSlackID = anchorTrim(words[i+1])
+		case regPlaybook.MatchString(words[i]):
+			if !weblink.MatchString(words[i+1]) {
+				r.message = notWeblink
+				slackPrint(r)
+				return
+			}
+			c.Playbooks = []Playbook{{Title: defaultPlaybookTitle, URL: urlTrim(words[i+1])}}
+		}
+	}
+	if c.SupportChan == "" || c.AnchorSlackID == "" || len(c.Playbooks) == 0 {
+		r.message = missingComponentInfo
+		slackPrint(r)
+		return
+	}
+	for _, channel := range []string{c.ComponentChan, c.SupportChan} {
+		if _, err := getChanName(channel); err != nil {
+			r.message = errMessage(err)
+			slackPrint(r)
+			return
+		}
+	}
+	if !validateAnchorName(c.AnchorSlackID) {
 		r.message = invalidAnchor
 		slackPrint(r)
 		return
 	}
-	if err := ChangeAnchor(chanTrim(words[2]), usrTrim(words[4])); err != nil {
-		if err == ErrNoComponent {
-			r.message = noComponentInDB
-		} else if err == ErrNoChannel {
-			r.message = noChannelInSlack
+	if err := store.AddComponent(c); err != nil {
+		if err == ErrComponentExists {
+			r.message = componentExists
+		} else if err == ErrURLTooLong {
+			r.message = urlTooLong
 		} else {
-			log.Panic(err)
+			r.message = errMessage(err)
 		}
 		slackPrint(r)
 		return
 	}
-	r.message = fmt.Sprintf("Successfully changed anchor for %s to %s", words[2], words[4])
+	change := newChange(r, strings.Join(words, " "))
+	change.add(actionAddComponent, c.ComponentChan, "", "", componentFmt(c))
+	recordChange(change)
+	cache.Load()
+	reindexPlaybooks(c.ComponentChan)
+	r.message = fmt.Sprintf("Successfully added the component %s", words[3])
 	slackPrint(r)
 }
 
-func setPlaybook(words []string, r response) {
-	if !weblink.MatchString(words[4]) {
-		r.message = notWeblink
+// setAnchor asks the user to confirm the new anchor, listing the tags which would be
+// routed to them
+func setAnchor(words []string, r response) {
+	if !validateAnchorName(anchorTrim(words[4])) {
+		r.message = invalidAnchor
 		slackPrint(r)
 		return
 	}
-	if err := ChangePlaybook(chanTrim(words[2]), urlTrim(words[4])); err != nil {
-		if err == ErrNoComponent {
-			r.message = noComponentInDB
-		} else if err == ErrNoChannel {
-			r.message = noChannelInSlack
-		} else {
-			log.Panic(err)
-		}
+	component, err := store.GetAnchor(chanTrim(words[2]))
+	if err != nil {
+		r.message = errMessage(err)
 		slackPrint(r)
 		return
 	}
-	r.message = fmt.Sprintf("Successfully changed playbook for %s to %s", words[2], urlTrim(words[4]))
-	slackPrint(r)
+	r.message = fmt.Sprintf("This will change the anchor of %s from %s to %s for the tags: %s",
+		chanFormat(component.ComponentChan), anchorOrNone(component.AnchorSlackID), anchorFormat(anchorTrim(words[4])),
+		orNone(strings.Join(cache.ComponentTags(component.ComponentChan), ", ")))
+	confirm(r, func() string { return doSetAnchor(words, r) })
 }
 
-func (r *response) setResponseContext(ev *slack.MessageEvent) {
-	chanInfo, err := sc.GetConversationInfo(ev.Channel, false)
+// doSetAnchor changes a confirmed anchor and returns the message to show
+func doSetAnchor(words []string, r response) string {
+	component, err := store.GetAnchor(chanTrim(words[2]))
 	if err != nil {
-		log.Error(err)
+		return errMessage(err)
 	}
-	if !chanInfo.IsIM {
-		if ev.ThreadTimestamp != "" {
-			r.threadTS = ev.ThreadTimestamp
-		} else {
-			r.threadTS = ev.Timestamp
+	if err := store.ChangeAnchor(component.ComponentChan, anchorTrim(words[4])); err != nil {
+		return errMessage(err)
+	}
+	change := newChange(r, strings.Join(words, " "))
+	defer recordChange(change)
+	change.add(actionAnchor, component.ComponentChan, "", component.AnchorSlackID, anchorTrim(words[4]))
+	// the new primary anchor no longer anchors the component in another role
+	for _, a := range component.Anchors {
+		if a.SlackID == anchorTrim(words[4]) {
+			if err := store.RemoveComponentAnchor(a.ComponentChan, a.SlackID); err != nil {
+				return errMessage(err)
+			}
+			change.add(actionAnchorRemove, a.ComponentChan, "", anchorEntry(a), "")
+		}
+	}
+	cache.Load() // More than one tag will be reset - we need to reload the cache entirely
+	return fmt.Sprintf("Successfully changed anchor for %s to %s", words[2], words[4])
+}
+
+// addAnchor adds an anchor in a role other than primary, backup if none is given
+func addAnchor(words []string, r response) {
+	a := ComponentAnchor{ComponentChan: chanTrim(words[2]), SlackID: anchorTrim(words[5]), Role: anchorBackup}
+	if len(words) > 7 && regRole.MatchString(words[6]) {
+		a.Role = strings.ToLower(words[7])
+	}
+	if a.Role == anchorPrimary {
+		r.message = primaryAnchorRole
+		slackPrint(r)
+		return
+	}
+	if !validateAnchorName(a.SlackID) {
+		r.message = invalidAnchor
+		slackPrint(r)
+		return
+	}
+	component, err := store.GetAnchor(a.ComponentChan)
+	if err != nil {
+		r.message = errMessage(err)
+		slackPrint(r)
+		return
+	}
+	if component.AnchorSlackID == a.SlackID {
+		r.message = anchorExists
+		slackPrint(r)
+		return
+	}
+	if err := store.AddComponentAnchor(a); err != nil {
+		switch err {
+		case ErrAnchorExists:
+			r.message = anchorExists
+		case ErrRoleTooLong:
+			r.message = roleTooLong
+		default:
+			r.message = errMessage(err)
+		}
+		slackPrint(r)
+		return
+	}
+	change := newChange(r, strings.Join(words, " "))
+	change.add(actionAnchorAdd, a.ComponentChan, "", "", anchorEntry(a))
+	recordChange(change)
+	cache.Load()
+	r.message = fmt.Sprintf("Successfully added %s as %s anchor for %s", anchorFormat(a.SlackID), a.Role, words[2])
+	slackPrint(r)
+}
+
+func removeAnchor(words []string, r response) {
+	componentChan, user := chanTrim(words[2]), anchorTrim(words[5])
+	component, err := store.GetAnchor(componentChan)
+	if err != nil {
+		r.message = errMessage(err)
+		slackPrint(r)
+		return
+	}
+	if component.AnchorSlackID == user {
+		r.message = removePrimaryAnchor
+		slackPrint(r)
+		return
+	}
+	var a ComponentAnchor
+	for _, anchor := range component.Anchors {
+		if anchor.SlackID == user {
+			a = anchor
+		}
+	}
+	if a.SlackID == "" {
+		r.message = noAnchor
+		slackPrint(r)
+		return
+	}
+	if err := store.RemoveComponentAnchor(componentChan, user); err != nil {
+		if err == ErrNoAnchor {
+			r.message = noAnchor
+		} else {
+			r.message = errMessage(err)
+		}
+		slackPrint(r)
+		return
+	}
+	change := newChange(r, strings.Join(words, " "))
+	change.add(actionAnchorRemove, componentChan, "", anchorEntry(a), "")
+	recordChange(change)
+	cache.Load()
+	r.message = fmt.Sprintf("Successfully removed %s as %s anchor for %s", anchorFormat(user), a.Role, words[2])
+	slackPrint(r)
+}
+
+// setRotation sets the anchor rotation of a component, or removes it with "off"
+func setRotation(words []string, r response) {
+	componentChan := chanTrim(words[2])
+	component, err := store.GetAnchor(componentChan)
+	if err != nil {
+		r.message = errMessage(err)
+		slackPrint(r)
+		return
+	}
+	var rotation *Rotation
+	if regOff.MatchString(words[4]) {
+		err = store.RemoveRotation(componentChan)
+	} else {
+		if rotation, r.message = parseRotation(componentChan, words[4:]); rotation == nil {
+			slackPrint(r)
+			return
+		}
+		err = store.SetRotation(*rotation)
+	}
+	if err != nil {
+		if err == ErrNoRotation {
+			r.message = noRotation
+		} else {
+			r.message = errMessage(err)
+		}
+		slackPrint(r)
+		return
+	}
+	change := newChange(r, strings.Join(words, " "))
+	change.add(actionRotation, componentChan, "", rotationEntry(component.Rotation), rotationEntry(rotation))
+	recordChange(change)
+	cache.Load() // the anchor on duty may have changed for every tag of the component
+	if rotation == nil {
+		r.message = fmt.Sprintf("Successfully removed the rotation of %s", words[2])
+	} else {
+		r.message = fmt.Sprintf("Successfully set the rotation of %s to %s", words[2], rotationSummary(rotation))
+	}
+	slackPrint(r)
+}
+
+// parseRotation parses "@anchor... every period [from date [time]]". If the words are
+// not a valid rotation, it returns nil and the message to show instead. Without a
+// first handoff, the rotation starts at midnight UTC today
+func parseRotation(componentChan string, words []string) (*Rotation, string) {
+	var (
+		members []string
+		i       int
+	)
+	for ; i < len(words) && !regEvery.MatchString(words[i]); i++ {
+		member := anchorTrim(words[i])
+		if !validateAnchorName(member) {
+			return nil, invalidAnchor
+		}
+		members = append(members, member)
+	}
+	if len(members) == 0 || i+1 >= len(words) {
+		return nil, badRotation
+	}
+	period, err := parsePeriod(words[i+1])
+	if err != nil {
+		return nil, invalidPeriod
+	}
+	start := time.Now().UTC().Truncate(24 * time.Hour)
+	if rest := words[i+2:]; len(rest) > 0 {
+		if !regFrom.MatchString(rest[0]) {
+			return nil, badRotation
+		}
+		var n int
+		if start, n, err = parseDate(rest[1:]); err != nil || n != len(rest)-1 {
+			return nil, invalidDate
+		}
+	}
+	return &Rotation{ComponentChan: componentChan, Members: strings.Join(members, ","), Period: period, Start: start}, ""
+}
+
+// setOverride puts an anchor on duty for a component until the given time
+func setOverride(words []string, r response) {
+	o := AnchorOverride{ComponentChan: chanTrim(words[2]), SlackID: anchorTrim(words[3])}
+	if !validateAnchorName(o.SlackID) {
+		r.message = invalidAnchor
+		slackPrint(r)
+		return
+	}
+	until, n, err := parseDate(words[5:])
+	if err != nil || n != len(words)-5 {
+		r.message = invalidDate
+		slackPrint(r)
+		return
+	}
+	if !until.After(time.Now()) {
+		r.message = dateInPast
+		slackPrint(r)
+		return
+	}
+	o.Until = until
+	if err := store.AddOverride(o); err != nil {
+		r.message = errMessage(err)
+		slackPrint(r)
+		return
+	}
+	change := newChange(r, strings.Join(words, " "))
+	change.add(actionOverride, o.ComponentChan, "", "", overrideEntry(o))
+	recordChange(change)
+	cache.Load()
+	r.message = fmt.Sprintf("Successfully put %s on duty for %s until %s", anchorFormat(o.SlackID), words[2], o.Until.Format(timeFmt))
+	slackPrint(r)
+}
+
+// setAway marks the user away until the given time
+func setAway(words []string, r response) {
+	until, n, err := parseDate(words[3:])
+	if err != nil || n != len(words)-3 {
+		r.message = invalidDate
+		slackPrint(r)
+		return
+	}
+	if !until.After(time.Now()) {
+		r.message = dateInPast
+		slackPrint(r)
+		return
+	}
+	var before *Absence
+	if a, err := store.GetAway(r.user, awayManual); err == nil {
+		before = &a
+	} else if err != ErrNotAway {
+		r.message = errMessage(err)
+		slackPrint(r)
+		return
+	}
+	a := Absence{SlackID: r.user, Source: awayManual, Until: until}
+	if err := store.SetAway(a); err != nil {
+		r.message = errMessage(err)
+		slackPrint(r)
+		return
+	}
+	change := newChange(r, strings.Join(words, " "))
+	change.add(actionAway, "", "", absenceEntry(before), absenceEntry(&a))
+	recordChange(change)
+	cache.Load()
+	r.message = fmt.Sprintf(awaySet, until.Format(timeFmt))
+	slackPrint(r)
+}
+
+// setBack ends the absence the user declared with away
+func setBack(words []string, r response) {
+	a, err := store.GetAway(r.user, awayManual)
+	if err == nil {
+		err = store.ClearAway(r.user, awayManual)
+	}
+	if err != nil {
+		if err == ErrNotAway {
+			r.message = notAway
+		} else {
+			r.message = errMessage(err)
+		}
+		slackPrint(r)
+		return
+	}
+	change := newChange(r, strings.Join(words, " "))
+	change.add(actionAway, "", "", absenceEntry(&a), "")
+	recordChange(change)
+	cache.Load()
+	r.message = welcomeBack
+	slackPrint(r)
+}
+
+// showRotation shows who is on duty for a component and the upcoming handoffs
+func showRotation(componentChan string, r response) {
+	component, err := store.GetAnchor(componentChan)
+	if err != nil {
+		r.message = errMessage(err)
+		slackPrint(r)
+		return
+	}
+	r.message = rotationFmt(component, time.Now())
+	slackPrint(r)
+}
+
+func setPlaybook(words []string, r response) {
+	if !weblink.MatchString(words[4]) {
+		r.message = notWeblink
+		slackPrint(r)
+		return
+	}
+	component, err := store.GetAnchor(chanTrim(words[2]))
+	if err != nil {
+		r.message = errMessage(err)
+		slackPrint(r)
+		return
+	}
+	if err := store.ChangePlaybook(component.ComponentChan, urlTrim(words[4])); err != nil {
+		r.message = errMessage(err)
+		slackPrint(r)
+		return
+	}
+	change := newChange(r, strings.Join(words, " "))
+	change.add(actionPlaybook, component.ComponentChan, "", component.playbookURL(), urlTrim(words[4]))
+	recordChange(change)
+	cache.Load() // More than one tag will be reset - we need to reload the cache entirely
+	reindexPlaybooks(component.ComponentChan)
+	r.message = fmt.Sprintf("Successfully changed playbook for %s to %s", words[2], urlTrim(words[4]))
+	slackPrint(r)
+}
+
+// setInfo sets the name, description or area of a component to the rest of the
+// command, clearing it if that is none
+func setInfo(words []string, r response) {
+	field := infoArea
+	switch {
+	case regName.MatchString(words[3]):
+		field = infoName
+	case regDescribe.MatchString(words[3]):
+		field = infoDescription
+	}
+	value := strings.Join(words[4:], " ")
+	if regNone.MatchString(value) {
+		value = ""
+	}
+	component, err := store.GetAnchor(chanTrim(words[2]))
+	if err != nil {
+		r.message = errMessage(err)
+		slackPrint(r)
+		return
+	}
+	if err := store.ChangeInfo(component.ComponentChan, field, value); err != nil {
+		if err == ErrInfoTooLong {
+			r.message = fmt.Sprintf(infoTooLong, infoLengths[infoName], infoLengths[infoArea], infoLengths[infoDescription])
+		} else {
+			r.message = errMessage(err)
+		}
+		slackPrint(r)
+		return
+	}
+	change := newChange(r, strings.Join(words, " "))
+	change.add(field, component.ComponentChan, "", component.info(field), value)
+	recordChange(change)
+	cache.Load()
+	if value == "" {
+		r.message = fmt.Sprintf("Cleared the %s of %s", field, words[2])
+	} else {
+		r.message = fmt.Sprintf("Successfully changed the %s of %s", field, words[2])
+	}
+	slackPrint(r)
+}
+
+// setLink adds, replaces or removes a link of a component:
+// set #channel link label url, or set #channel link remove label
+func setLink(words []string, r response) {
+	if len(words) != 6 {
+		r.message = badLink
+		slackPrint(r)
+		return
+	}
+	component, err := store.GetAnchor(chanTrim(words[2]))
+	if err != nil {
+		r.message = errMessage(err)
+		slackPrint(r)
+		return
+	}
+	var before, after *ComponentLink
+	if regRemove.MatchString(words[4]) {
+		l, ok := component.link(strings.ToLower(words[5]))
+		if !ok {
+			r.message = fmt.Sprintf(noLink, words[2], words[5])
+			slackPrint(r)
+			return
+		}
+		before = &l
+		err = store.RemoveLink(l.ComponentChan, l.Label)
+	} else {
+		if !weblink.MatchString(words[5]) {
+			r.message = badLink
+			slackPrint(r)
+			return
+		}
+		after = &ComponentLink{ComponentChan: component.ComponentChan, Label: strings.ToLower(words[4]), URL: urlTrim(words[5])}
+		if l, ok := component.link(after.Label); ok {
+			before = &l
+		}
+		err = store.SetLink(*after)
+	}
+	if err != nil {
+		if err == ErrInfoTooLong {
+			r.message = linkTooLong
+		} else {
+			r.message = errMessage(err)
+		}
+		slackPrint(r)
+		return
+	}
+	change := newChange(r, strings.Join(words, " "))
+	change.add(actionLink, component.ComponentChan, "", linkEntry(before), linkEntry(after))
+	recordChange(change)
+	cache.Load()
+	if after == nil {
+		r.message = fmt.Sprintf("Removed the link _%s_ from %s", before.Label, words[2])
+	} else {
+		r.message = fmt.Sprintf("Successfully set the link _%s_ of %s to %s", after.Label, words[2], after.URL)
+	}
+	slackPrint(r)
+}
+
+// addPlaybook adds a titled playbook to a component, scoped to the tags after for:
+// playbook add #channel "title" url [for tag1, tag2]
+func addPlaybook(text string, words []string, r response) {
+	title, rest := playbookTitle(afterWords(text, 4))
+	if title == "" || len(rest) == 0 || !weblink.MatchString(rest[0]) || (len(rest) > 1 && !regFor.MatchString(rest[1])) {
+		r.message = badPlaybook
+		slackPrint(r)
+		return
+	}
+	p := Playbook{ComponentChan: chanTrim(words[3]), Title: title, URL: urlTrim(rest[0])}
+	if len(rest) > 2 {
+		var tags []string
+		for _, word := range strings.Split(strings.Join(rest[2:], " "), ",") {
+			word = strings.Join(strings.Fields(word), " ")
+			if word == "" {
+				continue
+			}
+			tag := cache.Canonical(word)
+			if !cache.ContainsTagInfo(TagInfo{Name: tag, ComponentChan: p.ComponentChan}) {
+				r.message = fmt.Sprintf(tagNotOnComponent, word, words[3])
+				slackPrint(r)
+				return
+			}
+			tags = append(tags, tag)
+		}
+		p.Tags = strings.Join(tags, ",")
+	}
+	if err := store.AddPlaybook(p); err != nil {
+		switch err {
+		case ErrPlaybookExists:
+			r.message = fmt.Sprintf(playbookExists, words[3], title)
+		case ErrURLTooLong:
+			r.message = urlTooLong
+		default:
+			r.message = errMessage(err)
+		}
+		slackPrint(r)
+		return
+	}
+	change := newChange(r, text)
+	change.add(actionPlaybookAdd, p.ComponentChan, "", "", playbookEntry(p))
+	recordChange(change)
+	cache.Load()
+	reindexPlaybooks(p.ComponentChan)
+	r.message = fmt.Sprintf("Added the playbook _%s_ to %s", title, words[3])
+	slackPrint(r)
+}
+
+// removePlaybook removes a playbook from a component by its title:
+// playbook remove #channel "title"
+func removePlaybook(text string, words []string, r response) {
+	title, _ := playbookTitle(afterWords(text, 4))
+	component, err := store.GetAnchor(chanTrim(words[3]))
+	if err != nil {
+		r.message = errMessage(err)
+		slackPrint(r)
+		return
+	}
+	p, ok := component.playbook(title)
+	if !ok {
+		r.message = fmt.Sprintf(noPlaybook, words[3], title)
+		slackPrint(r)
+		return
+	}
+	if err := store.RemovePlaybook(p.ComponentChan, p.Title); err != nil {
+		r.message = errMessage(err)
+		slackPrint(r)
+		return
+	}
+	change := newChange(r, text)
+	change.add(actionPlaybookRemove, p.ComponentChan, "", playbookEntry(p), "")
+	recordChange(change)
+	cache.Load()
+	reindexPlaybooks(p.ComponentChan)
+	r.message = fmt.Sprintf("Removed the playbook _%s_ from %s", p.Title, words[3])
+	slackPrint(r)
+}
+
+// showPlaybooks lists every playbook of a component
+func showPlaybooks(componentChan string, r response) {
+	component, err := store.GetAnchor(componentChan)
+	if err != nil {
+		r.message = errMessage(err)
+	} else {
+		r.message = playbookListFmt(component)
+	}
+	slackPrint(r)
+}
+
+// playbookTitle splits the title of a playbook, quoted if it is more than one word,
+// from the words after it
+func playbookTitle(text string) (title string, rest []string) {
+	if m := regTitle.FindStringSubmatch(text); m != nil {
+		return strings.TrimSpace(m[1]), strings.Fields(text[len(m[0]):])
+	}
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		return "", nil
+	}
+	return words[0], words[1:]
+}
+
+// afterWords returns the text following its first n words, with its spacing intact
+func afterWords(text string, n int) string {
+	for i := 0; i < n; i++ {
+		text = strings.TrimLeft(text, " \t\n")
+		j := strings.IndexAny(text, " \t\n")
+		if j < 0 {
+			return ""
+		}
+		text = text[j:]
+	}
+	return strings.TrimSpace(text)
+}
+
+// showWho lists the anchors of a component, expanding user groups to their members
+func showWho(componentChan string, r response) {
+	component, err := store.GetAnchor(componentChan)
+	if err != nil {
+		r.message = errMessage(err)
+		slackPrint(r)
+		return
+	}
+	members := make(map[string][]string)
+	for _, anchor := range component.anchorIDs() {
+		if !isUserGroup(anchor) {
+			continue
+		}
+		if members[anchor], err = anchorMembers(anchor); err != nil {
+			r.message = errMessage(err)
+			slackPrint(r)
+			return
+		}
+	}
+	r.message = whoFmt(component, members)
+	slackPrint(r)
+}
+
+func showHistory(filter AuditEntry, r response) {
+	changes, err := store.History(filter, historyLimit)
+	if err != nil {
+		r.message = errMessage(err)
+		slackPrint(r)
+		return
+	}
+	if len(changes) == 0 {
+		r.message = noHistory
+	} else {
+		r.message = historyFmt(changes)
+	}
+	slackPrint(r)
+}
+
+func undoChange(text string, r response) {
+	c, err := store.LastChange(r.user)
+	if err != nil {
+		if err == ErrNoChange {
+			r.message = nothingToUndo
+		} else {
+			r.message = errMessage(err)
+		}
+		slackPrint(r)
+		return
+	}
+	if time.Since(c.CreatedAt) > undoWindow {
+		r.message = fmt.Sprintf(undoExpired, c.ID)
+		slackPrint(r)
+		return
+	}
+	// undoing is a change like any other - the user must still be allowed to make it
+	switch chans, admin := undoChans(c); {
+	case admin && !authorize(r, text):
+		return
+	case !admin && len(chans) != 0 && !authorize(r, text, chans...):
+		return
+	}
+	revertAndReport(c, text, r)
+}
+
+func revertByID(text string, words []string, r response) {
+	id, err := strconv.Atoi(strings.TrimPrefix(words[2], "#"))
+	if err != nil {
+		r.message = invalidChangeID
+		slackPrint(r)
+		return
+	}
+	c, err := store.GetChange(id)
+	if err != nil {
+		if err == ErrNoChange {
+			r.message = fmt.Sprintf(noSuchChange, id)
+		} else {
+			r.message = errMessage(err)
+		}
+		slackPrint(r)
+		return
+	}
+	revertAndReport(c, text, r)
+}
+
+func revertAndReport(c Change, text string, r response) {
+	rev, err := revertChange(c, r, text)
+	if err != nil {
+		switch err {
+		case ErrAlreadyReverted:
+			r.message = fmt.Sprintf(alreadyReverted, c.ID)
+		case ErrRevertConflict:
+			r.message = fmt.Sprintf(revertConflict, c.ID)
+		case ErrNotRevertible:
+			r.message = fmt.Sprintf(notRevertible, c.ID)
+		default:
+			r.message = errMessage(err)
+		}
+		if rev != nil && len(rev.Entries) != 0 {
+			r.message += "\n" + fmt.Sprintf(partialRevert, c.ID) + historyFmt([]Change{*rev})
+		}
+		slackPrint(r)
+		return
+	}
+	r.message = fmt.Sprintf("Reverted change #%d:\n", c.ID) + historyFmt([]Change{*rev})
+	slackPrint(r)
+}
+
+// parseRole reads the role from a grant or revoke command
+func parseRole(words []string) (role Role, ok bool) {
+	switch {
+	case len(words) == 4 && regAdmin.MatchString(words[3]):
+		role.Name = roleAdmin
+	case len(words) == 5 && regMaintain.MatchString(words[3]):
+		role.Name, role.ComponentChan = roleMaintainer, chanTrim(words[4])
+	default:
+		return role, false
+	}
+	role.SlackID = usrTrim(words[2])
+	return role, true
+}
+
+func changeRole(text string, role Role, grant bool, r response) {
+	if grant && !validateUserName(role.SlackID) {
+		r.message = invalidUser
+		slackPrint(r)
+		return
+	}
+	if role.ComponentChan != "" {
+		if _, err := store.GetAnchor(role.ComponentChan); err != nil {
+			r.message = errMessage(err)
+			slackPrint(r)
+			return
+		}
+	}
+	change := newChange(r, text)
+	var err error
+	if grant {
+		err = store.GrantRole(role)
+		change.add(actionGrant, role.ComponentChan, "", "", roleEntry(role))
+	} else {
+		err = store.RevokeRole(role)
+		change.add(actionRevoke, role.ComponentChan, "", roleEntry(role), "")
+	}
+	switch err {
+	case nil:
+		recordChange(change)
+		r.message = "Done: " + auditEntryFmt(change.Entries[0])
+	case ErrRoleExists:
+		r.message = roleExists
+	case ErrNoRole:
+		r.message = noRole
+	default:
+		r.message = errMessage(err)
+	}
+	slackPrint(r)
+}
+
+func showRoles(componentChan string, r response) {
+	roles, err := store.ListRoles(componentChan)
+	if err != nil {
+		r.message = errMessage(err)
+		slackPrint(r)
+		return
+	}
+	if componentChan == "" {
+		for _, a := range admins {
+			roles = append(roles, Role{SlackID: a, Name: roleAdmin})
+		}
+		r.message = "*admins:* " + rolesFmt(roles)
+	} else {
+		component, err := store.GetAnchor(componentChan)
+		if err != nil {
+			r.message = errMessage(err)
+			slackPrint(r)
+			return
+		}
+		r.message = fmt.Sprintf("*anchor:* %s, *maintainers:* %s", anchorOrNone(component.AnchorSlackID), rolesFmt(roles))
+	}
+	slackPrint(r)
+}
+
+func migrateStatus(r response) {
+	m, ok := store.(Migrator)
+	if !ok {
+		r.message = noMigrations
+		slackPrint(r)
+		return
+	}
+	status, err := m.MigrationStatus()
+	if err != nil {
+		r.message = errMessage(err)
+		slackPrint(r)
+		return
+	}
+	r.message = migrationFmt(status)
+	slackPrint(r)
+}
+
+func migrateUp(r response) {
+	m, ok := store.(Migrator)
+	if !ok {
+		r.message = noMigrations
+		slackPrint(r)
+		return
+	}
+	applied, err := m.MigrateUp()
+	if len(applied) != 0 {
+		// new columns or constraints may change what is loaded
+		cache.Load()
+	}
+	if err != nil {
+		r.message = fmt.Sprintf("Applied %d migrations before one failed. %s", len(applied), errMessage(err))
+		slackPrint(r)
+		return
+	}
+	if len(applied) == 0 {
+		r.message = "The database schema is already up to date"
+	} else {
+		r.message = "Applied migrations:\n" + migrationFmt(applied)
+	}
+	slackPrint(r)
+}
+
+// errMessage returns the message to print to slack for an error returned by the store
+// or the slack API
+func errMessage(err error) string {
+	switch {
+	case err == ErrNoComponent:
+		return noComponentInDB
+	case err == ErrNoChannel:
+		return noChannelInSlack
+	case err == ErrNoTag:
+		return noTag
+	case IsDBError(err):
+		return dbUnavailable
+	}
+	log.WithField("ERROR", err).Error("unexpected error handling command")
+	return unexpectedError
+}
+
+func (r *response) setResponseContext(ev *slack.MessageEvent) {
+	chanInfo, err := sc.GetConversationInfo(ev.Channel, false)
+	if err != nil {
+		log.Error(err)
+	}
+	if !chanInfo.IsIM {
+		if ev.ThreadTimestamp != "" {
+			r.threadTS = ev.ThreadTimestamp
+		} else {
+			r.threadTS = ev.Timestamp
 		}
 	}
 
@@ -427,19 +1584,21 @@ func (r *response) setResponseContext(ev *slack.MessageEvent) {
 const (
 	reqAdd = iota
 	reqDrop
+	reqUntag
+	reqUnalias
 )
 
 func tagCleanup(message string, reqType int) []string {
 	var words []string
 	switch {
-	case reqType == reqAdd:
+	case reqType == reqAdd || reqType == reqUntag:
 		// Message like: "@bot tag #channel tag1, tag2a tag2b , tag3a b   tag3c"
 		words = strings.Split(message, ",")
 		for i, word := range words {
 			words[i] = strings.Join(strings.Fields(strings.Trim(word, " ")), " ")
 		}
 		words[0] = strings.Join(strings.Fields(words[0])[3:], " ")
-	case reqType == reqDrop:
+	case reqType == reqDrop || reqType == reqUnalias:
 		// Message like: "@bot drop tag1, tag2a tag2b , tag3a b   tag3c"
 		words = strings.Split(message, ",")
 		for i, word := range words {
@@ -447,5 +1606,33 @@ func tagCleanup(message string, reqType int) []string {
 		}
 		words[0] = strings.Join(strings.Fields(words[0])[2:], " ")
 	}
-	return words
+	var tags []string
+	for _, word := range words {
+		if tag := cleanTag(word); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// aliasCleanup returns the tag and the aliases of a message like
+// "@bot alias tag1a tag1b alias1, alias2a alias2b". The tag is the longest run of words
+// which is a known tag, so tags of several words can be given aliases too
+func aliasCleanup(message string) (tag string, aliases []string) {
+	items := strings.Split(afterWords(message, 2), ",")
+	words := strings.Fields(items[0])
+	n := 0
+	for i := len(words); i > 0; i-- {
+		if n = i; cache.ContainsTag(cleanTag(strings.Join(words[:i], " "))) {
+			break
+		}
+	}
+	tag = cleanTag(strings.Join(words[:n], " "))
+	items[0] = strings.Join(words[n:], " ")
+	for _, item := range items {
+		if alias := cleanTag(item); alias != "" {
+			aliases = append(aliases, alias)
+		}
+	}
+	return tag, aliases
 }
@@ -0,0 +1,155 @@
+/*
+Tests for parsing the commands and questions sent to the bot.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTagCleanup(t *testing.T) {
+	tests := []struct {
+		message string
+		reqType int
+		want    []string
+	}{
+		{"<@B> tag <#C1|c1> kafka", reqAdd, []string{"kafka"}},
+		{"<@B> tag <#C1|c1> Tag1, tag2a  tag2b , tag3a b   tag3c", reqAdd, []string{"tag1", "tag2a tag2b", "tag3a b tag3c"}},
+		{"<@B> untag <#C1|c1> kafka,, postgres.", reqUntag, []string{"kafka", "postgres"}},
+		{"<@B> drop kafka, “big data”", reqDrop, []string{"kafka", "big data"}},
+		{"<@B> unalias pg, pgsql,", reqUnalias, []string{"pg", "pgsql"}},
+		{"<@B> drop", reqDrop, nil},
+	}
+	for _, tt := range tests {
+		if got := tagCleanup(tt.message, tt.reqType); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("tagCleanup(%q) = %q, want %q", tt.message, got, tt.want)
+		}
+	}
+}
+
+func TestAliasCleanup(t *testing.T) {
+	newTestCache(t, map[string][]string{
+		"postgres":  {"C1"},
+		"big data":  {"C2"},
+		"data lake": {"C2"},
+	})
+	tests := []struct {
+		message string
+		tag     string
+		aliases []string
+	}{
+		{"<@B> alias postgres pg", "postgres", []string{"pg"}},
+		{"<@B> alias Postgres pg, pgsql,", "postgres", []string{"pg", "pgsql"}},
+		{"<@B> alias big data hadoop, spark stack", "big data", []string{"hadoop", "spark stack"}},
+		{"<@B> alias data lake lake", "data lake", []string{"lake"}},
+		{"<@B> alias unknown thing", "unknown", []string{"thing"}},
+		{"<@B> alias postgres", "postgres", nil},
+	}
+	for _, tt := range tests {
+		tag, aliases := aliasCleanup(tt.message)
+		if tag != tt.tag || !reflect.DeepEqual(aliases, tt.aliases) {
+			t.Errorf("aliasCleanup(%q) = %q %q, want %q %q", tt.message, tag, aliases, tt.tag, tt.aliases)
+		}
+	}
+}
+
+func TestPlaybookTitle(t *testing.T) {
+	tests := []struct {
+		text  string
+		title string
+		rest  []string
+	}{
+		{"restart <https://wiki/restart>", "restart", []string{"<https://wiki/restart>"}},
+		{`"disk full" <https://wiki/disk>`, "disk full", []string{"<https://wiki/disk>"}},
+		{"“disk full ” <https://wiki/disk> spare", "disk full", []string{"<https://wiki/disk>", "spare"}},
+		{"", "", nil},
+	}
+	for _, tt := range tests {
+		title, rest := playbookTitle(tt.text)
+		if title != tt.title || len(rest) != len(tt.rest) || (len(rest) > 0 && !reflect.DeepEqual(rest, tt.rest)) {
+			t.Errorf("playbookTitle(%q) = %q %q, want %q %q", tt.text, title, rest, tt.title, tt.rest)
+		}
+	}
+}
+
+func TestAfterWords(t *testing.T) {
+	tests := []struct {
+		text string
+		n    int
+		want string
+	}{
+		{"<@B> description <#C1|c1> Runs  the\nqueue", 3, "Runs  the\nqueue"},
+		{"  <@B>   alias pg", 2, "pg"},
+		{"<@B> description <#C1|c1>", 3, ""},
+		{"<@B> description", 3, ""},
+	}
+	for _, tt := range tests {
+		if got := afterWords(tt.text, tt.n); got != tt.want {
+			t.Errorf("afterWords(%q, %d) = %q, want %q", tt.text, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestCommandWords(t *testing.T) {
+	tests := []struct {
+		word  string
+		away  bool
+		back  bool
+		title bool
+	}{
+		{"away", true, false, false},
+		{"AWAY", true, false, false},
+		{"faraway", false, false, false},
+		{"back", false, true, false},
+		{"fallback", false, false, false},
+		{"backup", false, false, false},
+		{`"disk`, false, false, false},
+		{`"disk"`, false, false, true},
+	}
+	for _, tt := range tests {
+		if regAway.MatchString(tt.word) != tt.away || regBack.MatchString(tt.word) != tt.back || regTitle.MatchString(tt.word) != tt.title {
+			t.Errorf("%q matched away %v, back %v, title %v", tt.word,
+				regAway.MatchString(tt.word), regBack.MatchString(tt.word), regTitle.MatchString(tt.word))
+		}
+	}
+}
+
+func TestTagMatch(t *testing.T) {
+	newTestCache(t, map[string][]string{
+		"kafka":    {"C1"},
+		"postgres": {"C2"},
+		"big data": {"C3"},
+	})
+	matcher = mustMatcher(defaultMatchers)
+
+	tests := []struct {
+		question string
+		want     []string
+	}{
+		{"<@B> is kafka down?", []string{"C1"}},
+		{"<@B> postgres and Kafka are slow", []string{"C1", "C2"}}, // ties go by tag name
+		{"<@B> who runs big data", []string{"C3"}},
+		{"<@B> hello there", nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, m := range tagMatch(strings.Fields(tt.question)) {
+			got = append(got, m.Tags[0].ComponentChan)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("tagMatch(%q) = %v, want %v", tt.question, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("tagMatch(%q) = %v, want %v", tt.question, got, tt.want)
+				break
+			}
+		}
+	}
+}
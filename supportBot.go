@@ -2,8 +2,8 @@
 Support bot - first response and basic AI for handling new support questions in Slack
 for the Pivotal support team
 
-PCF enabled - requires Postgres Database instance as well as bot integration key
-provided by Slack
+Runs on PCF using the bound Postgres database, or anywhere else given a database and
+the bot integration key provided by Slack - see config.go for the available settings
 
 Released under MIT liscence, copyright 2018 Tyler Ramer
 */
@@ -15,78 +15,119 @@ import (
 	"os"
 	"time"
 
-	"github.com/cloudfoundry-community/go-cfenv"
 	"github.com/nlopes/slack"
 	log "github.com/sirupsen/logrus"
 )
 
-const serviceLable = "elephantsql"
-
-var conStr string
-
-// Get bot name and token from env, and make sure botID is globally accessible
+// make sure botID and the bot channel ID are globally accessible
 var (
-	slackBotToken   = os.Getenv("SLACK_BOT_TOKEN")
-	slackBotName    = os.Getenv("SLACK_BOT_NAME")
-	slackBotChannel = os.Getenv("SLACK_BOT_CHANNEL")
-	botID           string
-	chanID          string
+	botID  string
+	chanID string
 )
 
 // The slack client and RTM messaging are used as a stdout - rather than passing
 // the SC to each function, define it globally to ease accessed. We do handle init
 // errors in the main function, however
-// The TagCache is similarly globally defined for convinience
+// The TagCache and the TagStore behind it are similarly globally defined for convinience
 
 var (
 	sc    *slack.Client
 	rtm   *slack.RTM
+	store Store
 	cache *TagCache
 )
 
-// log levels, see logrus docs
-func init() {
-	log.SetOutput(os.Stdout)
-	log.SetLevel(log.DebugLevel)
-
-	appEnv, err := cfenv.Current()
+// bootstrap sets up logging, the database, the tag cache and the slack client from
+// the provided config
+func bootstrap(cfg *Config) error {
+	level, err := log.ParseLevel(cfg.LogLevel)
 	if err != nil {
-		log.Fatal("Could not get cloud foundry environment details")
+		return err
 	}
-	dbService, err := appEnv.Services.WithLabel(serviceLable)
+	log.SetOutput(os.Stdout)
+	log.SetLevel(level)
+	matchDistPercent = cfg.MatchDistPercent
+	minWordLength = cfg.MinWordLength
+	stopWords = wordSet(cfg.StopWords)
+	if matcher, err = newMatcher(cfg.Matchers); err != nil {
+		return err
+	}
+	undoWindow = cfg.undoWindow
+	proposalTTL = cfg.proposalTTL
+	admins = cfg.Admins
+	awayEmoji = cfg.AwayEmoji
+	linkCheckInterval = cfg.linkCheck
+	indexInterval = cfg.index
+	signingSecret = cfg.SigningSecret
+
+	gormStore, err := NewGormStore(cfg.DBDialect, cfg.DBURL)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	if err = migrateStartup(gormStore, cfg.AutoMigrate); err != nil {
+		log.Error("Could not query tables and had a problem creating them successfully")
+		return err
+	}
+	log.Debug("Starting cache load")
+	store = gormStore
+	if cache, err = NewTagCache(store); err != nil {
+		return err
+	}
+	// the cache may have missed changes while the database was unavailable
+	gormStore.OnReconnect = func() { cache.Load() }
+	log.Debug("Finished loading cache")
 
-	if len(dbService) != 1 {
-		log.WithField("serviceLable", serviceLable).Fatal("It appears that more than one service with the serviceLable is attached to your app\nPlease ensure there is only one database instance attached")
+	sc = slack.New(cfg.SlackToken)
+	if botID, err = getBotID(cfg.BotName, sc); err != nil {
+		return err
+	}
+	log.WithField("ID", botID).Debug("Bot ID returned")
+	if chanID, err = getBotChannel(cfg.BotChannel, sc); err != nil {
+		return err
 	}
-	var ok bool
-	conStr, ok = dbService[0].CredentialString("uri")
-	if !ok {
-		log.Fatal("Could not find database URI")
+	return nil
+}
+
+// migrateStartup applies pending migrations, or only warns about them if automatic
+// migration is turned off
+func migrateStartup(m Migrator, auto bool) error {
+	if auto {
+		_, err := m.MigrateUp()
+		return err
 	}
-	InitDB()
-	err = MigrateDB()
+	status, err := m.MigrationStatus()
 	if err != nil {
-		log.Error("Could not query tables and had a problem creating them successfully, closing")
-		log.Fatal(err)
+		return err
 	}
-	log.Debug("Starting cache load")
-	cache = NewTagCache()
-	log.Debug("Finished loading cache")
+	for _, st := range status {
+		if st.AppliedAt == nil {
+			log.WithFields(log.Fields{"version": st.Version, "name": st.Name}).Warn("pending migration - run @bot migrate up to apply it")
+		}
+	}
+	return nil
 }
 
 func main() {
 	rand.Seed(time.Now().Unix())
 
-	sc = slack.New(slackBotToken)
-	botID = getBotID(slackBotName, sc)
-	chanID = getBotChannel(slackBotChannel, sc)
-	log.WithField("ID", botID).Debug("Bot ID returned")
+	cfg, err := loadConfig(os.Args[1:], os.Getenv)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = bootstrap(cfg); err != nil {
+		log.Error("Could not start the bot, shutting down")
+		log.Fatal(err)
+	}
 	rtm = sc.NewRTM()
 	go rtm.ManageConnection()
 	log.Info("Connected to slack")
+	go func() {
+		log.Fatal(serveInteractions(cfg.HTTPPort))
+	}()
+	go sweepProposals()
+	go sweepAway()
+	go sweepLinks()
+	go sweepIndex()
 
 	for slackEvent := range rtm.IncomingEvents {
 		switch ev := slackEvent.Data.(type) {
@@ -18,26 +18,78 @@ if cache.Contains(tag) {
 package main
 
 import (
+	"sort"
 	"strings"
 	"sync"
 
 	log "github.com/sirupsen/logrus"
 )
 
-// TagCache is just a hashmap of tags to tagInfo. Further methods are defined to ease use of the cache
+// TagCache is just a hashmap of tags to tagInfo. Further methods are defined to ease use of the cache.
+// Aliases are keys of the map too, holding the TagInfo of their canonical tag
 type TagCache struct {
 	sync.Mutex
 	Tags  map[string][]TagInfo
 	Count int
+	store TagStore
+	// indexes of the keys of Tags, built when first needed
+	fuzzy  *bkTree             // for fuzzy lookups
+	normal map[string][]string // by their normalised form, see normalize.go
 }
 
 // TagInfo is the response structure when a tag query is made
 type TagInfo struct {
 	Anchor        string
-	Name          string
-	PlaybookURL   string
+	Anchors       []ComponentAnchor // anchors other than the primary
+	Rotation      *Rotation
+	Overrides     []AnchorOverride
+	Away          map[string]Absence
+	Name          string   // the canonical tag name
+	Aliases       []string // other names of the tag, see aliases.go
+	Playbooks     []Playbook
 	ComponentChan string
 	SupportChan   string
+	DisplayName   string
+	Description   string
+	Area          string
+	Links         []ComponentLink
+}
+
+// newTagInfo returns the TagInfo for tag n on component c
+func newTagInfo(n string, c Component) TagInfo {
+	return TagInfo{
+		Name:          n,
+		Anchor:        c.AnchorSlackID,
+		Anchors:       c.Anchors,
+		Rotation:      c.Rotation,
+		Overrides:     c.Overrides,
+		Away:          c.Away,
+		ComponentChan: c.ComponentChan,
+		Playbooks:     c.Playbooks,
+		SupportChan:   c.SupportChan,
+		DisplayName:   c.DisplayName,
+		Description:   c.Description,
+		Area:          c.Area,
+		Links:         c.Links,
+	}
+}
+
+// component returns the component the TagInfo describes
+func (t TagInfo) component() Component {
+	return Component{
+		AnchorSlackID: t.Anchor,
+		Playbooks:     t.Playbooks,
+		ComponentChan: t.ComponentChan,
+		SupportChan:   t.SupportChan,
+		Anchors:       t.Anchors,
+		Rotation:      t.Rotation,
+		Overrides:     t.Overrides,
+		Away:          t.Away,
+		DisplayName:   t.DisplayName,
+		Description:   t.Description,
+		Area:          t.Area,
+		Links:         t.Links,
+	}
 }
 
 // GetNames gets a []string slice of all tag names in the cache
@@ -50,7 +102,7 @@ func (cache *TagCache) getNames() []string {
 	// NOTE: this looks a bit long but it is faster to iterate with
 	// i rather than to use append when we already know the size of the slice
 	// https://stackoverflow.com/a/27848197
-	keys := make([]string, cache.Count)
+	keys := make([]string, len(cache.Tags)) // Count leaves out aliases
 	i := 0
 	for k := range cache.Tags {
 		keys[i] = k
@@ -72,6 +124,36 @@ func (cache *TagCache) find(t string) []TagInfo {
 
 }
 
+// Similar returns the tags and aliases with a levenshtein ratio of at least minRatio
+// to word, with their ratios
+func (cache *TagCache) Similar(word string, minRatio float64) map[string]float64 {
+	cache.Lock()
+	defer cache.Unlock()
+	if cache.fuzzy == nil {
+		cache.buildIndexes()
+	}
+	similar := make(map[string]float64)
+	for name, d := range cache.fuzzy.near(word, maxDistance(len([]rune(word)), minRatio)) {
+		if r := ratio(word, name, d); r >= minRatio {
+			similar[name] = r
+		}
+	}
+	return similar
+}
+
+// Normalized returns the tags and aliases which normalise to the same as words
+func (cache *TagCache) Normalized(words string) []string {
+	cache.Lock()
+	defer cache.Unlock()
+	if cache.normal == nil {
+		cache.buildIndexes()
+	}
+	if key := normalKey(words); key != "" {
+		return cache.normal[key]
+	}
+	return nil
+}
+
 // ContainsTag returns bool if the cache contains the tag
 func (cache *TagCache) ContainsTag(t string) bool {
 	cache.Lock()
@@ -103,6 +185,43 @@ func (cache *TagCache) containsTagInfo(t TagInfo) bool {
 	return false
 }
 
+// ComponentTags returns the sorted names of all tags marked for a component
+func (cache *TagCache) ComponentTags(componentChan string) []string {
+	cache.Lock()
+	defer cache.Unlock()
+	var names []string
+	for name, tags := range cache.Tags {
+		for _, tag := range tags {
+			if tag.ComponentChan == componentChan {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Anchors returns the sorted slack IDs of everyone anchoring the component of a tag
+func (cache *TagCache) Anchors() []string {
+	cache.Lock()
+	defer cache.Unlock()
+	seen := make(map[string]bool)
+	var ids []string
+	for _, tags := range cache.Tags {
+		for _, tag := range tags {
+			for _, id := range tag.component().anchorIDs() {
+				if id != "" && !seen[id] {
+					seen[id] = true
+					ids = append(ids, id)
+				}
+			}
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
 // Add adds a tag + TagInfo to the cache. If the tag is already in the cache, it adds
 // to the TagInfo array. Handles lowering strings as well
 func (cache *TagCache) Add(t TagInfo) error {
@@ -113,74 +232,228 @@ func (cache *TagCache) Add(t TagInfo) error {
 }
 
 func (cache *TagCache) add(t TagInfo) error {
-	var err error
-	t.Name = strings.ToLower(t.Name)
-	if cache.Count == 0 || !cache.containsTag(t.Name) {
-		if err := AddTag(t); err != nil {
-			if err == ErrNoComponent || err == ErrTagTooLong || err == ErrNoChannel {
-				return err
-			}
-			log.Panic(err) // we don't want a discrepancy between cache and there's some critical issue here
-			// TODO : error handling where we alert the maintainer that there's an issue
-		}
-		cache.Tags[t.Name], err = QueryTag(t.Name)
-		cache.Count++
-	} else {
-		if err := AddTag(t); err != nil {
-			if err == ErrNoComponent || err == ErrTagTooLong || err == ErrNoChannel {
-				return err
-			}
-			log.Panic(err)
-		}
-		cache.Tags[t.Name], err = QueryTag(t.Name)
+	t.Name = cache.canonical(t.Name)
+	isNew := !cache.containsTag(t.Name)
+	if err := cache.store.AddTag(t); err != nil {
+		return err
 	}
+	tags, err := cache.store.QueryTag(t.Name)
 	if err != nil {
 		log.Error("Error fetching tag data from the DB. There may be a discrepancy between the cache and the db")
-		log.Panic(err) // TODO alert bot maintainer
+		return err
+	}
+	cache.set(t.Name, tags)
+	if isNew {
+		cache.Count++
 	}
 	return nil
 }
 
-// Drop removes a tag from the cache (probably not necessary for this use case)
-// Be aware that this currently removes ALL TagInfo from the cache related to the tag
-// FIXME: For consideration - should we remove a specific TagInfo? - Also should we drop from DB?
-func (cache *TagCache) Drop(t string) {
+// Drop removes a tag from the cache and the DB
+// Be aware that this removes ALL TagInfo from the cache related to the tag - use Untag
+// to remove the tag from a single component
+func (cache *TagCache) Drop(t string) error {
 	cache.Lock()
 	defer cache.Unlock()
-	cache.drop(t)
+	return cache.drop(t)
 }
 
-func (cache *TagCache) drop(t string) {
+func (cache *TagCache) drop(t string) error {
 	if !cache.containsTag(t) {
-		return
+		return ErrNoTag
 	}
-	t = strings.ToLower(t)
-	err := DropTag(t)
-	if err != nil {
-		log.Error("Could not drop tag from the DB. There may be a discrepancy between the cache and the db")
-		log.Panic(err)
+	t = cache.canonical(t)
+	if err := cache.store.DropTag(t); err != nil {
+		log.Error("Could not drop tag from the DB")
+		return err
 	}
-	delete(cache.Tags, t)
+	cache.set(t, nil)
 	cache.Count--
+	return nil
+}
+
+// Untag removes a single component from a tag in the cache and the DB. The tag is
+// removed entirely once no components are left
+func (cache *TagCache) Untag(t TagInfo) error {
+	cache.Lock()
+	defer cache.Unlock()
+	return cache.untag(t)
+}
+
+func (cache *TagCache) untag(t TagInfo) error {
+	t.Name = cache.canonical(t.Name)
+	if !cache.containsTagInfo(t) {
+		if !cache.containsTag(t.Name) {
+			return ErrNoTag
+		}
+		return ErrTagNotOnComponent
+	}
+	if err := cache.store.RemoveTag(t); err != nil {
+		log.Error("Could not remove tag from the component in the DB")
+		return err
+	}
+	var remaining []TagInfo
+	for _, tag := range cache.Tags[t.Name] {
+		if tag.ComponentChan != t.ComponentChan {
+			remaining = append(remaining, tag)
+		}
+	}
+	cache.set(t.Name, remaining)
+	if len(remaining) == 0 {
+		cache.Count--
+	}
+	return nil
+}
+
+// Canonical returns the tag an alias belongs to, or the lowered name if it is not an alias
+func (cache *TagCache) Canonical(t string) string {
+	cache.Lock()
+	defer cache.Unlock()
+	return cache.canonical(t)
+}
+
+func (cache *TagCache) canonical(t string) string {
+	t = strings.ToLower(t)
+	if tags := cache.Tags[t]; len(tags) != 0 {
+		return tags[0].Name
+	}
+	return t
+}
+
+// set replaces the TagInfo of a canonical tag and its aliases, removing them all if
+// tags is empty. The aliases are taken from the new TagInfo, or the old ones if the
+// tag is removed
+func (cache *TagCache) set(name string, tags []TagInfo) {
+	old := cache.Tags[name]
+	touched := []string{name}
+	for _, infos := range [][]TagInfo{old, tags} {
+		if len(infos) != 0 {
+			for _, alias := range infos[0].Aliases {
+				delete(cache.Tags, alias)
+				touched = append(touched, alias)
+			}
+		}
+	}
+	defer cache.index(touched)
+	if len(tags) == 0 {
+		delete(cache.Tags, name)
+		return
+	}
+	cache.Tags[name] = tags
+	for _, alias := range tags[0].Aliases {
+		cache.Tags[alias] = tags
+	}
+}
+
+// index updates the indexes for names which may have been added or removed
+func (cache *TagCache) index(names []string) {
+	if cache.fuzzy == nil {
+		return
+	}
+	for _, n := range names {
+		key := normalKey(n)
+		var same []string
+		for _, other := range cache.normal[key] {
+			if other != n {
+				same = append(same, other)
+			}
+		}
+		if cache.containsTag(n) {
+			cache.fuzzy.add(n)
+			same = append(same, n)
+		} else {
+			cache.fuzzy.remove(n)
+		}
+		if len(same) == 0 {
+			delete(cache.normal, key)
+		} else if key != "" {
+			cache.normal[key] = same
+		}
+	}
+}
+
+// buildIndexes builds the indexes of all names in the cache
+func (cache *TagCache) buildIndexes() {
+	names := cache.getNames()
+	sort.Strings(names)
+	cache.fuzzy = newBKTree(names)
+	cache.normal = make(map[string][]string)
+	for _, n := range names {
+		if key := normalKey(n); key != "" {
+			cache.normal[key] = append(cache.normal[key], n)
+		}
+	}
+}
+
+// AddAlias adds an alias to a tag in the cache and the DB. If the tag is itself an
+// alias, the alias is added to its canonical tag, which is returned
+func (cache *TagCache) AddAlias(alias, t string) (string, error) {
+	cache.Lock()
+	defer cache.Unlock()
+	alias, t = strings.ToLower(alias), cache.canonical(t)
+	if !cache.containsTag(t) {
+		return t, ErrNoTag
+	}
+	if cache.containsTag(alias) {
+		return t, ErrAliasTaken
+	}
+	if err := cache.store.AddAlias(TagAlias{Name: alias, TagName: t}); err != nil {
+		return t, err
+	}
+	return t, cache.reload(t)
+}
 
+// RemoveAlias removes an alias from the cache and the DB, and returns the tag it belonged to
+func (cache *TagCache) RemoveAlias(alias string) (string, error) {
+	cache.Lock()
+	defer cache.Unlock()
+	alias = strings.ToLower(alias)
+	t := cache.canonical(alias)
+	if t == alias {
+		return t, ErrNoAlias
+	}
+	if err := cache.store.RemoveAlias(alias); err != nil {
+		return t, err
+	}
+	return t, cache.reload(t)
+}
+
+// reload fetches a canonical tag from the DB again
+func (cache *TagCache) reload(t string) error {
+	tags, err := cache.store.QueryTag(t)
+	if err != nil {
+		log.Error("Error fetching tag data from the DB. There may be a discrepancy between the cache and the db")
+		return err
+	}
+	cache.set(t, tags)
+	return nil
 }
 
 // Load adds all tags in the database to the cache  // TODO - govern concurrent access here?
-// This should be called when the cache is first initialized
-func (cache *TagCache) Load() {
+// This should be called when the cache is first initialized. If the store fails, the
+// cache keeps its current entries
+func (cache *TagCache) Load() error {
 	cache.Lock()
 	defer cache.Unlock()
-	cache.load()
+	return cache.load()
 }
 
-func (cache *TagCache) load() {
-	cache.Tags, cache.Count = GetAllTags()
+func (cache *TagCache) load() error {
+	tags, count, err := cache.store.GetAllTags()
+	if err != nil {
+		log.Error("Could not load tags from the DB, keeping the current cache")
+		return err
+	}
+	cache.Tags, cache.Count = tags, count
+	cache.buildIndexes()
+	return nil
 }
 
-// NewTagCache returns a pointer to a tagCache with entries from the database loaded
-func NewTagCache() *TagCache {
+// NewTagCache returns a pointer to a tagCache with entries from the store loaded
+func NewTagCache(store TagStore) (*TagCache, error) {
 	var t = new(TagCache)
 	t.Tags = make(map[string][]TagInfo)
-	t.Load() //TODO: Consider adding counter for how long it takes to load the cache? Consider concurrently loading?
-	return t
+	t.store = store
+	err := t.Load() //TODO: Consider adding counter for how long it takes to load the cache? Consider concurrently loading?
+	return t, err
 }
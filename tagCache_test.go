@@ -0,0 +1,185 @@
+/*
+Tests for the tag cache, backed by a MemStore.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// newTestCache returns a cache of the tags, keyed by tag name, on a MemStore with a
+// component for every channel, anchored by U and the channel. The cache and its store
+// are also set as the global ones
+func newTestCache(t testing.TB, tags map[string][]string) *TagCache {
+	s := NewMemStore()
+	for name, chans := range tags {
+		for _, ch := range chans {
+			if err := s.AddComponent(Component{ComponentChan: ch, AnchorSlackID: "U" + ch}); err != nil && err != ErrComponentExists {
+				t.Fatal(err)
+			}
+			if err := s.AddTag(TagInfo{Name: name, ComponentChan: ch}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	c, err := NewTagCache(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, store = c, s
+	return c
+}
+
+// findChans returns the sorted component channels of a tag in the cache
+func findChans(c *TagCache, name string) (chans []string) {
+	for _, t := range c.Find(name) {
+		chans = append(chans, t.ComponentChan)
+	}
+	sort.Strings(chans)
+	return chans
+}
+
+func TestTagCacheLoad(t *testing.T) {
+	c := newTestCache(t, map[string][]string{
+		"kafka":    {"C1"},
+		"postgres": {"C2", "C3"},
+	})
+	if c.Count != 2 || len(c.GetNames()) != 2 {
+		t.Fatalf("loaded %d tags named %v, want 2", c.Count, c.GetNames())
+	}
+	if got := findChans(c, "Postgres"); !reflect.DeepEqual(got, []string{"C2", "C3"}) {
+		t.Errorf("Find(Postgres) = %v", got)
+	}
+	if tags := c.Find("kafka"); len(tags) != 1 || tags[0].Anchor != "UC1" || tags[0].Name != "kafka" {
+		t.Errorf("Find(kafka) = %+v", tags)
+	}
+	if !c.ContainsTagInfo(TagInfo{Name: "KAFKA", ComponentChan: "C1"}) || c.ContainsTagInfo(TagInfo{Name: "kafka", ComponentChan: "C2"}) {
+		t.Error("ContainsTagInfo doesn't match the component")
+	}
+	if got := c.ComponentTags("C2"); !reflect.DeepEqual(got, []string{"postgres"}) {
+		t.Errorf("ComponentTags(C2) = %v", got)
+	}
+}
+
+func TestTagCacheAdd(t *testing.T) {
+	c := newTestCache(t, map[string][]string{"kafka": {"C1"}, "other": {"C2"}})
+	if err := c.Add(TagInfo{Name: "Kafka", ComponentChan: "C2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Add(TagInfo{Name: "zookeeper", ComponentChan: "C1"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := findChans(c, "kafka"); !reflect.DeepEqual(got, []string{"C1", "C2"}) {
+		t.Errorf("Find(kafka) = %v", got)
+	}
+	if c.Count != 3 {
+		t.Errorf("Count = %d, want 3", c.Count)
+	}
+	if err := c.Add(TagInfo{Name: "x", ComponentChan: "C9"}); err != ErrNoComponent {
+		t.Errorf("Add to an unknown component = %v, want ErrNoComponent", err)
+	}
+	if c.ContainsTag("x") {
+		t.Error("tag of an unknown component is cached")
+	}
+}
+
+func TestTagCacheUntagAndDrop(t *testing.T) {
+	c := newTestCache(t, map[string][]string{
+		"kafka":    {"C1", "C2"},
+		"postgres": {"C1"},
+	})
+	if _, err := c.AddAlias("kfk", "kafka"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Untag(TagInfo{Name: "kfk", ComponentChan: "C1"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := findChans(c, "kfk"); !reflect.DeepEqual(got, []string{"C2"}) {
+		t.Errorf("after untagging C1, Find(kfk) = %v", got)
+	}
+	if err := c.Untag(TagInfo{Name: "kafka", ComponentChan: "C1"}); err != ErrTagNotOnComponent {
+		t.Errorf("Untag twice = %v, want ErrTagNotOnComponent", err)
+	}
+	if err := c.Untag(TagInfo{Name: "kafka", ComponentChan: "C2"}); err != nil {
+		t.Fatal(err)
+	}
+	if c.ContainsTag("kafka") || c.ContainsTag("kfk") || c.Count != 1 {
+		t.Errorf("untagging the last component left %v, count %d", c.GetNames(), c.Count)
+	}
+
+	if err := c.Drop("postgres"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Drop("postgres"); err != ErrNoTag {
+		t.Errorf("Drop twice = %v, want ErrNoTag", err)
+	}
+	if len(c.Tags) != 0 || c.Count != 0 {
+		t.Errorf("after dropping everything, the cache has %v, count %d", c.GetNames(), c.Count)
+	}
+	if tags, _, _ := c.store.GetAllTags(); len(tags) != 0 {
+		t.Errorf("after dropping everything, the store has %v", tags)
+	}
+}
+
+func TestTagCacheAliases(t *testing.T) {
+	c := newTestCache(t, map[string][]string{
+		"postgres": {"C1"},
+		"kafka":    {"C2"},
+	})
+	if tag, err := c.AddAlias("PG", "postgres"); err != nil || tag != "postgres" {
+		t.Fatalf("AddAlias(PG) = %s, %v", tag, err)
+	}
+	// an alias of an alias belongs to the canonical tag
+	if tag, err := c.AddAlias("pgsql", "pg"); err != nil || tag != "postgres" {
+		t.Fatalf("AddAlias(pgsql, pg) = %s, %v", tag, err)
+	}
+	if _, err := c.AddAlias("kafka", "postgres"); err != ErrAliasTaken {
+		t.Errorf("AddAlias of a tag = %v, want ErrAliasTaken", err)
+	}
+	if _, err := c.AddAlias("x", "nope"); err != ErrNoTag {
+		t.Errorf("AddAlias to an unknown tag = %v, want ErrNoTag", err)
+	}
+	if got := c.Canonical("PGSQL"); got != "postgres" {
+		t.Errorf("Canonical(PGSQL) = %s", got)
+	}
+	tags := c.Find("pg")
+	if len(tags) != 1 || tags[0].Name != "postgres" || !reflect.DeepEqual(tags[0].Aliases, []string{"pg", "pgsql"}) {
+		t.Errorf("Find(pg) = %+v", tags)
+	}
+	if c.Count != 2 {
+		t.Errorf("aliases are counted as tags: Count = %d", c.Count)
+	}
+
+	// tagging an alias tags the canonical tag
+	if err := c.Add(TagInfo{Name: "pg", ComponentChan: "C2"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := findChans(c, "postgres"); !reflect.DeepEqual(got, []string{"C1", "C2"}) {
+		t.Errorf("after tagging pg, Find(postgres) = %v", got)
+	}
+
+	if tag, err := c.RemoveAlias("pg"); err != nil || tag != "postgres" {
+		t.Fatalf("RemoveAlias(pg) = %s, %v", tag, err)
+	}
+	if _, err := c.RemoveAlias("postgres"); err != ErrNoAlias {
+		t.Errorf("RemoveAlias of a tag = %v, want ErrNoAlias", err)
+	}
+	if c.ContainsTag("pg") || !c.ContainsTag("pgsql") {
+		t.Errorf("after removing pg, the cache has %v", c.GetNames())
+	}
+
+	// a new cache on the same store loads the aliases
+	reloaded, err := NewTagCache(c.store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reloaded.Canonical("pgsql"); got != "postgres" {
+		t.Errorf("after a reload, Canonical(pgsql) = %s", got)
+	}
+}
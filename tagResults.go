@@ -0,0 +1,159 @@
+/*
+Ranking the tags matched in a question.
+
+The same component is often found more than once - by its tag and an alias, or by a
+word and the pair of words it starts. Matches are grouped by component, and the
+components ranked by their best match. Matches are ranked by the kind of match first -
+a tag named in the question beats an alias, which beats a fuzzy or full text match - then
+by the score of the matcher, and only then by how many words matched. Each component is
+shown once, with every tag of it which matched and the words they matched.
+
+Only the best maxComponentMatches components are shown at first, with a button to
+show the rest. The button carries how many were shown, and clicking it adds the
+components after those below them.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nlopes/slack"
+)
+
+// ComponentMatch is a component found by the tags a question matched
+type ComponentMatch struct {
+	Tags  []TagInfo // the matched tags of the component, best first
+	Words []string  // the words of the question which matched, best first
+	Score float64   // the score of the best match
+}
+
+const (
+	maxComponentMatches = 3
+	maxMoreValue        = 2000 // length of a button value allowed by slack
+)
+
+// callback ID prefix of the button showing more matches
+const callbackMore = "more"
+
+// tag returns the best matching tag of the component
+func (m ComponentMatch) tag() TagInfo {
+	return m.Tags[0]
+}
+
+// class returns the kind of match a candidate is, 0 for the best, see matcherOrder
+func (c Candidate) class() int {
+	for i, name := range matcherOrder {
+		if c.Matcher == name {
+			return i
+		}
+	}
+	return len(matcherOrder)
+}
+
+// rankMatches groups candidates by the components of their tags, best first
+func rankMatches(candidates []Candidate, tags TagSet) []ComponentMatch {
+	candidates = append([]Candidate(nil), candidates...)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if ci, cj := candidates[i].class(), candidates[j].class(); ci != cj {
+			return ci < cj
+		}
+		if candidates[i].Score != candidates[j].Score {
+			return candidates[i].Score > candidates[j].Score
+		}
+		if ni, nj := len(strings.Fields(candidates[i].Words)), len(strings.Fields(candidates[j].Words)); ni != nj {
+			return ni > nj
+		}
+		if candidates[i].Tag != candidates[j].Tag {
+			return candidates[i].Tag < candidates[j].Tag
+		}
+		return candidates[i].Words < candidates[j].Words
+	})
+	var matches []ComponentMatch
+	index := make(map[string]int) // component channel to its position in matches
+	for _, c := range candidates {
+		for _, info := range tags.Find(c.Tag) {
+			i, ok := index[info.ComponentChan]
+			if !ok {
+				i = len(matches)
+				index[info.ComponentChan] = i
+				matches = append(matches, ComponentMatch{Score: c.Score})
+			}
+			m := &matches[i]
+			if !hasTag(m.Tags, info.Name) {
+				m.Tags = append(m.Tags, info)
+			}
+			if !hasWord(m.Words, c.Words) {
+				m.Words = append(m.Words, c.Words)
+			}
+		}
+	}
+	return matches
+}
+
+func hasTag(tags []TagInfo, name string) bool {
+	for _, t := range tags {
+		if t.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func hasWord(words []string, w string) bool {
+	for _, x := range words {
+		if x == w {
+			return true
+		}
+	}
+	return false
+}
+
+// moreButton returns the attachment with a button showing the matches of the question
+// after the shown ones
+func moreButton(question string, shown, more int) slack.Attachment {
+	if len(question) > maxMoreValue {
+		i := maxMoreValue
+		for i > 0 && !utf8.RuneStart(question[i]) {
+			i-- // don't cut a letter
+		}
+		question = question[:i]
+		if i := strings.LastIndex(question, " "); i > 0 {
+			question = question[:i] // don't cut a word
+		}
+	}
+	return slack.Attachment{
+		CallbackID: fmt.Sprintf("%s:%d", callbackMore, shown),
+		Actions: []slack.AttachmentAction{
+			{Name: callbackMore, Text: moreText(more), Type: "button", Value: question},
+		},
+	}
+}
+
+// handleMore adds the components matching the question after the shown ones to the
+// message when Show more is clicked
+func handleMore(cb slack.InteractionCallback, arg string) string {
+	shown, err := strconv.Atoi(arg)
+	actions := cb.ActionCallback.AttachmentActions
+	if err != nil || shown < 0 || len(actions) == 0 {
+		return unexpectedError
+	}
+	matches := rankMatches(matchTags(queryWords(actions[0].Value), matcher, cache), cache)
+	text := cb.OriginalMessage.Text // slack leaves it out for ephemeral messages
+	if len(matches) > shown {
+		if text != "" {
+			text += "\n"
+		}
+		text += matchesFmt(matches[shown:])
+	}
+	if text == "" {
+		return noRelevantTag
+	}
+	return text
+}
@@ -0,0 +1,153 @@
+/*
+Tests for ranking the tags matched in a question.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/nlopes/slack"
+)
+
+func TestRankMatches(t *testing.T) {
+	tags := newTestCache(t, map[string][]string{
+		"kafka":    {"C1"},
+		"postgres": {"C2"},
+		"big data": {"C3"},
+		"hadoop":   {"C3"},
+	})
+	tests := []struct {
+		name       string
+		candidates []Candidate
+		chans      []string
+		words      [][]string
+	}{
+		{
+			"exact beats fuzzy of more words",
+			[]Candidate{
+				{"big data", "big dta platform", 0.9, matchFuzzy},
+				{"kafka", "kafka", 1, matchExact},
+			},
+			[]string{"C1", "C3"},
+			[][]string{{"kafka"}, {"big dta platform"}},
+		},
+		{
+			"alias beats fuzzy of a higher score",
+			[]Candidate{
+				{"kafka", "kafak", 0.97, matchFuzzy},
+				{"postgres", "pg", 0.95, matchAlias},
+			},
+			[]string{"C2", "C1"},
+			[][]string{{"pg"}, {"kafak"}},
+		},
+		{
+			"more words break a tie",
+			[]Candidate{
+				{"kafka", "kafka", 1, matchExact},
+				{"big data", "big data", 1, matchExact},
+			},
+			[]string{"C3", "C1"},
+			[][]string{{"big data"}, {"kafka"}},
+		},
+		{
+			"a component is shown once",
+			[]Candidate{
+				{"hadoop", "hadoop", 1, matchExact},
+				{"big data", "big data", 1, matchExact},
+				{"big data", "data", 1, matchFullText},
+			},
+			[]string{"C3"},
+			[][]string{{"big data", "hadoop", "data"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var chans []string
+			var words [][]string
+			for _, m := range rankMatches(tt.candidates, tags) {
+				chans = append(chans, m.tag().ComponentChan)
+				words = append(words, m.Words)
+			}
+			if !reflect.DeepEqual(chans, tt.chans) || !reflect.DeepEqual(words, tt.words) {
+				t.Errorf("rankMatches = %v %q, want %v %q", chans, words, tt.chans, tt.words)
+			}
+		})
+	}
+}
+
+func TestHandleMore(t *testing.T) {
+	newTestCache(t, map[string][]string{
+		"alpha":   {"C1"},
+		"beta":    {"C2"},
+		"gamma":   {"C3"},
+		"delta":   {"C4"},
+		"epsilon": {"C5"},
+	})
+	matcher = mustMatcher(defaultMatchers)
+	question := "alpha beta gamma delta epsilon"
+	matches := tagMatch(append([]string{"<@B>"}, queryWords(question)...))
+	if len(matches) != 5 {
+		t.Fatalf("%d matches, want 5", len(matches))
+	}
+
+	click := func(arg, text string) string {
+		cb := slack.InteractionCallback{
+			OriginalMessage: slack.Message{Msg: slack.Msg{Text: text}},
+			ActionCallback: slack.ActionCallbacks{
+				AttachmentActions: []*slack.AttachmentAction{{Name: callbackMore, Value: question}},
+			},
+		}
+		return handleMore(cb, arg)
+	}
+	tests := []struct {
+		name string
+		arg  string
+		text string
+		want string
+	}{
+		{"added below", "3", "first three", "first three\n" + matchesFmt(matches[3:])},
+		{"ephemeral", "3", "", matchesFmt(matches[3:])},
+		{"none left", "5", "first five", "first five"},
+		{"none at all", "5", "", noRelevantTag},
+		{"no offset", "", "first three", unexpectedError},
+		{"negative offset", "-1", "first three", unexpectedError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := click(tt.arg, tt.text); got != tt.want {
+				t.Errorf("handleMore(%q) = %q, want %q", tt.arg, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoreButton(t *testing.T) {
+	tests := []struct {
+		name     string
+		question string
+		want     string
+	}{
+		{"short", "is kafka down", "is kafka down"},
+		{"cut at a word", strings.Repeat("kafka ", 400), strings.TrimSpace(strings.Repeat("kafka ", 333))},
+		{"cut at a letter", strings.Repeat("é", 1500), strings.Repeat("é", 1000)},
+		{"cut before a letter", "x" + strings.Repeat("é", 1500), "x" + strings.Repeat("é", 999)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := moreButton(tt.question, 3, 2)
+			v := b.Actions[0].Value
+			if v != tt.want || len(v) > maxMoreValue || !utf8.ValidString(v) {
+				t.Errorf("moreButton value is %d bytes, want %d", len(v), len(tt.want))
+			}
+			if b.CallbackID != callbackMore+":3" {
+				t.Errorf("moreButton callback ID = %q", b.CallbackID)
+			}
+		})
+	}
+}
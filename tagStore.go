@@ -0,0 +1,273 @@
+/*
+tagStore.go defines the storage used behind the tag cache.
+
+The TagStore interface covers tags and their aliases, components, the associations
+between them, component anchors, playbooks and component info. The Store interface adds
+everything else the bot keeps, like the audit trail, permissions, tag proposals, anchor
+rotations, absences and playbook link checks. Errors from losing the connection to the
+underlying storage are returned as a DBError; the ErrNo* errors are returned for expected
+conditions. GormStore (see dbopts.go) is the database backed implementation; MemStore
+keeps everything in memory, which is useful for running the cache and parser without a
+database.
+
+Released under MIT license, copyright 2018 Tyler Ramer
+*/
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Store is everything the bot keeps in its storage backend
+type Store interface {
+	TagStore
+	AuditLog
+	PermissionStore
+	ProposalStore
+	ScheduleStore
+	AwayStore
+	LinkStore
+}
+
+// TagStore is the backing storage for the TagCache
+type TagStore interface {
+	// QueryTag returns the TagInfo for every component associated with tag n. If n is
+	// an alias, the TagInfo of its canonical tag are returned
+	QueryTag(n string) ([]TagInfo, error)
+	// GetAllTags returns every tag and alias in the store, and the number of tags
+	GetAllTags() (map[string][]TagInfo, int, error)
+	// AddTag associates a tag with the component channel in the TagInfo
+	AddTag(t TagInfo) error
+	// DropTag removes a tag, its aliases and all of its associations
+	DropTag(t string) error
+	// RemoveTag removes the association between a tag and one component, deleting
+	// the tag and its aliases once no components are left
+	RemoveTag(t TagInfo) error
+	// AddComponent adds a new component
+	AddComponent(c Component) error
+	// GetAnchor returns the component registered for a component channel
+	GetAnchor(componentChan string) (Component, error)
+	// ChangeAnchor sets the primary anchor of a component
+	ChangeAnchor(componentChan, newAnchor string) error
+	// AddComponentAnchor adds an anchor to a component, returning ErrAnchorExists
+	// if the user already anchors it
+	AddComponentAnchor(a ComponentAnchor) error
+	// RemoveComponentAnchor removes an anchor from a component, returning ErrNoAnchor
+	// if the user does not anchor it
+	RemoveComponentAnchor(componentChan, slackID string) error
+	// ChangePlaybook sets the URL of a component's default playbook, adding it if
+	// needed. An empty URL removes it
+	ChangePlaybook(componentChan, newURL string) error
+	// AddPlaybook adds a playbook to a component, returning ErrPlaybookExists if the
+	// component has a playbook with the same title
+	AddPlaybook(p Playbook) error
+	// RemovePlaybook removes the playbook with the title from a component, returning
+	// ErrNoPlaybook if there is none
+	RemovePlaybook(componentChan, title string) error
+	// ChangeInfo sets the name, description or area of a component
+	ChangeInfo(componentChan, field, value string) error
+	// SetLink adds a link to a component, replacing any link with the same label
+	SetLink(l ComponentLink) error
+	// RemoveLink removes the link with the label from a component, returning
+	// ErrNoLink if there is none
+	RemoveLink(componentChan, label string) error
+	// AddAlias adds an alias to an existing tag, returning ErrAliasTaken if the alias
+	// is already a tag or an alias
+	AddAlias(a TagAlias) error
+	// RemoveAlias removes an alias, returning ErrNoAlias if there is none by the name
+	RemoveAlias(name string) error
+}
+
+// MemStore is an in-memory TagStore. Nothing is persisted
+type MemStore struct {
+	sync.Mutex
+	components map[string]Component // keyed by component channel
+	tags       map[string][]string  // tag name to component channels
+	aliases    map[string]string    // alias to tag name
+	nextID     int
+	changes    []Change
+	roles      []Role
+	proposals  []Proposal
+	anchors    []ComponentAnchor
+	rotations  map[string]Rotation // keyed by component channel
+	overrides  []AnchorOverride
+	absences   []Absence
+	links      []ComponentLink
+	playbooks  []Playbook
+}
+
+// NewMemStore returns an empty MemStore
+func NewMemStore() *MemStore {
+	return &MemStore{
+		components: make(map[string]Component),
+		tags:       make(map[string][]string),
+		aliases:    make(map[string]string),
+		rotations:  make(map[string]Rotation),
+		nextID:     1,
+	}
+}
+
+// QueryTag returns the TagInfo for every component associated with tag n. If n is
+// an alias, the TagInfo of its canonical tag are returned
+func (s *MemStore) QueryTag(n string) ([]TagInfo, error) {
+	s.Lock()
+	defer s.Unlock()
+	if tag, ok := s.aliases[n]; ok {
+		n = tag
+	}
+	chans, ok := s.tags[n]
+	if !ok {
+		return nil, ErrNoTag
+	}
+	return s.tagInfo(n, chans), nil
+}
+
+// GetAllTags returns every tag and alias in the store, and the number of tags
+func (s *MemStore) GetAllTags() (map[string][]TagInfo, int, error) {
+	s.Lock()
+	defer s.Unlock()
+	tagMap := make(map[string][]TagInfo)
+	for n, chans := range s.tags {
+		tagMap[n] = s.tagInfo(n, chans)
+	}
+	for alias, tag := range s.aliases {
+		tagMap[alias] = tagMap[tag]
+	}
+	return tagMap, len(s.tags), nil
+}
+
+func (s *MemStore) tagInfo(n string, chans []string) (retTags []TagInfo) {
+	for _, ch := range chans {
+		c := s.components[ch]
+		s.fill(&c)
+		t := newTagInfo(n, c)
+		t.Aliases = s.tagAliases(n)
+		retTags = append(retTags, t)
+	}
+	return
+}
+
+// AddTag associates a tag with the component channel in the TagInfo
+func (s *MemStore) AddTag(t TagInfo) error {
+	if len(t.Name) > MAX_TAG_LENGTH {
+		return ErrTagTooLong
+	}
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.components[t.ComponentChan]; !ok {
+		return ErrNoComponent
+	}
+	for _, ch := range s.tags[t.Name] {
+		if ch == t.ComponentChan {
+			return nil
+		}
+	}
+	s.tags[t.Name] = append(s.tags[t.Name], t.ComponentChan)
+	return nil
+}
+
+// DropTag removes a tag, its aliases and all of its associations
+func (s *MemStore) DropTag(t string) error {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.tags[t]; !ok {
+		return ErrNoTag
+	}
+	delete(s.tags, t)
+	s.dropAliases(t)
+	return nil
+}
+
+// RemoveTag removes the association between a tag and one component, deleting
+// the tag and its aliases once no components are left
+func (s *MemStore) RemoveTag(t TagInfo) error {
+	s.Lock()
+	defer s.Unlock()
+	chans, ok := s.tags[t.Name]
+	if !ok {
+		return ErrNoTag
+	}
+	if _, ok := s.components[t.ComponentChan]; !ok {
+		return ErrNoComponent
+	}
+	for i, ch := range chans {
+		if ch == t.ComponentChan {
+			chans = append(chans[:i:i], chans[i+1:]...)
+			if len(chans) == 0 {
+				delete(s.tags, t.Name)
+				s.dropAliases(t.Name)
+			} else {
+				s.tags[t.Name] = chans
+			}
+			return nil
+		}
+	}
+	return ErrTagNotOnComponent
+}
+
+// AddComponent adds a new component
+func (s *MemStore) AddComponent(c Component) error {
+	for _, p := range c.Playbooks {
+		if err := checkPlaybook(p); err != nil {
+			return err
+		}
+	}
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.components[c.ComponentChan]; ok {
+		return ErrComponentExists
+	}
+	c.ID = s.nextID
+	s.nextID++
+	for _, p := range c.Playbooks {
+		p.ID = s.nextID
+		s.nextID++
+		p.ComponentChan = c.ComponentChan
+		s.playbooks = append(s.playbooks, p)
+	}
+	c.Playbooks = nil
+	s.components[c.ComponentChan] = c
+	return nil
+}
+
+// GetAnchor returns the component registered for a component channel
+func (s *MemStore) GetAnchor(componentChan string) (Component, error) {
+	s.Lock()
+	defer s.Unlock()
+	c, ok := s.components[componentChan]
+	if !ok {
+		return c, ErrNoComponent
+	}
+	s.fill(&c)
+	return c, nil
+}
+
+// fill sets what is stored about the component outside of s.components
+func (s *MemStore) fill(c *Component) {
+	c.Anchors = s.componentAnchors(c.ComponentChan)
+	c.Rotation, c.Overrides = s.schedule(c.ComponentChan)
+	var active []Absence
+	for _, a := range s.absences {
+		if time.Now().Before(a.Until) {
+			active = append(active, a)
+		}
+	}
+	c.Away = latestAbsences(active, c.anchorIDs())
+	c.Links = s.componentLinks(c.ComponentChan)
+	c.Playbooks = s.componentPlaybooks(c.ComponentChan)
+}
+
+// ChangeAnchor sets the primary anchor of a component
+func (s *MemStore) ChangeAnchor(componentChan, newAnchor string) error {
+	s.Lock()
+	defer s.Unlock()
+	c, ok := s.components[componentChan]
+	if !ok {
+		return ErrNoComponent
+	}
+	c.AnchorSlackID = newAnchor
+	s.components[componentChan] = c
+	return nil
+}